@@ -1,23 +1,16 @@
 package main
 
 import (
-	"fmt"
-	"os/exec"
+	"context"
 
+	"github.com/fly-examples/postgres-ha/pkg/commands"
 	"github.com/fly-examples/postgres-ha/pkg/util"
 )
 
 func main() {
-	args := []string{"stolon", "pg_ctl", "-D", "/data/postgres", "restart"}
-	subProcess := exec.Command("gosu", args...)
-
-	if err := subProcess.Run(); err != nil {
+	if err := commands.Call(context.Background(), "restart", nil, nil); err != nil {
 		util.WriteError(err)
 	}
 
-	if subProcess.ProcessState.ExitCode() != 0 {
-		util.WriteError(fmt.Errorf(subProcess.ProcessState.String()))
-	}
-
 	util.WriteOutput("Restart completed successfully", "")
 }
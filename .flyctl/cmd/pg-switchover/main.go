@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/fly-examples/postgres-ha/pkg/commands"
+	"github.com/fly-examples/postgres-ha/pkg/util"
+)
+
+// Expects a base64 encoded json request, e.g. {"keeper_uid": "...", "max_lag_bytes": 0}
+// or {"region": "ord"}.
+func main() {
+	if len(os.Args) < 2 {
+		util.WriteError(fmt.Errorf("a switchover request is required"))
+	}
+
+	input, err := base64.StdEncoding.DecodeString(os.Args[1])
+	if err != nil {
+		util.WriteError(err)
+	}
+
+	var result json.RawMessage
+	if err := commands.Call(context.Background(), "switchover", input, &result); err != nil {
+		util.WriteError(err)
+	}
+
+	util.WriteOutput("Switchover completed successfully", string(result))
+}
@@ -1,38 +1,37 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"os"
 
-	"encoding/base64"
-
-	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/fly-examples/postgres-ha/pkg/commands"
 	"github.com/fly-examples/postgres-ha/pkg/util"
-	"github.com/google/shlex"
 )
 
+// Expects base64 encoded stolonctl arguments. Unlike the other commands this
+// one isn't served over http, so it runs in process.
 func main() {
-	env, err := util.BuildEnv()
-	if err != nil {
-		util.WriteError(err)
+	if len(os.Args) < 2 {
+		util.WriteError(fmt.Errorf("a stolonctl command is required"))
 	}
 
-	encodedArg := os.Args[1]
-
-	argBytes, err := base64.StdEncoding.DecodeString(encodedArg)
+	argBytes, err := base64.StdEncoding.DecodeString(os.Args[1])
 	if err != nil {
 		util.WriteError(err)
 	}
 
-	args, err := shlex.Split(string(argBytes))
+	input, err := json.Marshal(map[string]string{"args": string(argBytes)})
 	if err != nil {
-		util.WriteError(fmt.Errorf("error parsing argument: %w", err))
+		util.WriteError(err)
 	}
 
-	result, err := stolon.Ctl(args, env)
+	result, err := commands.Exec(context.Background(), "stolonctl-run", input)
 	if err != nil {
 		util.WriteError(err)
 	}
 
-	util.WriteOutput("Command completed successfully", string(result))
+	util.WriteOutput("Command completed successfully", result.(string))
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	_ "embed"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -16,11 +17,17 @@ import (
 
 	"github.com/fly-examples/postgres-ha/pkg/flypg"
 	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
 	"github.com/fly-examples/postgres-ha/pkg/flyunlock"
+	"github.com/fly-examples/postgres-ha/pkg/server"
 	"github.com/fly-examples/postgres-ha/pkg/supervisor"
 	"github.com/jackc/pgx/v4"
 )
 
+// logger writes into the supervisor's output, so these lines are formatted
+// and kept alongside process output.
+var logger *supervisor.Logger
+
 func main() {
 
 	if os.Getenv("FLY_RESTORED_FROM") != "" {
@@ -60,11 +67,17 @@ func main() {
 		panic(err)
 	}
 
-	stolonCmd := func(cmd string) string {
-		return "gosu stolon " + cmd
+	svisor := supervisor.New("flypg", 5*time.Minute)
+
+	logFormat, err := supervisor.ParseLogFormat(os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		panic(err)
 	}
+	svisor.SetLogFormat(logFormat)
+
+	logger = svisor.Logger("start")
 
-	cfg, err := flypg.InitConfig("/fly/cluster-spec.json")
+	cfg, err := flypg.InitConfig("/fly/cluster-spec.json", svisor.Logger("config"))
 	if err != nil {
 		panic(err)
 	}
@@ -74,10 +87,17 @@ func main() {
 		defer t.Stop()
 
 		for range t.C {
-			fmt.Println("checking stolon status")
+			logger.Println("checking stolon status")
 
-			cd, err := node.GetStolonClusterData()
-			if err != nil && !errors.Is(err, flypg.ErrClusterNotInitialized) {
+			cd, err := node.GetStolonClusterData(context.TODO())
+			if err != nil {
+				if errors.Is(err, flypg.ErrClusterNotInitialized) {
+					continue
+				}
+				if errors.Is(err, stolon.ErrStoreUnreachable) {
+					logger.Error(err)
+					continue
+				}
 				panic(err)
 			}
 
@@ -91,23 +111,23 @@ func main() {
 			}
 
 			if currentKeeper.Status.Healthy && currentDB.Status.Healthy {
-				fmt.Println("keeper is healthy, db is healthy, role:", currentDB.Spec.Role)
+				logger.Println("keeper is healthy, db is healthy, role:", currentDB.Spec.Role)
 				if currentDB.Spec.Role == "master" {
 					pg, err := node.NewLocalConnection(context.TODO())
 					if err != nil {
-						fmt.Println("error connecting to local postgres", err)
+						logger.Error("error connecting to local postgres", err)
 						continue
 					}
 
 					if err = initOperator(context.TODO(), pg, node.OperatorCredentials); err != nil {
-						fmt.Println("error configuring operator user:", err)
+						logger.Error("error configuring operator user:", err)
 						continue
 					}
 
 					// Stolon handles replUser creation during initial bootstrap.
 					if cfg.InitMode == flypg.InitModeExisting {
 						if err = initReplicationUser(context.TODO(), pg, node.ReplCredentials); err != nil {
-							fmt.Println("error configuring replication user:", err)
+							logger.Error("error configuring replication user:", err)
 							continue
 						}
 					}
@@ -119,70 +139,68 @@ func main() {
 		}
 	}()
 
-	svisor := supervisor.New("flypg", 5*time.Minute)
-
-	keeperEnv := map[string]string{
-		"STKEEPER_UID":               node.KeeperUID,
-		"STKEEPER_DATA_DIR":          node.DataDir,
-		"STKEEPER_PG_SU_USERNAME":    node.SUCredentials.Username,
-		"STKEEPER_PG_SU_PASSWORD":    node.SUCredentials.Password,
-		"STKEEPER_PG_REPL_USERNAME":  node.ReplCredentials.Username,
-		"STKEEPER_PG_REPL_PASSWORD":  node.ReplCredentials.Password,
-		"STKEEPER_PG_LISTEN_ADDRESS": node.PrivateIP.String(),
-		"STKEEPER_PG_PORT":           strconv.Itoa(node.PGPort),
-		"STKEEPER_LOG_LEVEL":         "warn",
-		"STKEEPER_CLUSTER_NAME":      node.AppName,
-		"STKEEPER_STORE_BACKEND":     node.BackendStore,
-		"STKEEPER_STORE_URL":         node.BackendStoreURL.String(),
-		"STKEEPER_STORE_NODE":        node.StoreNode,
+	manifest, err := loadManifest(node)
+	if err != nil {
+		panic(err)
 	}
+	svisor.AddManifest(manifest)
 
-	if !node.IsPrimaryRegion() {
-		keeperEnv["STKEEPER_CAN_BE_MASTER"] = "false"
-		keeperEnv["STKEEPER_CAN_BE_SYNCHRONOUS_REPLICA"] = "false"
-	}
+	svisor.StopOnSignal(syscall.SIGINT, syscall.SIGTERM)
 
-	svisor.AddProcess("keeper", stolonCmd("stolon-keeper"), supervisor.WithEnv(keeperEnv), supervisor.WithRestart(5, 5*time.Second))
+	go server.StartHttpServer(svisor)
 
-	sentinelEnv := map[string]string{
-		"STSENTINEL_DATA_DIR":             node.DataDir,
-		"STSENTINEL_INITIAL_CLUSTER_SPEC": "/fly/cluster-spec.json",
-		"STSENTINEL_LOG_LEVEL":            "warn",
-		"STSENTINEL_CLUSTER_NAME":         node.AppName,
-		"STSENTINEL_STORE_BACKEND":        node.BackendStore,
-		"STSENTINEL_STORE_URL":            node.BackendStoreURL.String(),
-		"STSENTINEL_STORE_NODE":           node.StoreNode,
+	err = svisor.Run()
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
 	}
+}
 
-	svisor.AddProcess("sentinel", stolonCmd("stolon-sentinel"), supervisor.WithEnv(sentinelEnv), supervisor.WithRestart(0, 3*time.Second))
+//go:embed processes.yml
+var defaultManifest []byte
 
-	proxyEnv := map[string]string{
-		"FLY_APP_NAME":      os.Getenv("FLY_APP_NAME"),
-		"PRIMARY_REGION":    os.Getenv("PRIMARY_REGION"),
-		"PG_LISTEN_ADDRESS": node.PrivateIP.String(),
-	}
-	svisor.AddProcess("proxy", "/usr/sbin/haproxy -W -db -f /fly/haproxy.cfg", supervisor.WithEnv(proxyEnv), supervisor.WithRestart(0, 1*time.Second))
+// loadManifest loads the built in process manifest, merged with the one at
+// SUPERVISOR_MANIFEST if set.
+func loadManifest(node *flypg.Node) (*supervisor.Manifest, error) {
+	vars := manifestVars(node)
 
-	exporterEnv := map[string]string{
-		"DATA_SOURCE_URI":                      fmt.Sprintf("[%s]:%d/postgres?sslmode=disable", node.PrivateIP, node.PGPort),
-		"DATA_SOURCE_USER":                     node.SUCredentials.Username,
-		"DATA_SOURCE_PASS":                     node.SUCredentials.Password,
-		"PG_EXPORTER_EXCLUDE_DATABASE":         "template0,template1",
-		"PG_EXPORTER_DISABLE_SETTINGS_METRICS": "true",
-		"PG_EXPORTER_AUTO_DISCOVER_DATABASES":  "true",
-		"PG_EXPORTER_EXTEND_QUERY_PATH":        "/fly/queries.yaml",
+	manifest, err := supervisor.LoadManifest(defaultManifest, vars)
+	if err != nil {
+		return nil, err
 	}
 
-	svisor.AddProcess("exporter", "postgres_exporter", supervisor.WithEnv(exporterEnv), supervisor.WithRestart(0, 1*time.Second))
-
-	svisor.StopOnSignal(syscall.SIGINT, syscall.SIGTERM)
+	if path := os.Getenv("SUPERVISOR_MANIFEST"); path != "" {
+		custom, err := supervisor.LoadManifestFile(path, vars)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Merge(custom)
+	}
 
-	svisor.StartHttpListener()
+	return manifest, nil
+}
 
-	err = svisor.Run()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// manifestVars are the node settings available to process manifests.
+func manifestVars(node *flypg.Node) map[string]string {
+	canBeMaster := "true"
+	if !node.IsPrimaryRegion() {
+		canBeMaster = "false"
+	}
+
+	return map[string]string{
+		"KEEPER_UID":    node.KeeperUID,
+		"DATA_DIR":      node.DataDir,
+		"SU_USERNAME":   node.SUCredentials.Username,
+		"SU_PASSWORD":   node.SUCredentials.Password,
+		"REPL_USERNAME": node.ReplCredentials.Username,
+		"REPL_PASSWORD": node.ReplCredentials.Password,
+		"PRIVATE_IP":    node.PrivateIP.String(),
+		"PG_PORT":       strconv.Itoa(node.PGPort),
+		"APP_NAME":      node.AppName,
+		"STORE_BACKEND": node.BackendStore,
+		"STORE_URL":     node.BackendStoreURL.String(),
+		"STORE_NODE":    node.StoreNode,
+		"CAN_BE_MASTER": canBeMaster,
 	}
 }
 
@@ -197,10 +215,10 @@ func writeStolonctlEnvFile(n *flypg.Node, filename string) {
 }
 
 func initOperator(ctx context.Context, pg *pgx.Conn, creds flypg.Credentials) error {
-	fmt.Println("configuring operator")
+	logger.Println("configuring operator")
 
 	if creds.Password == "" {
-		fmt.Println("OPERATOR_PASSWORD not set, cannot configure operator")
+		logger.Println("OPERATOR_PASSWORD not set, cannot configure operator")
 		return nil
 	}
 
@@ -219,7 +237,7 @@ func initOperator(ctx context.Context, pg *pgx.Conn, creds flypg.Credentials) er
 	}
 
 	if operatorUser == nil {
-		fmt.Println("operator user does not exist, creating")
+		logger.Println("operator user does not exist, creating")
 		err = admin.CreateUser(ctx, pg, creds.Username, creds.Password)
 		if err != nil {
 			return err
@@ -235,29 +253,29 @@ func initOperator(ctx context.Context, pg *pgx.Conn, creds flypg.Credentials) er
 	}
 
 	if !operatorUser.SuperUser {
-		fmt.Println("operator is not a superuser, fixing")
+		logger.Println("operator is not a superuser, fixing")
 		if err := admin.GrantSuperuser(ctx, pg, creds.Username); err != nil {
 			return err
 		}
 	}
 
 	if !operatorUser.IsPassword(creds.Password) {
-		fmt.Println("operator password does not match config, changing")
+		logger.Println("operator password does not match config, changing")
 		if err := admin.ChangePassword(ctx, pg, creds.Username, creds.Password); err != nil {
 			return err
 		}
 	}
 
-	fmt.Println("operator ready!")
+	logger.Println("operator ready!")
 
 	return nil
 }
 
 func initReplicationUser(ctx context.Context, pg *pgx.Conn, creds flypg.Credentials) error {
-	fmt.Println("configuring repluser")
+	logger.Println("configuring repluser")
 
 	if creds.Password == "" {
-		fmt.Println("REPL_PASSWORD not set, cannot configure operator")
+		logger.Println("REPL_PASSWORD not set, cannot configure operator")
 		return nil
 	}
 
@@ -276,7 +294,7 @@ func initReplicationUser(ctx context.Context, pg *pgx.Conn, creds flypg.Credenti
 	}
 
 	if replUser == nil {
-		fmt.Println("repl user does not exist, creating")
+		logger.Println("repl user does not exist, creating")
 		err = admin.CreateUser(ctx, pg, creds.Username, creds.Password)
 		if err != nil {
 			return err
@@ -292,20 +310,20 @@ func initReplicationUser(ctx context.Context, pg *pgx.Conn, creds flypg.Credenti
 	}
 
 	if !replUser.ReplUser {
-		fmt.Println("repluser does not have REPLICATION role, fixing")
+		logger.Println("repluser does not have REPLICATION role, fixing")
 		if err := admin.GrantReplication(ctx, pg, creds.Username); err != nil {
 			return err
 		}
 	}
 
 	if !replUser.IsPassword(creds.Password) {
-		fmt.Println("repluser password does not match config, changing")
+		logger.Println("repluser password does not match config, changing")
 		if err := admin.ChangePassword(ctx, pg, creds.Username, creds.Password); err != nil {
 			return err
 		}
 	}
 
-	fmt.Println("replication ready!")
+	logger.Println("replication ready!")
 
 	return nil
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testNode(region string) *flypg.Node {
+	return &flypg.Node{
+		AppName:         "app",
+		PrivateIP:       net.ParseIP("fdaa::2"),
+		Region:          region,
+		PrimaryRegion:   "ord",
+		DataDir:         "/data",
+		SUCredentials:   flypg.Credentials{Username: "flypgadmin", Password: "su$pass"},
+		ReplCredentials: flypg.Credentials{Username: "repluser", Password: "repl"},
+		BackendStore:    "consul",
+		BackendStoreURL: &url.URL{Scheme: "https", Host: "consul"},
+		KeeperUID:       "abc",
+		StoreNode:       "app/",
+		PGPort:          5433,
+	}
+}
+
+func TestDefaultManifest(t *testing.T) {
+	manifest, err := loadManifest(testNode("ord"))
+	require.NoError(t, err)
+
+	var names []string
+	for _, proc := range manifest.Processes {
+		names = append(names, proc.Name)
+	}
+	assert.Equal(t, []string{"keeper", "sentinel", "exporter", "proxy"}, names)
+
+	keeper := manifest.Processes[0]
+	assert.Equal(t, "abc", keeper.Env["STKEEPER_UID"])
+	assert.Equal(t, "su$pass", keeper.Env["STKEEPER_PG_SU_PASSWORD"])
+	assert.Equal(t, "true", keeper.Env["STKEEPER_CAN_BE_MASTER"])
+	assert.Equal(t, "[fdaa::2]:5433", keeper.Readiness.TCP)
+
+	replica, err := loadManifest(testNode("syd"))
+	require.NoError(t, err)
+	assert.Equal(t, "false", replica.Processes[0].Env["STKEEPER_CAN_BE_MASTER"])
+}
+
+func TestCustomManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "processes.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+processes:
+  - name: pgbouncer
+    command: pgbouncer /fly/pgbouncer.ini
+    depends_on: [keeper]
+    restart: {}
+`), 0644))
+
+	os.Setenv("SUPERVISOR_MANIFEST", path)
+	defer os.Unsetenv("SUPERVISOR_MANIFEST")
+
+	manifest, err := loadManifest(testNode("ord"))
+	require.NoError(t, err)
+	require.Len(t, manifest.Processes, 5)
+	assert.Equal(t, "pgbouncer", manifest.Processes[4].Name)
+}
@@ -0,0 +1,432 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeAdmin Scope = "admin"
+)
+
+const (
+	HeaderKeyID     = "X-Flypg-Key"
+	HeaderTimestamp = "X-Flypg-Timestamp"
+	HeaderSignature = "X-Flypg-Signature"
+	HeaderNonce     = "X-Flypg-Nonce"
+
+	// Signed requests older or newer than this are rejected.
+	MaxClockSkew = 5 * time.Minute
+
+	// MaxBodySize is the largest request body commands accept.
+	MaxBodySize = 1 << 20
+)
+
+// Key is an api key. Admin keys can call every endpoint, read keys only the
+// ones that don't change anything.
+type Key struct {
+	ID     string
+	Scope  Scope
+	Secret string
+}
+
+func (k Key) allows(scope Scope) bool {
+	return k.Scope == ScopeAdmin || k.Scope == scope
+}
+
+type callerKey struct{}
+
+// Caller returns the id of the key that authenticated the request.
+func Caller(ctx context.Context) string {
+	if id, ok := ctx.Value(callerKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// Authenticator checks requests against a set of api keys and writes every
+// decision to an audit log.
+type Authenticator struct {
+	keys []Key
+	// open is only set by Disabled, every request is allowed.
+	open bool
+
+	mu    sync.Mutex
+	audit io.Writer
+	now   func() time.Time
+	// nonces are the signed requests seen within the clock skew window,
+	// keyed by key id and nonce, so they can't be replayed.
+	nonces map[string]time.Time
+}
+
+// New returns an authenticator for keys. Without keys every request is
+// denied.
+func New(keys []Key, audit io.Writer) *Authenticator {
+	return &Authenticator{
+		keys:   keys,
+		audit:  audit,
+		now:    time.Now,
+		nonces: map[string]time.Time{},
+	}
+}
+
+// Disabled returns an authenticator that allows every request.
+func Disabled(audit io.Writer) *Authenticator {
+	a := New(nil, audit)
+	a.open = true
+	return a
+}
+
+// disabledFromEnv reports whether FLYPG_AUTH_DISABLED opts out of
+// authentication.
+func disabledFromEnv() bool {
+	disabled, _ := strconv.ParseBool(os.Getenv("FLYPG_AUTH_DISABLED"))
+	return disabled
+}
+
+// FromEnv loads keys from ADMIN_API_KEYS and the file named by
+// ADMIN_API_KEYS_FILE. Both use "id:scope:secret" entries, comma or newline
+// separated. The audit log is appended to ADMIN_API_AUDIT_LOG, or written to
+// stdout. If the keys can't be loaded, or none are configured, the returned
+// authenticator denies every request. Setting FLYPG_AUTH_DISABLED=1 allows
+// every request instead.
+func FromEnv() (*Authenticator, error) {
+	var audit io.Writer = os.Stdout
+	if path := os.Getenv("ADMIN_API_AUDIT_LOG"); path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return New(nil, audit), fmt.Errorf("error opening audit log: %w", err)
+		}
+		audit = f
+	}
+
+	if disabledFromEnv() {
+		return Disabled(audit), nil
+	}
+
+	keys, err := LoadKeys()
+	if err != nil {
+		return New(nil, audit), err
+	}
+
+	return New(keys, audit), nil
+}
+
+// LoadKeys returns the keys configured through ADMIN_API_KEYS and
+// ADMIN_API_KEYS_FILE.
+func LoadKeys() ([]Key, error) {
+	keys, err := ParseKeys(os.Getenv("ADMIN_API_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+
+	if path := os.Getenv("ADMIN_API_KEYS_FILE"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading api keys file: %w", err)
+		}
+		fileKeys, err := ParseKeys(string(data))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+
+	return keys, nil
+}
+
+// ClientKey returns a configured key that can call endpoints requiring
+// scope, or nil if authentication is disabled.
+func ClientKey(scope Scope) (*Key, error) {
+	if disabledFromEnv() {
+		return nil, nil
+	}
+
+	keys, err := LoadKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if key.allows(scope) {
+			return &key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no api key with %s scope is configured", scope)
+}
+
+// ParseKeys parses "id:scope:secret" entries separated by commas or newlines.
+func ParseKeys(raw string) ([]Key, error) {
+	var keys []Key
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(raw, ",", "\n")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid api key entry, expected id:scope:secret")
+		}
+
+		scope := Scope(parts[1])
+		if scope != ScopeRead && scope != ScopeAdmin {
+			return nil, fmt.Errorf("invalid scope %q for api key %s", parts[1], parts[0])
+		}
+
+		keys = append(keys, Key{ID: parts[0], Scope: scope, Secret: parts[2]})
+	}
+
+	return keys, scanner.Err()
+}
+
+// Open reports whether authentication is disabled.
+func (a *Authenticator) Open() bool {
+	return a.open
+}
+
+// Locked reports whether every request is denied because no keys are
+// configured.
+func (a *Authenticator) Locked() bool {
+	return !a.open && len(a.keys) == 0
+}
+
+// Require returns a middleware that only lets requests authenticated with a
+// key for the given scope through.
+func (a *Authenticator) Require(scope Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+			}
+
+			key, err := a.authenticate(r)
+
+			entry := auditEntry{
+				Time:   a.now().UTC(),
+				Method: r.Method,
+				Path:   r.URL.Path,
+				Remote: r.RemoteAddr,
+				Scope:  scope,
+			}
+
+			switch {
+			case a.open:
+				entry.Allowed = true
+				entry.Reason = "authentication disabled"
+			case err != nil:
+				entry.Reason = err.Error()
+			case !key.allows(scope):
+				entry.Caller = key.ID
+				entry.Reason = fmt.Sprintf("key scope %q does not allow %q", key.Scope, scope)
+			default:
+				entry.Caller = key.ID
+				entry.Allowed = true
+			}
+
+			a.log(entry)
+
+			if !entry.Allowed {
+				status := http.StatusUnauthorized
+				if err == nil {
+					status = http.StatusForbidden
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				json.NewEncoder(w).Encode(map[string]string{"error": entry.Reason})
+				return
+			}
+
+			if entry.Caller != "" {
+				r = r.WithContext(context.WithValue(r.Context(), callerKey{}, entry.Caller))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var errMissingCredentials = errors.New("missing credentials")
+
+func (a *Authenticator) authenticate(r *http.Request) (*Key, error) {
+	if len(a.keys) == 0 {
+		return nil, errors.New("no api keys are configured")
+	}
+
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		for i := range a.keys {
+			if subtle.ConstantTimeCompare([]byte(a.keys[i].Secret), []byte(token)) == 1 {
+				return &a.keys[i], nil
+			}
+		}
+		return nil, errors.New("invalid bearer token")
+	}
+
+	id := r.Header.Get(HeaderKeyID)
+	if id == "" {
+		return nil, errMissingCredentials
+	}
+
+	var key *Key
+	for i := range a.keys {
+		if a.keys[i].ID == id {
+			key = &a.keys[i]
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	rawTimestamp := r.Header.Get(HeaderTimestamp)
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid signature timestamp")
+	}
+	if skew := a.now().Sub(time.Unix(timestamp, 0)); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return nil, errors.New("signature timestamp is outside the allowed window")
+	}
+
+	nonce := r.Header.Get(HeaderNonce)
+	if nonce == "" {
+		return nil, errors.New("missing signature nonce")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := signature(key.Secret, r.Method, r.URL.RequestURI(), rawTimestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))) {
+		return nil, errors.New("invalid signature")
+	}
+
+	if !a.useNonce(key.ID, nonce) {
+		return nil, errors.New("signature was already used")
+	}
+
+	return key, nil
+}
+
+// useNonce records a nonce and reports whether it wasn't seen before. Nonces
+// are forgotten once a signature using them would be rejected as stale.
+func (a *Authenticator) useNonce(id, nonce string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	for seen, expires := range a.nonces {
+		if now.After(expires) {
+			delete(a.nonces, seen)
+		}
+	}
+
+	seen := id + ":" + nonce
+	if _, ok := a.nonces[seen]; ok {
+		return false
+	}
+	a.nonces[seen] = now.Add(2 * MaxClockSkew)
+
+	return true
+}
+
+// Sign adds HMAC signature headers for key to a request.
+func Sign(r *http.Request, key Key, now time.Time) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(raw)
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	r.Header.Set(HeaderKeyID, key.ID)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, signature(key.Secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body))
+
+	return nil
+}
+
+// signature is the hex encoded HMAC-SHA256 of the method, request uri,
+// timestamp, nonce and body digest, separated by newlines.
+func signature(secret, method, uri, timestamp, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", method, uri, timestamp, nonce, hex.EncodeToString(digest[:]))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readBody reads the request body and puts it back so it can be read again.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			return nil, fmt.Errorf("request body is larger than %d bytes", MaxBodySize)
+		}
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Caller  string    `json:"caller,omitempty"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	Remote  string    `json:"remote"`
+	Scope   Scope     `json:"scope"`
+	Allowed bool      `json:"allowed"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+func (a *Authenticator) log(entry auditEntry) {
+	data, err := json.Marshal(struct {
+		Audit auditEntry `json:"audit"`
+	}{entry})
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.audit.Write(append(data, '\n'))
+}
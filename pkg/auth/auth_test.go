@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	readKey  = Key{ID: "reader", Scope: ScopeRead, Secret: "read-secret"}
+	adminKey = Key{ID: "operator", Scope: ScopeAdmin, Secret: "admin-secret"}
+)
+
+func testHandler(a *Authenticator, scope Scope) http.Handler {
+	return a.Require(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Caller(r.Context())))
+	}))
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("reader:read:abc, operator:admin:d:e\n# comment\n")
+	require.NoError(t, err)
+	assert.Equal(t, []Key{
+		{ID: "reader", Scope: ScopeRead, Secret: "abc"},
+		{ID: "operator", Scope: ScopeAdmin, Secret: "d:e"},
+	}, keys)
+
+	_, err = ParseKeys("reader:write:abc")
+	assert.Error(t, err)
+
+	_, err = ParseKeys("reader")
+	assert.Error(t, err)
+}
+
+func TestRequire(t *testing.T) {
+	cases := map[string]struct {
+		scope  Scope
+		setup  func(r *http.Request)
+		status int
+		caller string
+	}{
+		"missing credentials": {
+			scope:  ScopeRead,
+			setup:  func(r *http.Request) {},
+			status: http.StatusUnauthorized,
+		},
+		"bearer read key": {
+			scope:  ScopeRead,
+			setup:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer read-secret") },
+			status: http.StatusOK,
+			caller: "reader",
+		},
+		"bearer read key on admin endpoint": {
+			scope:  ScopeAdmin,
+			setup:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer read-secret") },
+			status: http.StatusForbidden,
+		},
+		"bearer admin key on read endpoint": {
+			scope:  ScopeRead,
+			setup:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer admin-secret") },
+			status: http.StatusOK,
+			caller: "operator",
+		},
+		"invalid bearer token": {
+			scope:  ScopeRead,
+			setup:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") },
+			status: http.StatusUnauthorized,
+		},
+		"signed request": {
+			scope: ScopeAdmin,
+			setup: func(r *http.Request) {
+				require.NoError(t, Sign(r, adminKey, time.Now()))
+			},
+			status: http.StatusOK,
+			caller: "operator",
+		},
+		"tampered body": {
+			scope: ScopeAdmin,
+			setup: func(r *http.Request) {
+				require.NoError(t, Sign(r, adminKey, time.Now()))
+				r.Body = http.NoBody
+			},
+			status: http.StatusUnauthorized,
+		},
+		"stale signature": {
+			scope: ScopeAdmin,
+			setup: func(r *http.Request) {
+				require.NoError(t, Sign(r, adminKey, time.Now().Add(-10*time.Minute)))
+			},
+			status: http.StatusUnauthorized,
+		},
+		"missing nonce": {
+			scope: ScopeAdmin,
+			setup: func(r *http.Request) {
+				require.NoError(t, Sign(r, adminKey, time.Now()))
+				r.Header.Del(HeaderNonce)
+			},
+			status: http.StatusUnauthorized,
+		},
+		"body too large": {
+			scope: ScopeAdmin,
+			setup: func(r *http.Request) {
+				r.Body = ioutil.NopCloser(strings.NewReader(strings.Repeat("x", MaxBodySize+1)))
+				require.NoError(t, Sign(r, adminKey, time.Now()))
+			},
+			status: http.StatusUnauthorized,
+		},
+		"unknown key": {
+			scope: ScopeRead,
+			setup: func(r *http.Request) {
+				require.NoError(t, Sign(r, Key{ID: "ghost", Secret: "x"}, time.Now()))
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for name, c := range cases {
+		var audit bytes.Buffer
+		a := New([]Key{readKey, adminKey}, &audit)
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/switchover?x=1", strings.NewReader(`{"region":"ord"}`))
+		c.setup(req)
+
+		rec := httptest.NewRecorder()
+		testHandler(a, c.scope).ServeHTTP(rec, req)
+
+		assert.Equal(t, c.status, rec.Code, name)
+		if c.status == http.StatusOK {
+			assert.Equal(t, c.caller, rec.Body.String(), name)
+		}
+
+		var entry struct {
+			Audit auditEntry `json:"audit"`
+		}
+		require.NoError(t, json.Unmarshal(audit.Bytes(), &entry), name)
+		assert.Equal(t, c.status == http.StatusOK, entry.Audit.Allowed, name)
+		assert.Equal(t, "/admin/switchover", entry.Audit.Path, name)
+	}
+}
+
+func TestRequireReplay(t *testing.T) {
+	a := New([]Key{adminKey}, ioutil.Discard)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/restart", strings.NewReader(`{}`))
+	require.NoError(t, Sign(req, adminKey, time.Now()))
+
+	replay := httptest.NewRequest(http.MethodPost, "/admin/restart", strings.NewReader(`{}`))
+	replay.Header = req.Header.Clone()
+
+	rec := httptest.NewRecorder()
+	testHandler(a, ScopeAdmin).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	testHandler(a, ScopeAdmin).ServeHTTP(rec, replay)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestRequireDisabled(t *testing.T) {
+	var audit bytes.Buffer
+	a := Disabled(&audit)
+	assert.True(t, a.Open())
+
+	rec := httptest.NewRecorder()
+	testHandler(a, ScopeAdmin).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/restart", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, audit.String(), "authentication disabled")
+}
+
+func TestRequireNoKeys(t *testing.T) {
+	var audit bytes.Buffer
+	a := New(nil, &audit)
+	assert.False(t, a.Open())
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/role", nil)
+	req.Header.Set("Authorization", "Bearer read-secret")
+
+	rec := httptest.NewRecorder()
+	testHandler(a, ScopeRead).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, audit.String(), "no api keys are configured")
+}
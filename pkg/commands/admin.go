@@ -1,311 +1,419 @@
 package commands
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/fly-examples/postgres-ha/pkg/flypg"
-	"github.com/pkg/errors"
-	"io"
-	"net/http"
 	"os/exec"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
 	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
 	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
-	"github.com/fly-examples/postgres-ha/pkg/render"
 	"github.com/fly-examples/postgres-ha/pkg/util"
+	"github.com/google/shlex"
 )
 
-func handleFailoverTrigger(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+func failoverTrigger(ctx context.Context, req *Request) (result interface{}, err error) {
+	defer func() {
+		recordFailover(err)
+	}()
 
 	env, err := util.BuildEnv()
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 
-	data, err := stolon.FetchClusterData(env)
+	node, err := flypg.NewNode()
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 
-	// Set this so we can compare it later.
-	currentMasterUID := masterKeeperUID(data)
+	client, err := node.NewStolonClient()
+	if err != nil {
+		return nil, err
+	}
 
-	// Discover keepers that are eligible for promotion.
-	eligibleCount := 0
-	for _, keeper := range data.Keepers {
-		if keeper.Status.Healthy && keeper.Status.CanBeMaster && keeper.UID != currentMasterUID {
-			fmt.Printf("Keeper %s is eligible!  Master is %s\n", keeper.UID, currentMasterUID)
-			eligibleCount++
-		}
+	data, err := client.ClusterData(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if eligibleCount == 0 {
-		err := fmt.Errorf("no eligible keepers available to accommodate failover")
-		render.Err(w, err)
-		return
+	currentMasterUID := data.MasterKeeperUID()
+
+	plan := stolon.PlanFailover(data, stolon.PlanOptions{})
+	if err := plan.Err(); err != nil {
+		return nil, err
 	}
+	logger.Printf("Keeper %s is likely to be elected! Master is %s", plan.Candidate, currentMasterUID)
 
-	_, err = stolon.Failkeeper(currentMasterUID, env)
-	if err != nil {
-		util.WriteError(err)
+	// Start watching before failing the keeper so the master change can't be missed.
+	watchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	events := stolon.NewWatcher(client, time.Second).WatchFrom(watchCtx, data)
+
+	if _, err = stolon.Failkeeper(currentMasterUID, env); err != nil {
+		return nil, err
 	}
 
 	// Verify failover
-	timeout := time.After(10 * time.Second)
+	for event := range events {
+		switch event.Type {
+		case stolon.EventMasterChanged:
+			return "failover completed successfully", nil
+		case stolon.EventStoreError:
+			return nil, fmt.Errorf("failed to verify failover with error: %s", event.Message)
+		}
+	}
 
-	ticker := time.NewTicker(1 * time.Second)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 
-	for {
-		select {
+	return nil, errFailoverTimeout
+}
 
-		case <-timeout:
-			render.Err(w, fmt.Errorf("timed out verifying failover"))
-		case <-ticker.C:
-			data, err := stolon.FetchClusterData(env)
-			if err != nil {
-				render.Err(w, fmt.Errorf("failed to verify failover with error: %w", err))
-			}
+func restart(ctx context.Context, req *Request) (interface{}, error) {
+	if err := run("gosu", "stolon", "pg_ctl", "-D", "/data/postgres", "restart"); err != nil {
+		return nil, err
+	}
 
-			if currentMasterUID != masterKeeperUID(data) {
-				res := failOverResponse{"failover completed successfully"}
-				render.JSON(w, res, http.StatusOK)
-				return
-			}
-		case <-ctx.Done():
-			render.Err(w, ctx.Err())
-			return
-		}
+	return "Restart completed successfully", nil
+}
 
+func role(ctx context.Context, req *Request) (interface{}, error) {
+	conn, close, err := localConnection(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer close()
+
+	return admin.ResolveRole(ctx, conn)
 }
 
-func handleRestart(w http.ResponseWriter, r *http.Request) {
+func viewSettings(ctx context.Context, req *Request) (interface{}, error) {
+	in := []string{}
+	if err := req.Decode(&in); err != nil {
+		return nil, err
+	}
+	if len(in) == 0 {
+		return nil, fmt.Errorf("no settings were specified")
+	}
 
-	args := []string{"stolon", "pg_ctl", "-D", "/data/postgres", "restart"}
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
 
-	cmd := exec.Command("gosu", args...)
+	return admin.ResolveSettings(ctx, conn, in)
+}
 
-	if err := cmd.Run(); err != nil {
-		render.Err(w, err)
-		return
+// updateSettings validates pg parameters against pg_settings before patching
+// them into the cluster spec.
+func updateSettings(ctx context.Context, req *Request) (interface{}, error) {
+	var input settingsUpdateRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
 	}
-
-	if cmd.ProcessState.ExitCode() != 0 {
-		err := fmt.Errorf(cmd.ProcessState.String())
-		render.Err(w, err)
-		return
+	if len(input.PGParameters) == 0 {
+		return nil, fmt.Errorf("no pgParameters were specified")
 	}
 
-	res := &Response{Result: "Restart completed successfully"}
+	return applySettings(ctx, input.PGParameters, input.Reason)
+}
+
+func viewSettingsHistory(ctx context.Context, req *Request) (interface{}, error) {
+	history, err := settingsHistory()
+	if err != nil {
+		return nil, err
+	}
 
-	render.JSON(w, res, http.StatusOK)
+	return history.List(ctx)
 }
 
-func handleRole(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := localConnection(r.Context())
+// rollbackSettings undoes every settings change made after a revision. The
+// rollback is recorded as a new revision, so it can be undone too.
+func rollbackSettings(ctx context.Context, req *Request) (interface{}, error) {
+	var input settingsRollbackRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+	if input.Revision == nil {
+		return nil, fmt.Errorf("revision is required")
+	}
+
+	history, err := settingsHistory()
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
-	defer close()
 
-	role, err := admin.ResolveRole(r.Context(), conn)
+	revs, err := history.List(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 
-	res := &Response{Result: role}
+	params, err := flypg.RollbackParameters(revs, *input.Revision)
+	if err != nil {
+		return nil, err
+	}
 
-	render.JSON(w, res, http.StatusOK)
+	return applySettings(ctx, params, fmt.Sprintf("rollback to revision %d", *input.Revision))
 }
 
-func handleViewSettings(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := proxyConnection(r.Context())
+func applySettings(ctx context.Context, params map[string]*string, reason string) (interface{}, error) {
+	conn, close, err := proxyConnection(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 	defer close()
 
-	in := []string{}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
 
-	if err = json.NewDecoder(r.Body).Decode(&in); err != nil {
-		render.Err(w, err)
-		return
+	current, err := admin.ResolveSettings(ctx, conn, names)
+	if err != nil {
+		return nil, err
 	}
 
-	settings, err := admin.ResolveSettings(r.Context(), conn, in)
+	changes, err := validateSettings(current.Settings, params)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 
-	res := &Response{
-		Result: settings,
+	if err := patchPGParameters(ctx, params, reason); err != nil {
+		return nil, err
 	}
-	render.JSON(w, res, http.StatusOK)
-}
 
-func handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
-	env, err := util.BuildEnv()
+	node, err := flypg.NewNode()
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 
-	config, err := io.ReadAll(r.Body)
+	data, err := node.GetStolonClusterData(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	defer r.Body.Close()
+	return settingsUpdateResponse(changes, data), nil
+}
 
+func settingsHistory() (*flypg.SettingsHistory, error) {
+	node, err := flypg.NewNode()
 	if err != nil {
-		err = fmt.Errorf("failed to read request body: %w", err)
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 
-	if _, err := stolon.Ctl([]string{"update", "--patch", string(config)}, env); err != nil {
-		render.Err(w, err)
-		return
+	client, err := node.NewStolonClient()
+	if err != nil {
+		return nil, err
 	}
-	resp := &Response{Result: "Ok"}
 
-	render.JSON(w, resp, http.StatusOK)
+	return flypg.NewSettingsHistory(client), nil
 }
 
-func handleReplicationStats(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := localConnection(r.Context())
-	if err != nil {
-		render.Err(w, err)
-		return
+// validateSettings checks every parameter against its setting. Parameters set
+// to null are reset to their default, they may be unknown to postgres when
+// they were set by mistake.
+func validateSettings(settings []flypg.Setting, params map[string]*string) ([]settingChange, error) {
+	byName := map[string]flypg.Setting{}
+	for _, s := range settings {
+		if s.Name != nil {
+			byName[*s.Name] = s
+		}
 	}
-	defer close()
 
-	stats, err := admin.ResolveReplicationLag(r.Context(), conn)
-	if err != nil {
-		render.Err(w, err)
-		return
+	changes := []settingChange{}
+	problems := []string{}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	resp := &Response{Result: stats}
+	for _, name := range names {
+		name, value := name, params[name]
 
-	render.JSON(w, resp, http.StatusOK)
-}
+		s, ok := byName[name]
+		if !ok {
+			if value == nil {
+				changes = append(changes, settingChange{Setting: flypg.Setting{Name: &name}, Apply: flypg.ApplyReload})
+			} else {
+				problems = append(problems, fmt.Sprintf("%s: unknown setting", name))
+			}
+			continue
+		}
 
-func handleStolonDBUid(w http.ResponseWriter, r *http.Request) {
-	env, err := util.BuildEnv()
-	if err != nil {
-		render.Err(w, err)
+		apply, err := s.Apply()
+		if err == nil && value != nil {
+			err = s.Validate(*value)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+
+		s.PendingChange = value
+		s.PendingRestart = apply == flypg.ApplyRestart
+		changes = append(changes, settingChange{Setting: s, Apply: apply})
 	}
 
-	data, err := stolon.FetchClusterData(env)
-	if err != nil {
-		render.Err(w, err)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid settings: %s", strings.Join(problems, "; "))
 	}
 
-	node, err := flypg.NewNode()
-	if err != nil {
-		render.Err(w, err)
+	return changes, nil
+}
+
+// settingsUpdateResponse lists the members that run with an old value until
+// postgres restarts.
+func settingsUpdateResponse(changes []settingChange, data *stolon.ClusterData) settingsUpdate {
+	resp := settingsUpdate{Changes: changes, RestartRequired: []string{}}
+
+	restart := false
+	for _, c := range changes {
+		restart = restart || c.PendingRestart
 	}
 
-	for _, db := range data.DBs {
-		if db.Spec.KeeperUID == node.KeeperUID {
-			resp := &Response{Result: db.UID}
-			render.JSON(w, resp, http.StatusOK)
-			return
+	if restart {
+		for _, db := range data.DBs {
+			if db.Spec != nil {
+				resp.RestartRequired = append(resp.RestartRequired, db.Spec.KeeperUID)
+			}
 		}
+		sort.Strings(resp.RestartRequired)
 	}
 
-	render.Err(w, errors.New("can't find db"))
+	switch {
+	case !restart:
+		resp.Message = "settings will be applied on reload"
+	case data.Cluster != nil && data.Cluster.Spec != nil && data.Cluster.Spec.AutomaticPgRestart != nil && *data.Cluster.Spec.AutomaticPgRestart:
+		resp.Message = "stolon will restart postgres to apply the settings"
+	default:
+		resp.Message = "postgres has to be restarted on every member to apply the settings"
+	}
+
+	return resp
 }
 
-func handleEnableReadonly(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := localConnection(r.Context())
+func stolonctlRun(ctx context.Context, req *Request) (interface{}, error) {
+	var input stolonctlRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+
+	args, err := shlex.Split(input.Args)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, fmt.Errorf("error parsing argument: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("a stolonctl command is required")
 	}
-	defer close()
 
-	err = admin.SetReadonly(r.Context(), conn, true)
+	env, err := util.BuildEnv()
 	if err != nil {
-		render.Err(w, err)
+		return nil, err
 	}
 
-	args := []string{"root", "pkill", "haproxy"}
+	result, err := stolon.Ctl(args, env)
+	if err != nil {
+		return nil, err
+	}
 
-	cmd := exec.Command("gosu", args...)
+	return string(result), nil
+}
 
-	if err := cmd.Run(); err != nil {
-		render.Err(w, err)
-		return
+func replicationStats(ctx context.Context, req *Request) (interface{}, error) {
+	conn, close, err := localConnection(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer close()
 
-	if cmd.ProcessState.ExitCode() != 0 {
-		err := fmt.Errorf(cmd.ProcessState.String())
-		render.Err(w, err)
-		return
-	}
+	return admin.ResolveReplicationLag(ctx, conn)
+}
 
-	resp := &Response{Result: true}
+func archiverStatus(ctx context.Context, req *Request) (interface{}, error) {
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
 
-	render.JSON(w, resp, http.StatusOK)
+	return admin.ResolveArchiverStatus(ctx, conn)
 }
 
-func handleDisableReadonly(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := localConnection(r.Context())
+func stolonDBUid(ctx context.Context, req *Request) (interface{}, error) {
+	node, err := flypg.NewNode()
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
-	defer close()
 
-	err = admin.SetReadonly(r.Context(), conn, false)
+	data, err := node.GetStolonClusterData(ctx)
 	if err != nil {
-		render.Err(w, err)
+		return nil, err
+	}
+
+	for _, db := range data.DBs {
+		if db.Spec.KeeperUID == node.KeeperUID {
+			return db.UID, nil
+		}
 	}
 
-	args := []string{"root", "pkill", "haproxy"}
+	return nil, errors.New("can't find db")
+}
 
-	cmd := exec.Command("gosu", args...)
+func enableReadonly(ctx context.Context, req *Request) (interface{}, error) {
+	return setReadonly(ctx, true)
+}
 
-	if err := cmd.Run(); err != nil {
-		render.Err(w, err)
-		return
+func disableReadonly(ctx context.Context, req *Request) (interface{}, error) {
+	return setReadonly(ctx, false)
+}
+
+func setReadonly(ctx context.Context, enable bool) (interface{}, error) {
+	conn, close, err := localConnection(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer close()
 
-	if cmd.ProcessState.ExitCode() != 0 {
-		err := fmt.Errorf(cmd.ProcessState.String())
-		render.Err(w, err)
-		return
+	if err := admin.SetReadonly(ctx, conn, enable); err != nil {
+		return nil, err
 	}
 
-	resp := &Response{Result: true}
+	// Restart haproxy to drop connections that are still using the old setting.
+	if _, err := processes.RestartProcess(proxyProcess); err != nil {
+		return nil, err
+	}
 
-	render.JSON(w, resp, http.StatusOK)
+	return true, nil
 }
 
-func handleRestartHaproxy(w http.ResponseWriter, r *http.Request) {
-	args := []string{"root", "pkill", "haproxy"}
+func restartHaproxy(ctx context.Context, req *Request) (interface{}, error) {
+	if _, err := processes.RestartProcess(proxyProcess); err != nil {
+		return nil, err
+	}
 
-	cmd := exec.Command("gosu", args...)
+	return true, nil
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
 
 	if err := cmd.Run(); err != nil {
-		render.Err(w, err)
-		return
+		return err
 	}
 
 	if cmd.ProcessState.ExitCode() != 0 {
-		err := fmt.Errorf(cmd.ProcessState.String())
-		render.Err(w, err)
-		return
+		return errors.New(cmd.ProcessState.String())
 	}
 
-	resp := &Response{Result: true}
-
-	render.JSON(w, resp, http.StatusOK)
+	return nil
 }
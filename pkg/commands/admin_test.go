@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSettings(t *testing.T) {
+	settings := []flypg.Setting{
+		pgSetting("work_mem", "integer", "user", "kB", "64", "2147483647"),
+		pgSetting("shared_buffers", "integer", "postmaster", "8kB", "16", "1073741823"),
+		pgSetting("log_min_duration_statement", "integer", "superuser", "ms", "-1", "2147483647"),
+		pgSetting("block_size", "integer", "internal", "", "8192", "8192"),
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		changes, err := validateSettings(settings, map[string]*string{
+			"work_mem":                   str("8MB"),
+			"shared_buffers":             str("1GB"),
+			"log_min_duration_statement": str("1s"),
+		})
+		require.NoError(t, err)
+		require.Len(t, changes, 3)
+
+		assert.Equal(t, "log_min_duration_statement", *changes[0].Name)
+		assert.Equal(t, flypg.ApplySuperuser, changes[0].Apply)
+		assert.Equal(t, "shared_buffers", *changes[1].Name)
+		assert.Equal(t, flypg.ApplyRestart, changes[1].Apply)
+		assert.True(t, changes[1].PendingRestart)
+		assert.Equal(t, "1GB", *changes[1].PendingChange)
+		assert.Equal(t, flypg.ApplyReload, changes[2].Apply)
+		assert.False(t, changes[2].PendingRestart)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		changes, err := validateSettings(settings, map[string]*string{
+			"shared_buffers": nil,
+			"wrok_mem":       nil,
+		})
+		require.NoError(t, err)
+		require.Len(t, changes, 2)
+
+		assert.Equal(t, flypg.ApplyRestart, changes[0].Apply)
+		assert.Equal(t, "wrok_mem", *changes[1].Name)
+		assert.Equal(t, flypg.ApplyReload, changes[1].Apply)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := validateSettings(settings, map[string]*string{
+			"work_mem":       str("1kB"),
+			"wrok_mem":       str("8MB"),
+			"block_size":     str("16384"),
+			"shared_buffers": str("128MB"),
+		})
+		assert.EqualError(t, err, `invalid settings: block_size: block_size can't be changed; work_mem: "1kB" is out of range, expected 64 to 2147483647kB; wrok_mem: unknown setting`)
+	})
+}
+
+func TestSettingsUpdateResponse(t *testing.T) {
+	enabled := true
+	data := &stolon.ClusterData{
+		Cluster: &stolon.Cluster{Spec: &stolon.ClusterSpec{}},
+		DBs: map[string]*stolon.DB{
+			"db2": {Spec: &stolon.DBSpec{KeeperUID: "keeper2"}},
+			"db1": {Spec: &stolon.DBSpec{KeeperUID: "keeper1"}},
+		},
+	}
+
+	reload := []settingChange{{Apply: flypg.ApplyReload}}
+	resp := settingsUpdateResponse(reload, data)
+	assert.Equal(t, []string{}, resp.RestartRequired)
+	assert.Equal(t, "settings will be applied on reload", resp.Message)
+
+	restart := []settingChange{{Setting: flypg.Setting{PendingRestart: true}, Apply: flypg.ApplyRestart}}
+	resp = settingsUpdateResponse(restart, data)
+	assert.Equal(t, []string{"keeper1", "keeper2"}, resp.RestartRequired)
+	assert.Equal(t, "postgres has to be restarted on every member to apply the settings", resp.Message)
+
+	data.Cluster.Spec.AutomaticPgRestart = &enabled
+	resp = settingsUpdateResponse(restart, data)
+	assert.Equal(t, "stolon will restart postgres to apply the settings", resp.Message)
+}
+
+func pgSetting(name, vartype, context, unit, min, max string) flypg.Setting {
+	return flypg.Setting{
+		Name:    str(name),
+		VarType: str(vartype),
+		Context: str(context),
+		Unit:    str(unit),
+		MinVal:  str(min),
+		MaxVal:  str(max),
+	}
+}
+
+func str(s string) *string {
+	return &s
+}
@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/auth"
+)
+
+// localEndpoint is the http api of the server running alongside the
+// supervisor.
+const localEndpoint = "http://localhost:5500/commands"
+
+// Call runs the named command on the local server rather than in process, so
+// it is logged, audited and counted in the metrics like any other request.
+// Path params are read from the top level fields of input, as with Exec. The
+// result is decoded into result.
+func Call(ctx context.Context, name string, input []byte, result interface{}) error {
+	cmd := Lookup(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown command '%s'", name)
+	}
+
+	return callCommand(ctx, localEndpoint, cmd, inputParams(input), nil, input, result)
+}
+
+var pathParam = regexp.MustCompile(`{([^}]+)}`)
+
+// callCommand sends a request for cmd to the server at endpoint, signed with
+// a configured api key.
+func callCommand(ctx context.Context, endpoint string, cmd *Command, params map[string]string, query url.Values, body []byte, result interface{}) error {
+	if cmd.Path == "" {
+		return fmt.Errorf("command '%s' is not available over http", cmd.Name)
+	}
+
+	var missing error
+	path := pathParam.ReplaceAllStringFunc(cmd.Path, func(param string) string {
+		name := param[1 : len(param)-1]
+		if params[name] == "" {
+			missing = fmt.Errorf("%s is required", name)
+		}
+		return url.PathEscape(params[name])
+	})
+	if missing != nil {
+		return missing
+	}
+
+	endpoint += path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, cmd.Method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	key, err := auth.ClientKey(cmd.Scope)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		if err := auth.Sign(req, *key, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	res := Response{Result: result}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&res)
+
+	switch {
+	case resp.StatusCode != http.StatusOK && res.Error != "":
+		return errors.New(res.Error)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("%s failed with status code %d", cmd.Name, resp.StatusCode)
+	case decodeErr != nil:
+		return fmt.Errorf("failed to decode %s response: %w", cmd.Name, decodeErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/auth"
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallCommand(t *testing.T) {
+	os.Setenv("FLYPG_AUTH_DISABLED", "1")
+	defer os.Unsetenv("FLYPG_AUTH_DISABLED")
+
+	svisor := supervisor.New("test", 0)
+	logger := svisor.Logger("start")
+	logger.Println("one")
+	logger.Println("two")
+
+	defer func(p processController) { processes = p }(processes)
+	UseSupervisor(svisor)
+
+	srv := httptest.NewServer(Handler(auth.Disabled(ioutil.Discard)))
+	defer srv.Close()
+
+	remote := &remoteSupervisor{endpoint: srv.URL}
+
+	lines, err := remote.Logs("start", 1)
+	require.NoError(t, err)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "two", lines[0].Message)
+
+	// errors come back as the command's own error
+	_, err = remote.Logs("missing", 1)
+	assert.EqualError(t, err, "unknown process missing")
+
+	_, err = remote.Process("")
+	assert.EqualError(t, err, "name is required")
+
+	err = callCommand(context.Background(), srv.URL, Lookup("stolonctl-run"), nil, nil, nil, nil)
+	assert.EqualError(t, err, "command 'stolonctl-run' is not available over http")
+}
@@ -1,100 +1,69 @@
 package commands
 
 import (
-	"encoding/json"
-	"net/http"
+	"context"
+	"fmt"
 
 	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
-	"github.com/fly-examples/postgres-ha/pkg/render"
-	"github.com/go-chi/chi/v5"
 )
 
-func handleListDatabases(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := proxyConnection(r.Context())
+func listDatabases(ctx context.Context, req *Request) (interface{}, error) {
+	conn, close, err := proxyConnection(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 	defer close()
 
-	dbs, err := admin.ListDatabases(r.Context(), conn)
-	if err != nil {
-		render.Err(w, err)
-		return
-	}
-	res := &Response{
-		Result: dbs,
-	}
-
-	render.JSON(w, res, http.StatusOK)
+	return admin.ListDatabases(ctx, conn)
 }
 
-func handleFindDatabase(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := proxyConnection(r.Context())
+func findDatabase(ctx context.Context, req *Request) (interface{}, error) {
+	conn, close, err := proxyConnection(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 	defer close()
 
-	name := chi.URLParam(r, "name")
+	return admin.FindDatabase(ctx, conn, req.Param("name"))
+}
 
-	db, err := admin.FindDatabase(r.Context(), conn, name)
-	if err != nil {
-		render.Err(w, err)
-		return
+func createDatabase(ctx context.Context, req *Request) (interface{}, error) {
+	var input createDatabaseRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
 	}
-	res := &Response{
-		Result: db,
+	if input.Name == "" {
+		return nil, fmt.Errorf("name is required")
 	}
 
-	render.JSON(w, res, http.StatusOK)
-}
-
-func handleCreateDatabase(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := proxyConnection(r.Context())
+	conn, close, err := proxyConnection(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 	defer close()
 
-	input := createDatabaseRequest{}
-
-	err = json.NewDecoder(r.Body).Decode(&input)
-	if err != nil {
-		render.Err(w, err)
-		return
+	if err := admin.CreateDatabase(ctx, conn, input.Name); err != nil {
+		return nil, err
 	}
-	defer r.Body.Close()
-
-	err = admin.CreateDatabase(r.Context(), conn, input.Name)
-	if err != nil {
-		render.Err(w, err)
-		return
-	}
-
-	res := &Response{Result: true}
 
-	render.JSON(w, res, http.StatusOK)
+	return true, nil
 }
 
-func handleDeleteDatabase(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := proxyConnection(r.Context())
+func deleteDatabase(ctx context.Context, req *Request) (interface{}, error) {
+	name := req.Param("name")
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	conn, close, err := proxyConnection(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 	defer close()
 
-	name := chi.URLParam(r, "name")
-
-	err = admin.DeleteDatabase(r.Context(), conn, name)
-	if err != nil {
-		render.Err(w, err)
-		return
+	if err := admin.DeleteDatabase(ctx, conn, name); err != nil {
+		return nil, err
 	}
-	res := &Response{Result: true}
 
-	render.JSON(w, res, http.StatusOK)
+	return true, nil
 }
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/fly-examples/postgres-ha/pkg/render"
+)
+
+const eventsKeepAliveInterval = 15 * time.Second
+
+// handleEvents streams cluster data changes as Server-Sent Events.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		render.Err(w, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	node, err := flypg.NewNode()
+	if err != nil {
+		render.Err(w, err)
+		return
+	}
+
+	client, err := node.NewStolonClient()
+	if err != nil {
+		render.Err(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	events := stolon.NewWatcher(client, time.Second).Watch(r.Context())
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		}
+
+		flusher.Flush()
+	}
+}
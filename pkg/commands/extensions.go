@@ -0,0 +1,252 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/jackc/pgx/v4"
+)
+
+const preloadSetting = "shared_preload_libraries"
+
+func listExtensions(ctx context.Context, req *Request) (interface{}, error) {
+	database := req.Param("database")
+	if database == "" {
+		database = "postgres"
+	}
+
+	conn, close, err := databaseConnection(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	available, err := admin.ListExtensions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := loadedPreloadLibraries(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions := []extension{}
+	for _, e := range available {
+		ext := extension{Extension: e}
+		if lib, ok := flypg.PreloadLibrary(e.Name); ok {
+			ext.PreloadLibrary = lib
+			ext.Preloaded = contains(loaded, lib)
+		}
+		extensions = append(extensions, ext)
+	}
+
+	return extensionsResponse{
+		Database:         database,
+		PreloadLibraries: loaded,
+		Extensions:       extensions,
+	}, nil
+}
+
+// createExtension creates an extension, unless it needs a library postgres
+// hasn't loaded. The library is then added to the cluster spec and the
+// extension can be created once postgres has restarted.
+func createExtension(ctx context.Context, req *Request) (interface{}, error) {
+	var input extensionRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+	if err := input.validate(); err != nil {
+		return nil, err
+	}
+
+	conn, close, err := databaseConnection(ctx, input.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	// Preloading a library that isn't installed keeps postgres from starting.
+	available, err := admin.ListExtensions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if !extensionAvailable(available, input.Name) {
+		return nil, fmt.Errorf("extension %s is not available on this image", input.Name)
+	}
+
+	loaded, err := loadedPreloadLibraries(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := node.GetStolonClusterData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := flypg.ParsePreloadLibraries(specParameters(data)[preloadSetting])
+
+	libs, changed, pending := requirePreload(input.Name, spec, loaded)
+	if changed {
+		value := strings.Join(libs, ",")
+		if err := patchPGParameters(ctx, map[string]*string{preloadSetting: &value}, "preload "+input.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	if pending {
+		return extensionResponse{
+			Database:         input.Database,
+			Name:             input.Name,
+			RestartPending:   true,
+			PreloadLibraries: libs,
+			Message:          fmt.Sprintf("%s has to be preloaded, restart postgres on every member then create the extension again", input.Name),
+		}, nil
+	}
+
+	if err := admin.CreateExtension(ctx, conn, input.Name, input.ExtensionOptions); err != nil {
+		return nil, err
+	}
+
+	return extensionResponse{
+		Database: input.Database,
+		Name:     input.Name,
+		Message:  fmt.Sprintf("extension %s created", input.Name),
+	}, nil
+}
+
+func updateExtension(ctx context.Context, req *Request) (interface{}, error) {
+	var input extensionRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+	if err := input.validate(); err != nil {
+		return nil, err
+	}
+
+	conn, close, err := databaseConnection(ctx, input.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	if err := admin.UpdateExtension(ctx, conn, input.Name, input.Version); err != nil {
+		return nil, err
+	}
+
+	return extensionResponse{
+		Database: input.Database,
+		Name:     input.Name,
+		Message:  fmt.Sprintf("extension %s updated", input.Name),
+	}, nil
+}
+
+// deleteExtension drops an extension. Its library stays preloaded, other
+// databases may still use it.
+func deleteExtension(ctx context.Context, req *Request) (interface{}, error) {
+	input := extensionRequest{Name: req.Param("name"), Database: req.Param("database")}
+	if err := input.validate(); err != nil {
+		return nil, err
+	}
+
+	cascade := false
+	if raw := req.Param("cascade"); raw != "" {
+		var err error
+		if cascade, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("invalid cascade: %w", err)
+		}
+	}
+
+	conn, close, err := databaseConnection(ctx, input.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	if err := admin.DropExtension(ctx, conn, input.Name, cascade); err != nil {
+		return nil, err
+	}
+
+	return extensionResponse{
+		Database: input.Database,
+		Name:     input.Name,
+		Message:  fmt.Sprintf("extension %s dropped", input.Name),
+	}, nil
+}
+
+func (r extensionRequest) validate() error {
+	if r.Database == "" {
+		return fmt.Errorf("database is required")
+	}
+	if r.Name == "" {
+		return fmt.Errorf("extension name is required")
+	}
+	return nil
+}
+
+// requirePreload adds the library extension needs to the preload libraries
+// of the spec. pending is set while postgres runs without it.
+func requirePreload(extension string, spec, loaded []string) (libs []string, changed, pending bool) {
+	lib, ok := flypg.PreloadLibrary(extension)
+	if !ok {
+		return spec, false, false
+	}
+
+	libs, changed = flypg.AddPreloadLibrary(spec, lib)
+	return libs, changed, !contains(loaded, lib)
+}
+
+// databaseConnection connects to database on the master, extensions are
+// created per database.
+func databaseConnection(ctx context.Context, database string) (*pgx.Conn, func() error, error) {
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pg, err := node.NewProxyDatabaseConnection(ctx, database)
+	if err != nil {
+		return nil, nil, err
+	}
+	close := func() error {
+		return pg.Close(ctx)
+	}
+
+	return pg, close, nil
+}
+
+func loadedPreloadLibraries(ctx context.Context, conn *pgx.Conn) ([]string, error) {
+	settings, err := admin.CurrentSettings(ctx, conn, []string{preloadSetting})
+	if err != nil {
+		return nil, err
+	}
+	return flypg.ParsePreloadLibraries(settings[preloadSetting]), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func extensionAvailable(available []admin.Extension, name string) bool {
+	for _, e := range available {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
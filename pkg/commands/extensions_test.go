@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequirePreload(t *testing.T) {
+	cases := map[string]struct {
+		extension string
+		spec      []string
+		loaded    []string
+		libs      []string
+		changed   bool
+		pending   bool
+	}{
+		"no library needed": {
+			extension: "postgis",
+			spec:      []string{},
+			libs:      []string{},
+		},
+		"already loaded": {
+			extension: "pg_stat_statements",
+			spec:      []string{"pg_stat_statements"},
+			loaded:    []string{"pg_stat_statements"},
+			libs:      []string{"pg_stat_statements"},
+		},
+		"added to spec": {
+			extension: "pg_cron",
+			spec:      []string{"timescaledb"},
+			loaded:    []string{"timescaledb"},
+			libs:      []string{"timescaledb", "pg_cron"},
+			changed:   true,
+			pending:   true,
+		},
+		"waiting for restart": {
+			extension: "pg_cron",
+			spec:      []string{"pg_cron"},
+			libs:      []string{"pg_cron"},
+			pending:   true,
+		},
+	}
+
+	for name, c := range cases {
+		libs, changed, pending := requirePreload(c.extension, c.spec, c.loaded)
+		assert.Equal(t, c.libs, libs, name)
+		assert.Equal(t, c.changed, changed, name)
+		assert.Equal(t, c.pending, pending, name)
+	}
+}
+
+func TestExtensionAvailable(t *testing.T) {
+	available := []admin.Extension{{Name: "pg_stat_statements"}, {Name: "postgis"}}
+
+	assert.True(t, extensionAvailable(available, "postgis"))
+	assert.False(t, extensionAvailable(available, "pg_cron"))
+}
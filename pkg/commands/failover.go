@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"context"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/jackc/pgx/v4"
+)
+
+func viewFailoverPlan(ctx context.Context, req *Request) (interface{}, error) {
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := node.GetStolonClusterData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return failoverPlan(ctx, node, data), nil
+}
+
+// failoverPlan builds a plan enriched with the replication lag reported by
+// the master and the region of each keeper. Both are best effort, the plan
+// only relies on the cluster data.
+func failoverPlan(ctx context.Context, node *flypg.Node, data *stolon.ClusterData) *stolon.FailoverPlan {
+	opts := stolon.PlanOptions{}
+
+	if conn, close, err := proxyConnection(ctx); err == nil {
+		defer close()
+
+		if opts.ReplicationLags, err = dbReplicationLags(ctx, conn, data); err != nil {
+			logger.Printf("failed to resolve replication lag: %s", err)
+		}
+	} else {
+		logger.Printf("failed to connect to master: %s", err)
+	}
+
+	regions, err := node.KeeperRegions(ctx)
+	if err != nil {
+		logger.Printf("failed to resolve keeper regions: %s", err)
+	}
+	opts.Regions = regions
+
+	return stolon.PlanFailover(data, opts)
+}
+
+// dbReplicationLags returns the replication lag reported by the master in
+// bytes, keyed by standby db uid.
+func dbReplicationLags(ctx context.Context, conn *pgx.Conn, data *stolon.ClusterData) (map[string]int64, error) {
+	lags, err := standbyLags(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	dbLags := map[string]int64{}
+	for uid := range data.DBs {
+		if lag, ok := lags[stolon.ApplicationName(uid)]; ok {
+			dbLags[uid] = lag
+		}
+	}
+
+	return dbLags, nil
+}
@@ -4,41 +4,87 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/fly-examples/postgres-ha/pkg/auth"
 	"github.com/fly-examples/postgres-ha/pkg/flypg"
-	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v4"
 )
 
-func Handler() http.Handler {
+func init() {
+	register(
+		&Command{Name: "user-list", Method: http.MethodGet, Path: "/users/list", Scope: auth.ScopeRead, Run: listUsers},
+		&Command{Name: "user-find", Method: http.MethodGet, Path: "/users/{username}", Scope: auth.ScopeRead, Run: findUser},
+		&Command{Name: "user-create", Method: http.MethodPost, Path: "/users/create", Scope: auth.ScopeAdmin, Run: createUser},
+		&Command{Name: "user-delete", Method: http.MethodDelete, Path: "/users/delete/{username}", Scope: auth.ScopeAdmin, Run: deleteUser},
+		&Command{Name: "user-update", Method: http.MethodPost, Path: "/users/update", Scope: auth.ScopeAdmin, Run: updateRole},
+		&Command{Name: "role-list", Method: http.MethodGet, Path: "/users/roles", Scope: auth.ScopeRead, Run: listRoles},
+		&Command{Name: "grant-access", Method: http.MethodPost, Path: "/users/access/grant", Scope: auth.ScopeAdmin, Run: grantAccess},
+		&Command{Name: "revoke-access", Method: http.MethodPost, Path: "/users/access/revoke", Scope: auth.ScopeAdmin, Run: revokeAccess},
+		&Command{Name: "grant-superuser", Method: http.MethodPost, Path: "/users/superuser/grant", Scope: auth.ScopeAdmin, Run: grantSuperuser},
+		&Command{Name: "revoke-superuser", Method: http.MethodPost, Path: "/users/superuser/revoke", Scope: auth.ScopeAdmin, Run: revokeSuperuser},
+		&Command{Name: "group-create", Method: http.MethodPost, Path: "/users/groups/create", Scope: auth.ScopeAdmin, Run: createGroup},
+		&Command{Name: "grant-membership", Method: http.MethodPost, Path: "/users/membership/grant", Scope: auth.ScopeAdmin, Run: grantMembership},
+		&Command{Name: "revoke-membership", Method: http.MethodPost, Path: "/users/membership/revoke", Scope: auth.ScopeAdmin, Run: revokeMembership},
+
+		&Command{Name: "database-list", Method: http.MethodGet, Path: "/databases/list", Scope: auth.ScopeRead, Run: listDatabases},
+		&Command{Name: "database-find", Method: http.MethodGet, Path: "/databases/{name}", Scope: auth.ScopeRead, Run: findDatabase},
+		&Command{Name: "database-create", Method: http.MethodPost, Path: "/databases/create", Scope: auth.ScopeAdmin, Run: createDatabase},
+		&Command{Name: "database-delete", Method: http.MethodDelete, Path: "/databases/delete/{name}", Scope: auth.ScopeAdmin, Run: deleteDatabase},
+		&Command{Name: "grant-privileges", Method: http.MethodPost, Path: "/databases/privileges/grant", Scope: auth.ScopeAdmin, Run: grantPrivileges},
+		&Command{Name: "revoke-privileges", Method: http.MethodPost, Path: "/databases/privileges/revoke", Scope: auth.ScopeAdmin, Run: revokePrivileges},
+
+		&Command{Name: "extension-list", Method: http.MethodGet, Path: "/extensions/list", Scope: auth.ScopeRead, Run: listExtensions},
+		&Command{Name: "extension-create", Method: http.MethodPost, Path: "/extensions/create", Scope: auth.ScopeAdmin, Run: createExtension},
+		&Command{Name: "extension-update", Method: http.MethodPost, Path: "/extensions/update", Scope: auth.ScopeAdmin, Run: updateExtension},
+		&Command{Name: "extension-delete", Method: http.MethodDelete, Path: "/extensions/delete/{name}", Scope: auth.ScopeAdmin, Run: deleteExtension},
+
+		&Command{Name: "role", Method: http.MethodGet, Path: "/admin/role", Scope: auth.ScopeRead, Run: role},
+		&Command{Name: "failover-trigger", Method: http.MethodGet, Path: "/admin/failover/trigger", Scope: auth.ScopeAdmin, Run: failoverTrigger},
+		&Command{Name: "failover-plan", Method: http.MethodGet, Path: "/admin/failover/plan", Scope: auth.ScopeRead, Run: viewFailoverPlan},
+		&Command{Name: "switchover", Method: http.MethodPost, Path: "/admin/switchover", Scope: auth.ScopeAdmin, Run: switchover},
+		&Command{Name: "restart", Method: http.MethodGet, Path: "/admin/restart", Scope: auth.ScopeAdmin, Run: restart},
+		&Command{Name: "settings-view", Method: http.MethodGet, Path: "/admin/settings/view", Scope: auth.ScopeRead, Run: viewSettings},
+		&Command{Name: "settings-update", Method: http.MethodPost, Path: "/admin/settings/update", Scope: auth.ScopeAdmin, Run: updateSettings},
+		&Command{Name: "settings-history", Method: http.MethodGet, Path: "/admin/settings/history", Scope: auth.ScopeRead, Run: viewSettingsHistory},
+		&Command{Name: "settings-rollback", Method: http.MethodPost, Path: "/admin/settings/rollback", Scope: auth.ScopeAdmin, Run: rollbackSettings},
+		&Command{Name: "tuning", Method: http.MethodGet, Path: "/admin/tuning", Scope: auth.ScopeRead, Run: viewTuning},
+		&Command{Name: "replication-stats", Method: http.MethodGet, Path: "/admin/replicationstats", Scope: auth.ScopeRead, Run: replicationStats},
+		&Command{Name: "replication-slots", Method: http.MethodGet, Path: "/admin/replication/slots", Scope: auth.ScopeRead, Run: listReplicationSlots},
+		&Command{Name: "replication-slot-create", Method: http.MethodPost, Path: "/admin/replication/slots/create", Scope: auth.ScopeAdmin, Run: createReplicationSlot},
+		&Command{Name: "replication-slot-delete", Method: http.MethodDelete, Path: "/admin/replication/slots/delete/{name}", Scope: auth.ScopeAdmin, Run: deleteReplicationSlot},
+		&Command{Name: "archiver", Method: http.MethodGet, Path: "/admin/archiver", Scope: auth.ScopeRead, Run: archiverStatus},
+		&Command{Name: "readonly-enable", Method: http.MethodPost, Path: "/admin/readonly/enable", Scope: auth.ScopeAdmin, Run: enableReadonly},
+		&Command{Name: "readonly-disable", Method: http.MethodPost, Path: "/admin/readonly/disable", Scope: auth.ScopeAdmin, Run: disableReadonly},
+		&Command{Name: "dbuid", Method: http.MethodGet, Path: "/admin/dbuid", Scope: auth.ScopeRead, Run: stolonDBUid},
+		&Command{Name: "haproxy-restart", Method: http.MethodPost, Path: "/admin/haproxy/restart", Scope: auth.ScopeAdmin, Run: restartHaproxy},
+		// stolonctl can change anything in the cluster, so it's only run locally.
+		&Command{Name: "stolonctl-run", Scope: auth.ScopeAdmin, Run: stolonctlRun},
+		&Command{Name: "password-encryption", Method: http.MethodGet, Path: "/admin/password_encryption", Scope: auth.ScopeRead, Run: viewPasswordEncryption},
+		&Command{Name: "password-encryption-scram", Method: http.MethodPost, Path: "/admin/password_encryption/scram", Scope: auth.ScopeAdmin, Run: migrateToSCRAM},
+
+		&Command{Name: "supervisor-list", Method: http.MethodGet, Path: "/supervisor/list", Scope: auth.ScopeRead, Run: listProcesses},
+		&Command{Name: "supervisor-status", Method: http.MethodGet, Path: "/supervisor/{name}", Scope: auth.ScopeRead, Run: processStatus},
+		&Command{Name: "supervisor-stop", Method: http.MethodPost, Path: "/supervisor/{name}/stop", Scope: auth.ScopeAdmin, Run: stopProcess},
+		&Command{Name: "supervisor-start", Method: http.MethodPost, Path: "/supervisor/{name}/start", Scope: auth.ScopeAdmin, Run: startProcess},
+		&Command{Name: "supervisor-restart", Method: http.MethodPost, Path: "/supervisor/{name}/restart", Scope: auth.ScopeAdmin, Run: restartProcess},
+		&Command{Name: "supervisor-logs", Method: http.MethodGet, Path: "/supervisor/{name}/logs", Scope: auth.ScopeRead, Run: processLogs},
+	)
+}
+
+// Handler serves every registered command, plus the event stream which
+// only makes sense over HTTP.
+func Handler(authn *auth.Authenticator) http.Handler {
 	r := chi.NewRouter()
 
-	r.Route("/users", func(r chi.Router) {
-		r.Get("/{name}", handleFindUser)
-		r.Get("/list", handleListUsers)
-		r.Post("/create", handleCreateUser)
-		r.Delete("/delete/{name}", handleDeleteUser)
-	})
-
-	r.Route("/databases", func(r chi.Router) {
-		r.Get("/list", handleListDatabases)
-		r.Get("/{name}", handleFindDatabase)
-		r.Post("/create", handleCreateDatabase)
-		r.Delete("/delete/{name}", handleDeleteDatabase)
-	})
-
-	r.Route("/admin", func(r chi.Router) {
-		r.Get("/role", handleRole)
-		r.Get("/failover/trigger", handleFailoverTrigger)
-		r.Get("/restart", handleRestart)
-		r.Get("/settings/view", handleViewSettings)
-		r.Get("/replicationstats", handleReplicationStats)
-		r.Post("/readonly/enable", handleEnableReadonly)
-		r.Post("/readonly/disable", handleDisableReadonly)
-		r.Get("/dbuid", handleStolonDBUid)
-		r.Post("/haproxy/restart", handleRestartHaproxy)
-		r.Post("/settings/update", handleUpdateSettings)
-	})
+	for _, name := range Names() {
+		cmd := Lookup(name)
+		if cmd.Path == "" {
+			continue
+		}
+		r.With(authn.Require(cmd.Scope)).Method(cmd.Method, cmd.Path, cmd)
+	}
+
+	r.With(authn.Require(auth.ScopeRead)).Get("/admin/events", handleEvents)
 
 	return r
 }
@@ -76,8 +122,3 @@ func localConnection(ctx context.Context) (*pgx.Conn, func() error, error) {
 
 	return pg, close, nil
 }
-
-func masterKeeperUID(data *stolon.ClusterData) string {
-	db := data.DBs[data.Cluster.Status.Master]
-	return db.Spec.KeeperUID
-}
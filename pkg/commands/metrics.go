@@ -0,0 +1,27 @@
+package commands
+
+import (
+	"context"
+	"errors"
+
+	"github.com/fly-examples/postgres-ha/pkg/metrics"
+)
+
+// pg-failover calls the server, failovers run in process by flyadmin are
+// counted by that process instead. flypg_stolon_master_changes_total counts
+// every master change however it was triggered.
+var failoverAttempts = metrics.NewCounterVec("flypg_failover_attempts_total",
+	"Failovers triggered through the failover-trigger command, by result.", "result")
+
+var errFailoverTimeout = errors.New("timed out verifying failover")
+
+func recordFailover(err error) {
+	switch {
+	case err == nil:
+		failoverAttempts.Inc("success")
+	case errors.Is(err, errFailoverTimeout), errors.Is(err, context.DeadlineExceeded):
+		failoverAttempts.Inc("timeout")
+	default:
+		failoverAttempts.Inc("failure")
+	}
+}
@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/fly-examples/postgres-ha/pkg/util"
+	"github.com/jackc/pgx/v4"
+)
+
+// viewPasswordEncryption reports the configured password_encryption and
+// how each role's password is hashed.
+func viewPasswordEncryption(ctx context.Context, req *Request) (interface{}, error) {
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	return passwordEncryption(ctx, conn)
+}
+
+// migrateToSCRAM switches password_encryption to scram-sha-256 and
+// re-hashes the passwords of the users this image manages. Other md5 roles
+// keep working, but can only be migrated by setting their password again.
+func migrateToSCRAM(ctx context.Context, req *Request) (interface{}, error) {
+	env, err := util.BuildEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, err
+	}
+
+	patch := fmt.Sprintf(`{"pgParameters": {"password_encryption": %q}}`, admin.EncryptionSCRAM)
+	if _, err := stolon.Ctl([]string{"update", "--patch", patch}, env); err != nil {
+		return nil, err
+	}
+
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	// The spec change is applied asynchronously by the keepers, so hash
+	// with scram on this connection regardless.
+	if err := admin.SetSessionPasswordEncryption(ctx, conn, admin.EncryptionSCRAM); err != nil {
+		return nil, err
+	}
+
+	users, err := admin.ListUsers(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]admin.UserInfo{}
+	for _, u := range users {
+		existing[u.Username] = u
+	}
+
+	rehashed := []string{}
+	for _, creds := range []flypg.Credentials{node.SUCredentials, node.ReplCredentials, node.OperatorCredentials} {
+		user, ok := existing[creds.Username]
+		if !ok || creds.Password == "" || user.PasswordEncryption() == admin.EncryptionSCRAM {
+			continue
+		}
+
+		if err := admin.ChangePassword(ctx, conn, creds.Username, creds.Password); err != nil {
+			return nil, fmt.Errorf("failed to re-hash password for %s: %w", creds.Username, err)
+		}
+		rehashed = append(rehashed, creds.Username)
+	}
+
+	report, err := passwordEncryption(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	report.PasswordEncryption = admin.EncryptionSCRAM
+	report.Rehashed = rehashed
+
+	return report, nil
+}
+
+func passwordEncryption(ctx context.Context, conn *pgx.Conn) (*passwordEncryptionResponse, error) {
+	encryption, err := admin.PasswordEncryption(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := admin.ListUsers(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &passwordEncryptionResponse{
+		PasswordEncryption: encryption,
+		Roles:              map[string]string{},
+		MD5Roles:           []string{},
+	}
+
+	for _, u := range users {
+		method := u.PasswordEncryption()
+		if method == "" {
+			method = "none"
+		}
+		report.Roles[u.Username] = method
+
+		if method == admin.EncryptionMD5 {
+			report.MD5Roles = append(report.MD5Roles, u.Username)
+		}
+	}
+	sort.Strings(report.MD5Roles)
+
+	return report, nil
+}
@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/fly-examples/postgres-ha/pkg/auth"
+	"github.com/fly-examples/postgres-ha/pkg/render"
+	"github.com/go-chi/chi/v5"
+)
+
+// Command is an operation exposed both over HTTP and through the flyadmin
+// CLI. Both take the same json input and produce the same Response.
+type Command struct {
+	// Name is the flyadmin subcommand.
+	Name string
+	// Method and Path route the command under /commands. Commands without a
+	// Path are only available through the CLI.
+	Method string
+	Path   string
+	// Scope is the api key scope required to call the command over HTTP.
+	Scope auth.Scope
+	Run   func(ctx context.Context, req *Request) (interface{}, error)
+}
+
+// Request is the input to a command. Over HTTP params come from the URL
+// path and query string, from the CLI they are read from the json input.
+type Request struct {
+	params map[string]string
+	body   []byte
+}
+
+func (r *Request) Param(name string) string {
+	return r.params[name]
+}
+
+// Decode unmarshals the json input into v.
+func (r *Request) Decode(v interface{}) error {
+	if len(r.body) == 0 {
+		return fmt.Errorf("request body is required")
+	}
+	return json.Unmarshal(r.body, v)
+}
+
+var registry = map[string]*Command{}
+
+func register(cmds ...*Command) {
+	for _, cmd := range cmds {
+		if _, ok := registry[cmd.Name]; ok {
+			panic(fmt.Sprintf("command %s registered twice", cmd.Name))
+		}
+		registry[cmd.Name] = cmd
+	}
+}
+
+// Lookup returns the command with the given name, or nil.
+func Lookup(name string) *Command {
+	return registry[name]
+}
+
+// Names returns the names of all registered commands.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Exec runs a command with CLI input. Top level string, number and bool
+// fields in input are available as params.
+func (c *Command) Exec(ctx context.Context, input []byte) (interface{}, error) {
+	return c.Run(ctx, &Request{params: inputParams(input), body: input})
+}
+
+// inputParams returns the top level string, number and bool fields of json
+// input.
+func inputParams(input []byte) map[string]string {
+	params := map[string]string{}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(input, &fields); err == nil {
+		for key, value := range fields {
+			switch value.(type) {
+			case string, float64, bool:
+				params[key] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	return params
+}
+
+func (c *Command) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		render.Err(w, fmt.Errorf("failed to read request body: %w", err))
+		return
+	}
+
+	req := &Request{params: map[string]string{}, body: body}
+	for key, values := range r.URL.Query() {
+		req.params[key] = values[0]
+	}
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		for i, key := range rctx.URLParams.Keys {
+			req.params[key] = rctx.URLParams.Values[i]
+		}
+	}
+
+	result, err := c.Run(r.Context(), req)
+	if err != nil {
+		render.Err(w, err)
+		return
+	}
+
+	render.JSON(w, &Response{Result: result}, http.StatusOK)
+}
+
+// Exec runs the named command with CLI input.
+func Exec(ctx context.Context, name string, input []byte) (interface{}, error) {
+	cmd := Lookup(name)
+	if cmd == nil {
+		return nil, fmt.Errorf("unknown command '%s'", name)
+	}
+	return cmd.Exec(ctx, input)
+}
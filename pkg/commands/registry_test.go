@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/auth"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	routes := map[string]string{}
+
+	for _, name := range Names() {
+		cmd := Lookup(name)
+		require.NotNil(t, cmd, name)
+		assert.NotNil(t, cmd.Run, name)
+		assert.Contains(t, []auth.Scope{auth.ScopeRead, auth.ScopeAdmin}, cmd.Scope, name)
+
+		if cmd.Path == "" {
+			continue
+		}
+		route := cmd.Method + " " + cmd.Path
+		if other, ok := routes[route]; ok {
+			t.Errorf("%s and %s are both routed to %s", name, other, route)
+		}
+		routes[route] = name
+	}
+
+	// Everything that changes state requires an admin key.
+	for _, name := range []string{"user-create", "user-delete", "database-delete", "switchover", "settings-update", "stolonctl-run"} {
+		assert.Equal(t, auth.ScopeAdmin, Lookup(name).Scope, name)
+	}
+}
+
+func echoCommand() *Command {
+	return &Command{
+		Name:   "echo",
+		Method: http.MethodPost,
+		Path:   "/echo/{username}",
+		Scope:  auth.ScopeRead,
+		Run: func(ctx context.Context, req *Request) (interface{}, error) {
+			var input struct {
+				Message string `json:"message"`
+			}
+			if err := req.Decode(&input); err != nil {
+				return nil, err
+			}
+			if input.Message == "fail" {
+				return nil, errors.New("failed")
+			}
+			return req.Param("username") + ": " + input.Message, nil
+		},
+	}
+}
+
+func TestCommandExec(t *testing.T) {
+	cmd := echoCommand()
+
+	result, err := cmd.Exec(context.Background(), []byte(`{"username": "bob", "message": "hi"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "bob: hi", result)
+
+	_, err = cmd.Exec(context.Background(), nil)
+	assert.EqualError(t, err, "request body is required")
+}
+
+func TestCommandServeHTTP(t *testing.T) {
+	cmd := echoCommand()
+
+	r := chi.NewRouter()
+	r.Method(cmd.Method, cmd.Path, cmd)
+
+	cases := map[string]struct {
+		body     string
+		status   int
+		response Response
+	}{
+		"ok": {
+			body:     `{"message": "hi"}`,
+			status:   http.StatusOK,
+			response: Response{Result: "bob: hi"},
+		},
+		"error": {
+			body:     `{"message": "fail"}`,
+			status:   http.StatusInternalServerError,
+			response: Response{Error: "failed"},
+		},
+	}
+
+	for name, c := range cases {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/echo/bob", strings.NewReader(c.body)))
+		assert.Equal(t, c.status, rec.Code, name)
+
+		var res Response
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res), name)
+		assert.Equal(t, c.response, res, name)
+	}
+}
+
+func TestHandlerRequiresAuth(t *testing.T) {
+	authn := auth.New([]auth.Key{{ID: "reader", Scope: auth.ScopeRead, Secret: "s"}}, ioutil.Discard)
+	h := Handler(authn)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/list", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodDelete, "/databases/delete/app", nil)
+	req.Header.Set("Authorization", "Bearer s")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// stolonctl isn't exposed over HTTP
+	assert.Empty(t, Lookup("stolonctl-run").Path)
+	req = httptest.NewRequest(http.MethodPost, "/admin/stolonctl", strings.NewReader(`{"args": "status"}`))
+	req.Header.Set("Authorization", "Bearer s")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
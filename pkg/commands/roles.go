@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/jackc/pgx/v4"
+)
+
+func listRoles(ctx context.Context, req *Request) (interface{}, error) {
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	return admin.ListRoles(ctx, conn)
+}
+
+func updateRole(ctx context.Context, req *Request) (interface{}, error) {
+	var input updateRoleRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+	if input.Username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	if err := admin.UpdateRole(ctx, conn, input.Username, input.RoleOptions); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func grantAccess(ctx context.Context, req *Request) (interface{}, error) {
+	return applyAccess(ctx, req, admin.GrantAccess)
+}
+
+func revokeAccess(ctx context.Context, req *Request) (interface{}, error) {
+	return applyAccess(ctx, req, admin.RevokeAccess)
+}
+
+func applyAccess(ctx context.Context, req *Request, apply func(ctx context.Context, pg *pgx.Conn, database, username string) error) (interface{}, error) {
+	var input accessRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+	if input.Username == "" || input.Database == "" {
+		return nil, fmt.Errorf("username and database are required")
+	}
+
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	if err := apply(ctx, conn, input.Database, input.Username); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func grantSuperuser(ctx context.Context, req *Request) (interface{}, error) {
+	return applySuperuser(ctx, req, admin.GrantSuperuser)
+}
+
+func revokeSuperuser(ctx context.Context, req *Request) (interface{}, error) {
+	return applySuperuser(ctx, req, admin.RevokeSuperuser)
+}
+
+func applySuperuser(ctx context.Context, req *Request, apply func(ctx context.Context, pg *pgx.Conn, username string) error) (interface{}, error) {
+	var input usernameRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+	if input.Username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	if err := apply(ctx, conn, input.Username); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func createGroup(ctx context.Context, req *Request) (interface{}, error) {
+	var input createGroupRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+	if input.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	if err := admin.CreateGroup(ctx, conn, input.Name); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func grantMembership(ctx context.Context, req *Request) (interface{}, error) {
+	return applyMembership(ctx, req, admin.GrantRole)
+}
+
+func revokeMembership(ctx context.Context, req *Request) (interface{}, error) {
+	return applyMembership(ctx, req, admin.RevokeRole)
+}
+
+func applyMembership(ctx context.Context, req *Request, apply func(ctx context.Context, pg *pgx.Conn, role, member string) error) (interface{}, error) {
+	var input membershipRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+	if input.Role == "" || input.Member == "" {
+		return nil, fmt.Errorf("role and member are required")
+	}
+
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	if err := apply(ctx, conn, input.Role, input.Member); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func grantPrivileges(ctx context.Context, req *Request) (interface{}, error) {
+	return applyPrivileges(ctx, req, admin.GrantPrivileges)
+}
+
+func revokePrivileges(ctx context.Context, req *Request) (interface{}, error) {
+	return applyPrivileges(ctx, req, admin.RevokePrivileges)
+}
+
+func applyPrivileges(ctx context.Context, req *Request, apply func(ctx context.Context, pg *pgx.Conn, grant admin.PrivilegeGrant) error) (interface{}, error) {
+	var input privilegesRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+	if input.Database == "" {
+		return nil, fmt.Errorf("database is required")
+	}
+
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, err
+	}
+
+	// Schema and table privileges live in the database they apply to.
+	conn, err := node.NewProxyDatabaseConnection(ctx, input.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(ctx)
+
+	if err := apply(ctx, conn, input.PrivilegeGrant); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
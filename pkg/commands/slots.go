@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/fly-examples/postgres-ha/pkg/util"
+)
+
+// stolonSlotPrefix names the slots stolon creates for its standbys.
+const stolonSlotPrefix = "stolon_"
+
+// slotName matches what postgres accepts as a replication slot name.
+var slotName = regexp.MustCompile(`^[a-z0-9_]{1,63}$`)
+
+func listReplicationSlots(ctx context.Context, req *Request) (interface{}, error) {
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := node.GetStolonClusterData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	slots, err := admin.ListReplicationSlots(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return replicationSlotsResponse{
+		Slots:                 slots,
+		AdditionalMasterSlots: additionalMasterSlots(data),
+	}, nil
+}
+
+func createReplicationSlot(ctx context.Context, req *Request) (interface{}, error) {
+	var input replicationSlotRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+
+	return updateAdditionalMasterSlots(ctx, func(slots []string) ([]string, error) {
+		return addSlot(slots, input.Name)
+	})
+}
+
+func deleteReplicationSlot(ctx context.Context, req *Request) (interface{}, error) {
+	name := req.Param("name")
+
+	return updateAdditionalMasterSlots(ctx, func(slots []string) ([]string, error) {
+		return removeSlot(slots, name)
+	})
+}
+
+// updateAdditionalMasterSlots patches the cluster spec with the slots
+// returned by fn. Stolon then creates or drops them on the master.
+func updateAdditionalMasterSlots(ctx context.Context, fn func([]string) ([]string, error)) (interface{}, error) {
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := node.GetStolonClusterData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	slots, err := fn(additionalMasterSlots(data))
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := json.Marshal(map[string][]string{"additionalMasterReplicationSlots": slots})
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := util.BuildEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := stolon.Ctl([]string{"update", "--patch", string(patch)}, env); err != nil {
+		return nil, err
+	}
+
+	return slots, nil
+}
+
+func additionalMasterSlots(data *stolon.ClusterData) []string {
+	slots := []string{}
+	if data.Cluster != nil && data.Cluster.Spec != nil {
+		slots = append(slots, data.Cluster.Spec.AdditionalMasterReplicationSlots...)
+	}
+	return slots
+}
+
+func addSlot(slots []string, name string) ([]string, error) {
+	if !slotName.MatchString(name) {
+		return nil, fmt.Errorf("invalid slot name %q, use up to 63 lowercase letters, numbers and underscores", name)
+	}
+	if strings.HasPrefix(name, stolonSlotPrefix) {
+		return nil, fmt.Errorf("slot names starting with %s are reserved for stolon", stolonSlotPrefix)
+	}
+
+	for _, slot := range slots {
+		if slot == name {
+			return nil, fmt.Errorf("slot %s already exists", name)
+		}
+	}
+
+	return append(slots, name), nil
+}
+
+func removeSlot(slots []string, name string) ([]string, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	remaining := []string{}
+	for _, slot := range slots {
+		if slot != name {
+			remaining = append(remaining, slot)
+		}
+	}
+
+	if len(remaining) == len(slots) {
+		return nil, fmt.Errorf("%s is not an additional master slot", name)
+	}
+
+	return remaining, nil
+}
@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddSlot(t *testing.T) {
+	tests := []struct {
+		name     string
+		slots    []string
+		slot     string
+		expected []string
+		err      string
+	}{
+		{name: "first", slots: []string{}, slot: "debezium", expected: []string{"debezium"}},
+		{name: "appended", slots: []string{"a"}, slot: "b_2", expected: []string{"a", "b_2"}},
+		{name: "duplicate", slots: []string{"a"}, slot: "a", err: "already exists"},
+		{name: "uppercase", slots: []string{}, slot: "Debezium", err: "invalid slot name"},
+		{name: "empty", slots: []string{}, slot: "", err: "invalid slot name"},
+		{name: "too long", slots: []string{}, slot: "a123456789012345678901234567890123456789012345678901234567890123", err: "invalid slot name"},
+		{name: "reserved", slots: []string{}, slot: "stolon_abc", err: "reserved for stolon"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			slots, err := addSlot(tc.slots, tc.slot)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, slots)
+		})
+	}
+}
+
+func TestRemoveSlot(t *testing.T) {
+	slots, err := removeSlot([]string{"a", "b"}, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, slots)
+
+	// removing the last slot leaves an empty list, not null, in the patch
+	slots, err = removeSlot([]string{"b"}, "b")
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, slots)
+
+	_, err = removeSlot([]string{"a"}, "c")
+	assert.Error(t, err)
+
+	_, err = removeSlot([]string{"a"}, "")
+	assert.Error(t, err)
+}
+
+func TestAdditionalMasterSlots(t *testing.T) {
+	assert.Equal(t, []string{}, additionalMasterSlots(&stolon.ClusterData{}))
+
+	data := &stolon.ClusterData{Cluster: &stolon.Cluster{Spec: &stolon.ClusterSpec{
+		AdditionalMasterReplicationSlots: []string{"a"},
+	}}}
+	assert.Equal(t, []string{"a"}, additionalMasterSlots(data))
+}
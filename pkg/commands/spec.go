@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/auth"
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/fly-examples/postgres-ha/pkg/util"
+)
+
+// patchPGParameters sets params in the stolon cluster spec, a nil value
+// removes the parameter. Parameters that aren't in params are left as they
+// are. The change is recorded in the settings history as pending before the
+// spec is patched, so a change is never live without a revision.
+func patchPGParameters(ctx context.Context, params map[string]*string, reason string) error {
+	node, err := flypg.NewNode()
+	if err != nil {
+		return err
+	}
+
+	client, err := node.NewStolonClient()
+	if err != nil {
+		return err
+	}
+
+	data, err := client.ClusterData(ctx)
+	if err != nil {
+		return err
+	}
+
+	before, after := flypg.DiffParameters(specParameters(data), params)
+
+	patch, err := json.Marshal(map[string]map[string]*string{"pgParameters": params})
+	if err != nil {
+		return err
+	}
+
+	env, err := util.BuildEnv()
+	if err != nil {
+		return err
+	}
+
+	if len(after) == 0 {
+		_, err = stolon.Ctl([]string{"update", "--patch", string(patch)}, env)
+		return err
+	}
+
+	history := flypg.NewSettingsHistory(client)
+	rev := &flypg.SettingsRevision{
+		Time:   time.Now().UTC(),
+		Caller: caller(ctx),
+		Reason: reason,
+		Status: flypg.RevisionPending,
+		Before: before,
+		After:  after,
+	}
+
+	if err := history.Record(ctx, rev); err != nil {
+		return fmt.Errorf("failed to record settings revision, nothing was changed: %w", err)
+	}
+
+	if _, err = stolon.Ctl([]string{"update", "--patch", string(patch)}, env); err != nil {
+		if err := history.SetStatus(ctx, rev, flypg.RevisionFailed); err != nil {
+			logger.Printf("failed to mark settings revision %d as failed: %s", rev.Revision, err)
+		}
+		return err
+	}
+
+	if data, err = client.ClusterData(ctx); err == nil && data.Cluster != nil {
+		rev.Generation = data.Cluster.Generation
+	}
+
+	// The settings are live by now, a revision left pending only loses its
+	// status.
+	if err := history.SetStatus(ctx, rev, flypg.RevisionApplied); err != nil {
+		logger.Printf("failed to mark settings revision %d as applied: %s", rev.Revision, err)
+	}
+
+	return nil
+}
+
+// caller is the api key id of the request, commands run through flyadmin
+// aren't authenticated.
+func caller(ctx context.Context) string {
+	if id := auth.Caller(ctx); id != "" {
+		return id
+	}
+	return "flyadmin"
+}
@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
+)
+
+// proxyProcess is the name haproxy is supervised under.
+const proxyProcess = "proxy"
+
+// processController controls the processes run by the supervisor.
+type processController interface {
+	Processes() ([]supervisor.ProcessStatus, error)
+	Process(name string) (*supervisor.ProcessStatus, error)
+	StopProcess(name string) (*supervisor.ProcessStatus, error)
+	StartProcess(name string) (*supervisor.ProcessStatus, error)
+	RestartProcess(name string) (*supervisor.ProcessStatus, error)
+	Logs(name string, tail int) ([]supervisor.LogLine, error)
+}
+
+var processes processController = &remoteSupervisor{endpoint: localEndpoint}
+
+// logger is what commands log through. The CLIs print their result to
+// stdout, so until UseSupervisor is called it writes to stderr.
+var logger interface {
+	Printf(format string, a ...interface{})
+} = log.New(os.Stderr, "", 0)
+
+// UseSupervisor makes supervisor commands control svisor directly, and
+// commands log into its output. Without it, as in the flyadmin CLI, they go
+// through the local http api.
+func UseSupervisor(svisor *supervisor.Supervisor) {
+	processes = localSupervisor{svisor}
+	logger = svisor.Logger("commands")
+}
+
+type localSupervisor struct {
+	*supervisor.Supervisor
+}
+
+func (s localSupervisor) Processes() ([]supervisor.ProcessStatus, error) {
+	return s.Supervisor.Processes(), nil
+}
+
+// remoteSupervisor calls the supervisor commands of the http server running
+// alongside the supervisor.
+type remoteSupervisor struct {
+	endpoint string
+}
+
+func (s *remoteSupervisor) Processes() ([]supervisor.ProcessStatus, error) {
+	var statuses []supervisor.ProcessStatus
+	if err := s.call("supervisor-list", "", nil, &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+func (s *remoteSupervisor) Process(name string) (*supervisor.ProcessStatus, error) {
+	return s.callProcess("supervisor-status", name)
+}
+
+func (s *remoteSupervisor) StopProcess(name string) (*supervisor.ProcessStatus, error) {
+	return s.callProcess("supervisor-stop", name)
+}
+
+func (s *remoteSupervisor) StartProcess(name string) (*supervisor.ProcessStatus, error) {
+	return s.callProcess("supervisor-start", name)
+}
+
+func (s *remoteSupervisor) RestartProcess(name string) (*supervisor.ProcessStatus, error) {
+	return s.callProcess("supervisor-restart", name)
+}
+
+func (s *remoteSupervisor) Logs(name string, tail int) ([]supervisor.LogLine, error) {
+	query := url.Values{"tail": {strconv.Itoa(tail)}}
+
+	var lines []supervisor.LogLine
+	if err := s.call("supervisor-logs", name, query, &lines); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+func (s *remoteSupervisor) callProcess(command, name string) (*supervisor.ProcessStatus, error) {
+	var status supervisor.ProcessStatus
+	if err := s.call(command, name, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (s *remoteSupervisor) call(command, name string, query url.Values, result interface{}) error {
+	params := map[string]string{"name": name}
+	return callCommand(context.Background(), s.endpoint, Lookup(command), params, query, nil, result)
+}
+
+func listProcesses(ctx context.Context, req *Request) (interface{}, error) {
+	return processes.Processes()
+}
+
+func processStatus(ctx context.Context, req *Request) (interface{}, error) {
+	return processes.Process(req.Param("name"))
+}
+
+func stopProcess(ctx context.Context, req *Request) (interface{}, error) {
+	return processes.StopProcess(req.Param("name"))
+}
+
+func startProcess(ctx context.Context, req *Request) (interface{}, error) {
+	return processes.StartProcess(req.Param("name"))
+}
+
+func restartProcess(ctx context.Context, req *Request) (interface{}, error) {
+	return processes.RestartProcess(req.Param("name"))
+}
+
+// defaultLogTail is the number of log lines returned when tail isn't given.
+const defaultLogTail = 100
+
+func processLogs(ctx context.Context, req *Request) (interface{}, error) {
+	tail := defaultLogTail
+	if t := req.Param("tail"); t != "" {
+		n, err := strconv.Atoi(t)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("tail must be a number of lines, or 0 for all of them")
+		}
+		tail = n
+	}
+
+	return processes.Logs(req.Param("name"), tail)
+}
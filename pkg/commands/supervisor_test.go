@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/auth"
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessLogs(t *testing.T) {
+	svisor := supervisor.New("test", 0)
+	logger := svisor.Logger("start")
+	logger.Println("one")
+	logger.Println("two")
+	logger.Println("three")
+
+	defer func(p processController) { processes = p }(processes)
+	UseSupervisor(svisor)
+
+	h := Handler(auth.Disabled(ioutil.Discard))
+
+	cases := map[string]struct {
+		path     string
+		status   int
+		messages []string
+	}{
+		"tail":    {path: "/supervisor/start/logs?tail=2", status: http.StatusOK, messages: []string{"two", "three"}},
+		"all":     {path: "/supervisor/start/logs?tail=0", status: http.StatusOK, messages: []string{"one", "two", "three"}},
+		"default": {path: "/supervisor/start/logs", status: http.StatusOK, messages: []string{"one", "two", "three"}},
+		"invalid": {path: "/supervisor/start/logs?tail=x", status: http.StatusInternalServerError},
+		"unknown": {path: "/supervisor/missing/logs", status: http.StatusNotFound},
+	}
+
+	for name, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		require.Equal(t, c.status, rec.Code, name)
+
+		if c.messages == nil {
+			continue
+		}
+
+		var res struct {
+			Result []supervisor.LogLine `json:"result"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res), name)
+
+		var messages []string
+		for _, line := range res.Result {
+			messages = append(messages, line.Message)
+		}
+		assert.Equal(t, c.messages, messages, name)
+	}
+
+	// the cli passes tail as a json number
+	result, err := Exec(context.Background(), "supervisor-logs", []byte(`{"name": "start", "tail": 1}`))
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	assert.Equal(t, "three", result.([]supervisor.LogLine)[0].Message)
+}
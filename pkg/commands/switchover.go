@@ -0,0 +1,301 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/fly-examples/postgres-ha/pkg/util"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	defaultSwitchoverTimeout = 60 * time.Second
+	// Matches the stolon default for MaxStandbyLag.
+	defaultSwitchoverMaxLag = 1024 * 1024
+	// clearReadonlyTimeout bounds giving write access back once the
+	// switchover finished or gave up.
+	clearReadonlyTimeout = 30 * time.Second
+)
+
+// switchover hands the master role over to a specific keeper. Writes
+// are disabled on the current master until the target has caught up and
+// been promoted. The sentinel elects the standby with the most recent xlog
+// position, so once the target has caught up no other standby can be ahead
+// of it, though one that is equally caught up may be elected instead.
+func switchover(ctx context.Context, req *Request) (interface{}, error) {
+	var input switchoverRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
+	}
+
+	if input.KeeperUID == "" && input.Region == "" {
+		return nil, fmt.Errorf("a target keeper_uid or region is required")
+	}
+
+	timeout := defaultSwitchoverTimeout
+	if input.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(input.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	env, err := util.BuildEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := node.NewStolonClient()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := client.ClusterData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	masterUID := data.MasterKeeperUID()
+
+	candidates := []string{input.KeeperUID}
+	if input.KeeperUID == "" {
+		if candidates, err = node.RegionKeeperUIDs(ctx, input.Region); err != nil {
+			return nil, fmt.Errorf("failed to resolve keepers in region %s: %w", input.Region, err)
+		}
+	}
+
+	maxLag := int64(defaultSwitchoverMaxLag)
+	if input.MaxLagBytes != nil {
+		maxLag = *input.MaxLagBytes
+	} else if data.Cluster != nil && data.Cluster.Spec != nil && data.Cluster.Spec.MaxStandbyLag != nil {
+		maxLag = int64(*data.Cluster.Spec.MaxStandbyLag)
+	}
+
+	conn, close, err := proxyConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	lags, err := dbReplicationLags(ctx, conn, data)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := stolon.PlanFailover(data, stolon.PlanOptions{ReplicationLags: lags})
+
+	targetUID, err := selectSwitchoverTarget(plan, candidates, maxLag)
+	if err != nil {
+		return nil, err
+	}
+	targetApp := stolon.ApplicationName(data.FindDB(targetUID).UID)
+
+	if err := admin.SetReadonly(ctx, conn, true); err != nil {
+		return nil, err
+	}
+	readonlyAt := time.Now()
+
+	// Give write access back to the current master if we bail out before
+	// failing it.
+	revert := func(cause error) error {
+		revertCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := admin.SetReadonly(revertCtx, conn, false); err != nil {
+			return fmt.Errorf("%s (failed to disable read-only mode: %s)", cause, err)
+		}
+		return cause
+	}
+
+	// Read-only mode only applies to new sessions, existing ones could keep
+	// writing and the target would never catch up.
+	if err := admin.TerminateClientSessions(ctx, conn); err != nil {
+		return nil, revert(fmt.Errorf("failed to terminate client sessions: %w", err))
+	}
+
+	if err := waitForCatchUp(ctx, conn, targetApp); err != nil {
+		return nil, revert(fmt.Errorf("target %s failed to catch up: %w", targetUID, err))
+	}
+
+	events := stolon.NewWatcher(client, 500*time.Millisecond).WatchFrom(ctx, data)
+
+	if _, err := stolon.Failkeeper(masterUID, env); err != nil {
+		return nil, revert(err)
+	}
+
+	newMasterUID := ""
+	for event := range events {
+		if event.Type == stolon.EventMasterChanged {
+			newMasterUID = event.Current
+			break
+		}
+	}
+	if newMasterUID == "" {
+		if _, err := restoreWrites(node, client); err != nil {
+			return nil, fmt.Errorf("timed out waiting for %s to be promoted, the cluster is still read-only: %w", targetUID, err)
+		}
+		return nil, fmt.Errorf("timed out waiting for %s to be promoted, write access was restored", targetUID)
+	}
+
+	// The read-only flag is stored in the catalog and replicated, so it has
+	// to be cleared on the new master.
+	writableAt, err := restoreWrites(node, client)
+	if err != nil {
+		return nil, fmt.Errorf("%s was promoted but is still read-only: %w", newMasterUID, err)
+	}
+
+	res := switchoverResponse{
+		PreviousMaster: masterUID,
+		NewMaster:      newMasterUID,
+		ReadonlyAt:     readonlyAt,
+		WritableAt:     writableAt,
+		Downtime:       writableAt.Sub(readonlyAt).Round(time.Millisecond).String(),
+		Message:        "switchover completed successfully",
+	}
+	if newMasterUID != targetUID {
+		res.Message = fmt.Sprintf("switchover completed but %s was elected instead of %s", newMasterUID, targetUID)
+	}
+
+	return res, nil
+}
+
+// selectSwitchoverTarget returns the least lagging eligible candidate. Unlike
+// an unplanned failover the candidates only have to be caught up to within
+// maxLag, as writes are stopped until they have fully caught up.
+func selectSwitchoverTarget(plan *stolon.FailoverPlan, candidates []string, maxLag int64) (string, error) {
+	var target *stolon.KeeperPlan
+	var reasons []string
+
+	for _, uid := range candidates {
+		kp := plan.Keeper(uid)
+
+		var reason string
+		switch {
+		case kp == nil || kp.DBUID == "":
+			reason = "is not part of the cluster"
+		case uid == plan.MasterKeeperUID:
+			reason = "is already the master"
+		case !kp.Healthy || !kp.DBHealthy:
+			reason = "is unhealthy"
+		case !kp.CanBeMaster:
+			reason = "can't be master"
+		case kp.ReplicationLag == nil:
+			reason = "is not replicating from the master"
+		case *kp.ReplicationLag > maxLag:
+			reason = fmt.Sprintf("is lagging %d bytes behind, max is %d", *kp.ReplicationLag, maxLag)
+		case target == nil || *kp.ReplicationLag < *target.ReplicationLag:
+			target = kp
+		}
+
+		if reason != "" {
+			reasons = append(reasons, fmt.Sprintf("keeper %s %s", uid, reason))
+		}
+	}
+
+	if target == nil {
+		if len(reasons) == 0 {
+			return "", fmt.Errorf("no keepers found")
+		}
+		sort.Strings(reasons)
+		return "", fmt.Errorf("no eligible switchover target: %s", strings.Join(reasons, ", "))
+	}
+
+	return target.KeeperUID, nil
+}
+
+// standbyLags returns the replication lag in bytes keyed by application name.
+func standbyLags(ctx context.Context, conn *pgx.Conn) (map[string]int64, error) {
+	stats, err := admin.ResolveReplicationLag(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	lags := map[string]int64{}
+	for _, stat := range stats {
+		if stat.Diff != nil {
+			lags[stat.Name] = int64(*stat.Diff)
+		}
+	}
+
+	return lags, nil
+}
+
+func waitForCatchUp(ctx context.Context, conn *pgx.Conn, applicationName string) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		lags, err := standbyLags(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if lag, ok := lags[applicationName]; ok && lag <= 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// restoreWrites clears read-only mode on whichever keeper is master. The
+// switchover context may be nearly expired by now, so it gets its own.
+func restoreWrites(node *flypg.Node, client *stolon.Client) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clearReadonlyTimeout)
+	defer cancel()
+
+	return clearReadonly(ctx, node, client)
+}
+
+func clearReadonly(ctx context.Context, node *flypg.Node, client *stolon.Client) (time.Time, error) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		err := func() error {
+			data, err := client.ClusterData(ctx)
+			if err != nil {
+				return err
+			}
+
+			master := data.MasterDB()
+			if master == nil {
+				return fmt.Errorf("no master elected")
+			}
+
+			conn, err := node.NewDBConnection(ctx, master)
+			if err != nil {
+				return err
+			}
+			defer conn.Close(ctx)
+
+			return admin.SetReadonly(ctx, conn, false)
+		}()
+		if err == nil {
+			return time.Now(), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return time.Time{}, fmt.Errorf("%w: %s", ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
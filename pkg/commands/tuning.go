@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"context"
+	"sort"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+)
+
+// viewTuning shows what a tuning profile computes for this VM next to the
+// cluster spec and the settings postgres is running with. The profile
+// defaults to the configured one and can be previewed with ?profile=.
+func viewTuning(ctx context.Context, req *Request) (interface{}, error) {
+	profile := req.Param("profile")
+	if profile == "" {
+		var err error
+		if profile, err = flypg.TuningProfile(); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := flypg.SystemResources()
+	if err != nil {
+		return nil, err
+	}
+
+	computed, err := flypg.TuningParameters(profile, res)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := node.GetStolonClusterData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, close, err := localConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	applied, err := admin.CurrentSettings(ctx, conn, parameterNames(computed))
+	if err != nil {
+		return nil, err
+	}
+
+	return tuningResponse{
+		Profile:    profile,
+		Resources:  res,
+		Parameters: compareTuning(computed, specParameters(data), applied),
+	}, nil
+}
+
+func specParameters(data *stolon.ClusterData) stolon.PGParameters {
+	if data.Cluster == nil || data.Cluster.Spec == nil {
+		return nil
+	}
+	return data.Cluster.Spec.PGParameters
+}
+
+func compareTuning(computed, spec, applied map[string]string) []tuningParameter {
+	params := []tuningParameter{}
+	for _, name := range parameterNames(computed) {
+		params = append(params, tuningParameter{
+			Name:     name,
+			Computed: computed[name],
+			Spec:     spec[name],
+			Applied:  applied[name],
+		})
+	}
+	return params
+}
+
+func parameterNames(params map[string]string) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,5 +1,12 @@
 package commands
 
+import (
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+)
+
 type createUserRequest struct {
 	Username  string `json:"username"`
 	Password  string `json:"password"`
@@ -7,15 +14,148 @@ type createUserRequest struct {
 	Database  string `json:"databases"`
 }
 
+type usernameRequest struct {
+	Username string `json:"username"`
+}
+
+type updateRoleRequest struct {
+	Username string `json:"username"`
+	admin.RoleOptions
+}
+
+type accessRequest struct {
+	Username string `json:"username"`
+	Database string `json:"database"`
+}
+
+type membershipRequest struct {
+	Role   string `json:"role"`
+	Member string `json:"member"`
+}
+
+type createGroupRequest struct {
+	Name string `json:"name"`
+}
+
 type createDatabaseRequest struct {
 	Name string `json:"name"`
 }
 
-type failOverResponse struct {
-	Message string `json:"message"`
+type privilegesRequest struct {
+	Database string `json:"database"`
+	admin.PrivilegeGrant
+}
+
+type switchoverRequest struct {
+	KeeperUID   string `json:"keeper_uid"`
+	Region      string `json:"region"`
+	MaxLagBytes *int64 `json:"max_lag_bytes"`
+	Timeout     string `json:"timeout"`
+}
+
+type switchoverResponse struct {
+	PreviousMaster string    `json:"previous_master"`
+	NewMaster      string    `json:"new_master"`
+	ReadonlyAt     time.Time `json:"readonly_at"`
+	WritableAt     time.Time `json:"writable_at"`
+	Downtime       string    `json:"downtime"`
+	Message        string    `json:"message"`
 }
 
 type Response struct {
 	Result interface{} `json:"result,omitempty"`
 	Error  string      `json:"error,omitempty"`
 }
+
+type passwordEncryptionResponse struct {
+	PasswordEncryption string            `json:"password_encryption"`
+	Roles              map[string]string `json:"roles"`
+	MD5Roles           []string          `json:"md5_roles"`
+	Rehashed           []string          `json:"rehashed,omitempty"`
+}
+
+type stolonctlRequest struct {
+	Args string `json:"args"`
+}
+
+type replicationSlotRequest struct {
+	Name string `json:"name"`
+}
+
+type replicationSlotsResponse struct {
+	Slots []admin.ReplicationSlot `json:"slots"`
+	// AdditionalMasterSlots are the slots kept by the cluster spec, stolon
+	// drops any other slot that isn't one of its own.
+	AdditionalMasterSlots []string `json:"additional_master_slots"`
+}
+
+type tuningResponse struct {
+	Profile    string            `json:"profile"`
+	Resources  flypg.Resources   `json:"resources"`
+	Parameters []tuningParameter `json:"parameters"`
+}
+
+type tuningParameter struct {
+	Name     string `json:"name"`
+	Computed string `json:"computed"`
+	// Spec is the value in the stolon cluster spec, empty when stolon leaves
+	// it to the postgres default.
+	Spec    string `json:"spec,omitempty"`
+	Applied string `json:"applied"`
+}
+
+type extensionRequest struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+	admin.ExtensionOptions
+}
+
+type extension struct {
+	admin.Extension
+	// PreloadLibrary is the library the extension needs in
+	// shared_preload_libraries.
+	PreloadLibrary string `json:"preload_library,omitempty"`
+	Preloaded      bool   `json:"preloaded,omitempty"`
+}
+
+type extensionsResponse struct {
+	Database         string      `json:"database"`
+	PreloadLibraries []string    `json:"preload_libraries"`
+	Extensions       []extension `json:"extensions"`
+}
+
+type extensionResponse struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+	// RestartPending is set when postgres has to restart to load the
+	// extension's library before it can be created.
+	RestartPending   bool     `json:"restart_pending"`
+	PreloadLibraries []string `json:"preload_libraries,omitempty"`
+	Message          string   `json:"message"`
+}
+
+type settingsUpdateRequest struct {
+	// PGParameters are patched into the cluster spec, null resets a
+	// parameter to its default.
+	PGParameters map[string]*string `json:"pgParameters"`
+	// Reason is kept in the settings history.
+	Reason string `json:"reason"`
+}
+
+type settingsRollbackRequest struct {
+	Revision *int64 `json:"revision"`
+}
+
+type settingChange struct {
+	flypg.Setting
+	// Apply is how the change takes effect: reload, restart or superuser.
+	Apply string `json:"apply"`
+}
+
+type settingsUpdate struct {
+	Changes []settingChange `json:"changes"`
+	// RestartRequired are the keepers running postgres with old values until
+	// it restarts.
+	RestartRequired []string `json:"restart_required"`
+	Message         string   `json:"message"`
+}
@@ -1,117 +1,81 @@
 package commands
 
 import (
-	"encoding/json"
-	"net/http"
+	"context"
+	"fmt"
 
 	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
-	"github.com/fly-examples/postgres-ha/pkg/render"
-	"github.com/go-chi/chi/v5"
 )
 
-func handleListUsers(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := proxyConnection(r.Context())
+func listUsers(ctx context.Context, req *Request) (interface{}, error) {
+	conn, close, err := proxyConnection(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 	defer close()
 
-	users, err := admin.ListUsers(r.Context(), conn)
-	if err != nil {
-		render.Err(w, err)
-		return
-	}
-	res := &Response{
-		Result: users,
-	}
-
-	render.JSON(w, res, http.StatusOK)
-
+	return admin.ListUsers(ctx, conn)
 }
 
-func handleFindUser(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := proxyConnection(r.Context())
+func findUser(ctx context.Context, req *Request) (interface{}, error) {
+	conn, close, err := proxyConnection(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 	defer close()
 
-	name := chi.URLParam(r, "name")
+	return admin.FindUser(ctx, conn, req.Param("username"))
+}
 
-	user, err := admin.FindUser(r.Context(), conn, name)
-	if err != nil {
-		render.Err(w, err)
-		return
+func createUser(ctx context.Context, req *Request) (interface{}, error) {
+	var input createUserRequest
+	if err := req.Decode(&input); err != nil {
+		return nil, err
 	}
-	res := &Response{
-		Result: user,
+	if input.Username == "" {
+		return nil, fmt.Errorf("username is required")
 	}
-	render.JSON(w, res, http.StatusOK)
-}
 
-func handleCreateUser(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := proxyConnection(r.Context())
+	conn, close, err := proxyConnection(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 	defer close()
 
-	var input createUserRequest
-
-	err = json.NewDecoder(r.Body).Decode(&input)
-	if err != nil {
-		render.Err(w, err)
-		return
-	}
-	defer r.Body.Close()
-
-	err = admin.CreateUser(r.Context(), conn, input.Username, input.Password)
-	if err != nil {
-		render.Err(w, err)
-		return
+	if err := admin.CreateUser(ctx, conn, input.Username, input.Password); err != nil {
+		return nil, err
 	}
 
 	if input.Database != "" {
-		err = admin.GrantAccess(r.Context(), conn, input.Username, input.Database)
-		if err != nil {
-			render.Err(w, err)
-			return
+		if err := admin.GrantAccess(ctx, conn, input.Database, input.Username); err != nil {
+			return nil, err
 		}
 	}
 
 	if input.Superuser {
-		err = admin.GrantSuperuser(r.Context(), conn, input.Username)
-		if err != nil {
-			render.Err(w, err)
-			return
+		if err := admin.GrantSuperuser(ctx, conn, input.Username); err != nil {
+			return nil, err
 		}
 	}
-	res := &Response{
-		Result: true,
-	}
 
-	render.JSON(w, res, http.StatusOK)
+	return true, nil
 }
 
-func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
-	conn, close, err := proxyConnection(r.Context())
+func deleteUser(ctx context.Context, req *Request) (interface{}, error) {
+	username := req.Param("username")
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+
+	conn, close, err := proxyConnection(ctx)
 	if err != nil {
-		render.Err(w, err)
-		return
+		return nil, err
 	}
 	defer close()
 
-	name := chi.URLParam(r, "name")
-
-	err = admin.DeleteUser(r.Context(), conn, name)
-	if err != nil {
-		render.Err(w, err)
-		return
+	if err := admin.DeleteUser(ctx, conn, username); err != nil {
+		return nil, err
 	}
-	res := &Response{Result: true}
 
-	render.JSON(w, res, http.StatusOK)
+	return true, nil
 }
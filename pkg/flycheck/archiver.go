@@ -0,0 +1,71 @@
+package flycheck
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+)
+
+// ArchiverHistory keeps the status seen by the previous archiver check, to
+// tell whether failures and waiting segments are growing. The same history
+// has to be passed to every run of CheckPostgreSQL.
+type ArchiverHistory struct {
+	mu   sync.Mutex
+	last *admin.ArchiverStatus
+}
+
+// swap records status and returns the previous one, nil on the first check.
+func (h *ArchiverHistory) swap(status *admin.ArchiverStatus) *admin.ArchiverStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prev := h.last
+	h.last = status
+	return prev
+}
+
+// checkArchiver fails if archive_command failed since the previous check,
+// segments have been waiting for longer than ArchiveStaleTimeouts
+// archive_timeouts, or more than ArchiveReadyFiles are waiting and the
+// number is growing. The value is the number of segments waiting.
+func checkArchiver(prev, cur *admin.ArchiverStatus, now time.Time, thresholds Thresholds) (interface{}, string, error) {
+	if cur.ArchiveMode == "off" {
+		return cur.ReadyFiles, "archiving is disabled", nil
+	}
+
+	// counters go back to zero when the stats are reset
+	if prev != nil && cur.FailedCount < prev.FailedCount {
+		prev = nil
+	}
+
+	var problems []string
+
+	if prev != nil && cur.FailedCount > prev.FailedCount && cur.Failing() {
+		problems = append(problems, fmt.Sprintf("archive_command failed %d times since the last check, last on %s",
+			cur.FailedCount-prev.FailedCount, cur.LastFailedWAL))
+	}
+
+	stale := time.Duration(float64(cur.ArchiveTimeout) * thresholds.ArchiveStaleTimeouts * float64(time.Second))
+	if cur.ReadyFiles > 0 && stale > 0 && cur.LastArchivedTime != nil && now.Sub(*cur.LastArchivedTime) > stale {
+		problems = append(problems, fmt.Sprintf("nothing archived for %s with %d segments waiting",
+			now.Sub(*cur.LastArchivedTime).Round(time.Second), cur.ReadyFiles))
+	}
+
+	if prev != nil && cur.ReadyFiles > thresholds.ArchiveReadyFiles && cur.ReadyFiles > prev.ReadyFiles {
+		problems = append(problems, fmt.Sprintf("%d segments waiting to be archived, up from %d",
+			cur.ReadyFiles, prev.ReadyFiles))
+	}
+
+	if len(problems) > 0 {
+		return cur.ReadyFiles, "", fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+
+	if cur.LastArchivedTime == nil {
+		return cur.ReadyFiles, fmt.Sprintf("nothing archived yet, %d segments waiting", cur.ReadyFiles), nil
+	}
+	return cur.ReadyFiles, fmt.Sprintf("%s archived %s ago, %d segments waiting",
+		cur.LastArchivedWAL, now.Sub(*cur.LastArchivedTime).Round(time.Second), cur.ReadyFiles), nil
+}
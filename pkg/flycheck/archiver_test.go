@@ -0,0 +1,133 @@
+package flycheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckArchiver(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time {
+		at := now.Add(-d)
+		return &at
+	}
+
+	healthy := admin.ArchiverStatus{
+		ArchiveMode:      "on",
+		ArchiveTimeout:   60,
+		ArchivedCount:    100,
+		LastArchivedWAL:  "000000010000000000000064",
+		LastArchivedTime: ago(30 * time.Second),
+	}
+
+	tests := []struct {
+		name string
+		prev *admin.ArchiverStatus
+		cur  func(s *admin.ArchiverStatus)
+		err  string
+	}{
+		{
+			name: "healthy",
+			prev: &healthy,
+			cur:  func(s *admin.ArchiverStatus) {},
+		},
+		{
+			name: "disabled",
+			cur: func(s *admin.ArchiverStatus) {
+				s.ArchiveMode = "off"
+				s.ReadyFiles = 50
+			},
+		},
+		{
+			name: "failures since the last check",
+			prev: &healthy,
+			cur: func(s *admin.ArchiverStatus) {
+				s.FailedCount = 3
+				s.LastFailedWAL = "000000010000000000000065"
+				s.LastFailedTime = ago(5 * time.Second)
+			},
+			err: "archive_command failed 3 times since the last check, last on 000000010000000000000065",
+		},
+		{
+			name: "failures that have recovered",
+			prev: &healthy,
+			cur: func(s *admin.ArchiverStatus) {
+				s.FailedCount = 3
+				s.LastFailedTime = ago(time.Minute)
+			},
+		},
+		{
+			name: "failures on the first check",
+			cur: func(s *admin.ArchiverStatus) {
+				s.FailedCount = 3
+				s.LastFailedTime = ago(5 * time.Second)
+			},
+		},
+		{
+			name: "stale with segments waiting",
+			prev: &healthy,
+			cur: func(s *admin.ArchiverStatus) {
+				s.LastArchivedTime = ago(10 * time.Minute)
+				s.ReadyFiles = 2
+			},
+			err: "nothing archived for 10m0s with 2 segments waiting",
+		},
+		{
+			name: "idle",
+			prev: &healthy,
+			cur: func(s *admin.ArchiverStatus) {
+				s.LastArchivedTime = ago(10 * time.Hour)
+			},
+		},
+		{
+			name: "ready files growing",
+			prev: &admin.ArchiverStatus{ArchiveMode: "on", ReadyFiles: 12},
+			cur: func(s *admin.ArchiverStatus) {
+				s.ReadyFiles = 15
+			},
+			err: "15 segments waiting to be archived, up from 12",
+		},
+		{
+			name: "ready files shrinking",
+			prev: &admin.ArchiverStatus{ArchiveMode: "on", ReadyFiles: 20},
+			cur: func(s *admin.ArchiverStatus) {
+				s.ReadyFiles = 15
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cur := healthy
+			tc.cur(&cur)
+
+			value, msg, err := checkArchiver(tc.prev, &cur, now, DefaultThresholds)
+			assert.Equal(t, cur.ReadyFiles, value)
+
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Equal(t, tc.err, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, msg)
+		})
+	}
+}
+
+func TestArchiverHistory(t *testing.T) {
+	var h ArchiverHistory
+	first := &admin.ArchiverStatus{FailedCount: 1}
+	second := &admin.ArchiverStatus{FailedCount: 2}
+
+	assert.Nil(t, h.swap(first))
+	assert.Equal(t, first, h.swap(second))
+
+	// histories are independent of each other
+	var other ArchiverHistory
+	assert.Nil(t, other.swap(first))
+}
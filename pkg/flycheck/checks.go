@@ -2,31 +2,43 @@ package flycheck
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
 
-	suite "github.com/superfly/fly-checks/check"
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
 )
 
 const Port = 5500
 
-func Handler() http.Handler {
+// Handler serves the check suites. Each responds in text, or json when
+// requested with ?format=json.
+func Handler(svisor *supervisor.Supervisor, thresholds Thresholds) http.Handler {
 	r := http.NewServeMux()
-
-	r.HandleFunc("/flycheck/vm", runVMChecks)
-	r.HandleFunc("/flycheck/pg", runPGChecks)
-	r.HandleFunc("/flycheck/role", runRoleCheck)
+	archiver := &ArchiverHistory{}
+	logger := svisor.Logger("flycheck")
+
+	r.HandleFunc("/flycheck/vm", func(w http.ResponseWriter, r *http.Request) {
+		runVMChecks(w, r, svisor, thresholds)
+	})
+	r.HandleFunc("/flycheck/pg", func(w http.ResponseWriter, r *http.Request) {
+		runPGChecks(w, r, thresholds, archiver)
+	})
+	r.HandleFunc("/flycheck/role", func(w http.ResponseWriter, r *http.Request) {
+		runRoleCheck(w, r, thresholds, logger)
+	})
+	r.HandleFunc("/flycheck/cluster", runClusterChecks)
 
 	return r
 }
 
-func runVMChecks(w http.ResponseWriter, r *http.Request) {
+func runVMChecks(w http.ResponseWriter, r *http.Request, svisor *supervisor.Supervisor, thresholds Thresholds) {
 	ctx, cancel := context.WithTimeout(r.Context(), (5 * time.Second))
 	defer cancel()
-	suite := &suite.CheckSuite{Name: "VM"}
-	suite = CheckVM(suite)
+	suite := NewSuite("VM")
+	suite = CheckVM(suite, svisor, thresholds)
 
 	go func(ctx context.Context) {
 		suite.Process(ctx)
@@ -35,14 +47,14 @@ func runVMChecks(w http.ResponseWriter, r *http.Request) {
 
 	<-ctx.Done()
 
-	handleCheckResponse(w, suite, false)
+	handleCheckResponse(w, r, suite, false)
 }
 
-func runPGChecks(w http.ResponseWriter, r *http.Request) {
+func runPGChecks(w http.ResponseWriter, r *http.Request, thresholds Thresholds, archiver *ArchiverHistory) {
 	ctx, cancel := context.WithTimeout(r.Context(), (5 * time.Second))
 	defer cancel()
-	suite := &suite.CheckSuite{Name: "PG"}
-	suite, err := CheckPostgreSQL(ctx, suite)
+	suite := NewSuite("PG")
+	suite, err := CheckPostgreSQL(ctx, suite, thresholds, archiver)
 	if err != nil {
 		suite.ErrOnSetup = err
 		cancel()
@@ -55,15 +67,15 @@ func runPGChecks(w http.ResponseWriter, r *http.Request) {
 
 	<-ctx.Done()
 
-	handleCheckResponse(w, suite, false)
+	handleCheckResponse(w, r, suite, false)
 }
 
-func runRoleCheck(w http.ResponseWriter, r *http.Request) {
+func runRoleCheck(w http.ResponseWriter, r *http.Request, thresholds Thresholds, logger *supervisor.Logger) {
 	ctx, cancel := context.WithTimeout(r.Context(), (time.Second * 5))
 	defer cancel()
 
-	suite := &suite.CheckSuite{Name: "Role"}
-	suite, err := PostgreSQLRole(ctx, suite)
+	suite := NewSuite("Role")
+	suite, err := PostgreSQLRole(ctx, suite, thresholds, logger)
 	if err != nil {
 		suite.ErrOnSetup = err
 		cancel()
@@ -76,10 +88,39 @@ func runRoleCheck(w http.ResponseWriter, r *http.Request) {
 
 	<-ctx.Done()
 
-	handleCheckResponse(w, suite, true)
+	handleCheckResponse(w, r, suite, true)
 }
 
-func handleCheckResponse(w http.ResponseWriter, suite *suite.CheckSuite, raw bool) {
+func runClusterChecks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), (5 * time.Second))
+	defer cancel()
+
+	suite := NewSuite("Cluster")
+	suite, err := CheckCluster(ctx, suite)
+	if err != nil {
+		suite.ErrOnSetup = err
+		cancel()
+	}
+
+	go func() {
+		suite.Process(ctx)
+		cancel()
+	}()
+
+	<-ctx.Done()
+
+	handleCheckResponse(w, r, suite, false)
+}
+
+func handleCheckResponse(w http.ResponseWriter, r *http.Request, suite *Suite, raw bool) {
+	results := suite.Results()
+	recordMetrics(results)
+
+	if r.URL.Query().Get("format") == "json" {
+		handleJSONResponse(w, results)
+		return
+	}
+
 	if suite.ErrOnSetup != nil {
 		handleError(w, suite.ErrOnSetup)
 		return
@@ -97,6 +138,14 @@ func handleCheckResponse(w http.ResponseWriter, suite *suite.CheckSuite, raw boo
 	io.WriteString(w, result)
 }
 
+func handleJSONResponse(w http.ResponseWriter, result SuiteResult) {
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Passed {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
 func handleError(w http.ResponseWriter, err error) {
 	w.WriteHeader(http.StatusInternalServerError)
 	io.WriteString(w, err.Error())
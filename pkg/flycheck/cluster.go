@@ -0,0 +1,152 @@
+package flycheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/pkg/errors"
+)
+
+// CheckCluster verifies this node against stolon's view of the cluster.
+func CheckCluster(ctx context.Context, checks *Suite) (*Suite, error) {
+	node, err := flypg.NewNode()
+	if err != nil {
+		return checks, errors.Wrap(err, "failed to initialize node")
+	}
+
+	client, err := node.NewStolonClient()
+	if err != nil {
+		return checks, errors.Wrap(err, "failed to initialize store client")
+	}
+
+	cd, err := client.ClusterData(ctx)
+	if err != nil {
+		return checks, errors.Wrap(err, "failed to read cluster data")
+	}
+
+	keeperUID := node.KeeperUID
+
+	checks.AddCheck("keeper", func() (string, error) {
+		return checkKeeper(cd, keeperUID)
+	})
+
+	checks.AddCheck("phase", func() (string, error) {
+		return checkPhase(cd)
+	})
+
+	checks.AddCheck("sentinels", func() (string, error) {
+		uids, err := client.Sentinels(ctx)
+		if err != nil {
+			return "", err
+		}
+		return checkSentinels(uids)
+	})
+
+	checks.AddCheck("master", func() (string, error) {
+		return checkMaster(cd)
+	})
+
+	checks.AddCheck("generation", func() (string, error) {
+		return checkGeneration(cd, keeperUID)
+	})
+
+	checks.AddCheck("role", func() (string, error) {
+		conn, err := node.NewLocalConnection(ctx)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close(ctx)
+
+		role, err := admin.ResolveRole(ctx, conn)
+		if err != nil {
+			return "", err
+		}
+		return checkDBRole(cd, keeperUID, role)
+	})
+
+	return checks, nil
+}
+
+func checkKeeper(cd *stolon.ClusterData, keeperUID string) (string, error) {
+	keeper, ok := cd.Keepers[keeperUID]
+	if !ok {
+		return "", fmt.Errorf("keeper %s is not part of the cluster", keeperUID)
+	}
+	if keeper.Status.ForceFail {
+		return "", fmt.Errorf("keeper %s is marked to be force failed", keeperUID)
+	}
+	if !keeper.Status.Healthy {
+		return "", fmt.Errorf("keeper %s is unhealthy", keeperUID)
+	}
+
+	return fmt.Sprintf("keeper %s is healthy", keeperUID), nil
+}
+
+func checkPhase(cd *stolon.ClusterData) (string, error) {
+	if cd.Cluster == nil {
+		return "", fmt.Errorf("cluster data has no cluster")
+	}
+
+	phase := cd.Cluster.Status.Phase
+	if phase != stolon.ClusterPhaseNormal {
+		return "", fmt.Errorf("cluster is %s", phase)
+	}
+
+	return fmt.Sprintf("cluster is %s", phase), nil
+}
+
+func checkSentinels(uids []string) (string, error) {
+	if len(uids) == 0 {
+		return "", fmt.Errorf("no sentinels are running")
+	}
+
+	return fmt.Sprintf("%d sentinels: %s", len(uids), strings.Join(uids, ", ")), nil
+}
+
+func checkMaster(cd *stolon.ClusterData) (string, error) {
+	master := cd.MasterDB()
+	if master == nil {
+		return "", fmt.Errorf("no master is elected")
+	}
+
+	return fmt.Sprintf("master is keeper %s", cd.MasterKeeperUID()), nil
+}
+
+// checkGeneration fails while the keeper hasn't applied the latest spec of
+// its db.
+func checkGeneration(cd *stolon.ClusterData, keeperUID string) (string, error) {
+	db := cd.FindDB(keeperUID)
+	if db == nil {
+		return "", fmt.Errorf("keeper %s has no db", keeperUID)
+	}
+
+	if db.Status.CurrentGeneration < db.Generation {
+		return "", fmt.Errorf("db %s is at generation %d of %d", db.UID, db.Status.CurrentGeneration, db.Generation)
+	}
+
+	return fmt.Sprintf("db %s is at generation %d", db.UID, db.Generation), nil
+}
+
+// checkDBRole compares the role postgres reports, as returned by
+// admin.ResolveRole, with the one stolon assigned the db.
+func checkDBRole(cd *stolon.ClusterData, keeperUID string, role string) (string, error) {
+	db := cd.FindDB(keeperUID)
+	if db == nil || db.Spec == nil {
+		return "", fmt.Errorf("keeper %s has no db", keeperUID)
+	}
+
+	expected := "replica"
+	if db.Spec.Role == string(stolon.ClusterRoleMaster) {
+		expected = "leader"
+	}
+
+	if role != expected {
+		return "", fmt.Errorf("postgres is running as %s but stolon assigned the db the %s role", role, db.Spec.Role)
+	}
+
+	return fmt.Sprintf("running as %s", db.Spec.Role), nil
+}
@@ -0,0 +1,131 @@
+package flycheck
+
+import (
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testClusterData() *stolon.ClusterData {
+	return &stolon.ClusterData{
+		Cluster: &stolon.Cluster{
+			Status: stolon.ClusterStatus{Phase: stolon.ClusterPhaseNormal, Master: "db1"},
+		},
+		Keepers: stolon.Keepers{
+			"keeper1": {UID: "keeper1", Status: stolon.KeeperStatus{Healthy: true}},
+			"keeper2": {UID: "keeper2", Status: stolon.KeeperStatus{Healthy: true}},
+		},
+		DBs: stolon.DBs{
+			"db1": {UID: "db1", Generation: 3, Spec: &stolon.DBSpec{KeeperUID: "keeper1", Role: "master"}, Status: stolon.DBStatus{CurrentGeneration: 3}},
+			"db2": {UID: "db2", Generation: 5, Spec: &stolon.DBSpec{KeeperUID: "keeper2", Role: "standby"}, Status: stolon.DBStatus{CurrentGeneration: 5}},
+		},
+	}
+}
+
+func TestClusterChecks(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cd *stolon.ClusterData)
+		check  func(cd *stolon.ClusterData) (string, error)
+		err    string
+	}{
+		{
+			name:  "healthy keeper",
+			check: func(cd *stolon.ClusterData) (string, error) { return checkKeeper(cd, "keeper1") },
+		},
+		{
+			name:  "unknown keeper",
+			check: func(cd *stolon.ClusterData) (string, error) { return checkKeeper(cd, "keeper9") },
+			err:   "keeper keeper9 is not part of the cluster",
+		},
+		{
+			name:   "unhealthy keeper",
+			modify: func(cd *stolon.ClusterData) { cd.Keepers["keeper1"].Status.Healthy = false },
+			check:  func(cd *stolon.ClusterData) (string, error) { return checkKeeper(cd, "keeper1") },
+			err:    "keeper keeper1 is unhealthy",
+		},
+		{
+			name:   "force failed keeper",
+			modify: func(cd *stolon.ClusterData) { cd.Keepers["keeper1"].Status.ForceFail = true },
+			check:  func(cd *stolon.ClusterData) (string, error) { return checkKeeper(cd, "keeper1") },
+			err:    "keeper keeper1 is marked to be force failed",
+		},
+		{
+			name:  "normal phase",
+			check: checkPhase,
+		},
+		{
+			name:   "initializing",
+			modify: func(cd *stolon.ClusterData) { cd.Cluster.Status.Phase = stolon.ClusterPhaseInitializing },
+			check:  checkPhase,
+			err:    "cluster is initializing",
+		},
+		{
+			name:  "master",
+			check: checkMaster,
+		},
+		{
+			name:   "no master",
+			modify: func(cd *stolon.ClusterData) { cd.Cluster.Status.Master = "" },
+			check:  checkMaster,
+			err:    "no master is elected",
+		},
+		{
+			name:  "converged",
+			check: func(cd *stolon.ClusterData) (string, error) { return checkGeneration(cd, "keeper2") },
+		},
+		{
+			name:   "trailing generation",
+			modify: func(cd *stolon.ClusterData) { cd.DBs["db2"].Generation = 6 },
+			check:  func(cd *stolon.ClusterData) (string, error) { return checkGeneration(cd, "keeper2") },
+			err:    "db db2 is at generation 5 of 6",
+		},
+		{
+			name:  "no db",
+			check: func(cd *stolon.ClusterData) (string, error) { return checkGeneration(cd, "keeper9") },
+			err:   "keeper keeper9 has no db",
+		},
+		{
+			name:  "master role",
+			check: func(cd *stolon.ClusterData) (string, error) { return checkDBRole(cd, "keeper1", "leader") },
+		},
+		{
+			name:  "standby role",
+			check: func(cd *stolon.ClusterData) (string, error) { return checkDBRole(cd, "keeper2", "replica") },
+		},
+		{
+			name:  "role mismatch",
+			check: func(cd *stolon.ClusterData) (string, error) { return checkDBRole(cd, "keeper2", "leader") },
+			err:   "postgres is running as leader but stolon assigned the db the standby role",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cd := testClusterData()
+			if tc.modify != nil {
+				tc.modify(cd)
+			}
+
+			msg, err := tc.check(cd)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Equal(t, tc.err, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, msg)
+		})
+	}
+}
+
+func TestCheckSentinels(t *testing.T) {
+	_, err := checkSentinels([]string{})
+	assert.EqualError(t, err, "no sentinels are running")
+
+	msg, err := checkSentinels([]string{"a1", "b2"})
+	require.NoError(t, err)
+	assert.Equal(t, "2 sentinels: a1, b2", msg)
+}
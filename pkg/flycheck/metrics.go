@@ -0,0 +1,73 @@
+package flycheck
+
+import (
+	"github.com/fly-examples/postgres-ha/pkg/metrics"
+)
+
+var (
+	suitePassing = metrics.NewGaugeVec("flypg_check_suite_passing",
+		"Whether every check of the suite passed on its last run.", "suite")
+	checkPassing = metrics.NewGaugeVec("flypg_check_passing",
+		"Whether the check passed on its last run.", "suite", "check")
+	checkDuration = metrics.NewGaugeVec("flypg_check_duration_seconds",
+		"How long the check took on its last run.", "suite", "check")
+	checkValue = metrics.NewGaugeVec("flypg_check_value",
+		"The value the check measured on its last run, for checks that measure a single number.", "suite", "check")
+	checkRuns = metrics.NewCounterVec("flypg_check_runs_total",
+		"Check runs by status.", "suite", "check", "status")
+)
+
+// recordMetrics records the results of a suite run. Checks that share a
+// name, like the replicationLag check of each replica, are reported as one:
+// passing if all passed, with the highest value and the total duration.
+func recordMetrics(result SuiteResult) {
+	suitePassing.SetBool(result.Passed, result.Name)
+
+	type merged struct {
+		passing  bool
+		duration float64
+		value    *float64
+	}
+
+	var names []string
+	checks := map[string]*merged{}
+
+	for _, check := range result.Checks {
+		checkRuns.Inc(result.Name, check.Name, check.Status)
+
+		m, ok := checks[check.Name]
+		if !ok {
+			m = &merged{passing: true}
+			checks[check.Name] = m
+			names = append(names, check.Name)
+		}
+
+		m.passing = m.passing && check.Passed()
+		m.duration += check.DurationMs / 1000
+
+		if v, ok := number(check.Value); ok && (m.value == nil || v > *m.value) {
+			m.value = &v
+		}
+	}
+
+	for _, name := range names {
+		m := checks[name]
+		checkPassing.SetBool(m.passing, result.Name, name)
+		checkDuration.Set(m.duration, result.Name, name)
+		if m.value != nil {
+			checkValue.Set(*m.value, result.Name, name)
+		}
+	}
+}
+
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -8,16 +8,16 @@ import (
 	"time"
 
 	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
 	"github.com/fly-examples/postgres-ha/pkg/privnet"
 	"github.com/pkg/errors"
-	chk "github.com/superfly/fly-checks/check"
 
 	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 )
 
 // CheckPostgreSQL health, replication, etc
-func CheckPostgreSQL(ctx context.Context, checks *chk.CheckSuite) (*chk.CheckSuite, error) {
+func CheckPostgreSQL(ctx context.Context, checks *Suite, thresholds Thresholds, archiver *ArchiverHistory) (*Suite, error) {
 
 	node, err := flypg.NewNode()
 	if err != nil {
@@ -60,13 +60,30 @@ func CheckPostgreSQL(ctx context.Context, checks *chk.CheckSuite) (*chk.CheckSui
 		for _, entry := range entries {
 			msg := fmt.Sprintf("%s is lagging %s", entry.Client, entry.ReplayLag)
 			lag := entry.ReplayLag
-			checks.AddCheck("replicationLag", func() (string, error) {
-				if lag >= 3*time.Second {
-					return "", fmt.Errorf(msg)
+			// reported in seconds
+			checks.AddMeasuredCheck("replicationLag", thresholds.ReplicationLag.Seconds(), func() (interface{}, string, error) {
+				if lag >= thresholds.ReplicationLag {
+					return lag.Seconds(), "", fmt.Errorf(msg)
 				}
-				return msg, nil
+				return lag.Seconds(), msg, nil
 			})
 		}
+
+		checks.AddMeasuredCheck("replicationSlots", thresholds.SlotRetainedWAL, func() (interface{}, string, error) {
+			slots, err := admin.ListReplicationSlots(ctx, leaderConn)
+			if err != nil {
+				return nil, "", err
+			}
+			return checkReplicationSlots(slots, thresholds.SlotRetainedWAL)
+		})
+
+		checks.AddMeasuredCheck("archiver", thresholds.ArchiveReadyFiles, func() (interface{}, string, error) {
+			status, err := admin.ResolveArchiverStatus(ctx, localConn)
+			if err != nil {
+				return nil, "", err
+			}
+			return checkArchiver(archiver.swap(status), status, time.Now(), thresholds)
+		})
 	}
 
 	if !isLeader {
@@ -84,9 +101,177 @@ func CheckPostgreSQL(ctx context.Context, checks *chk.CheckSuite) (*chk.CheckSui
 		return connectionCount(ctx, localConn)
 	})
 
+	checks.AddMeasuredCheck("xidAge", warnFail(thresholds.XIDAgeWarn, thresholds.XIDAge), func() (interface{}, string, error) {
+		ages, err := admin.ListXIDAges(ctx, localConn)
+		if err != nil {
+			return nil, "", err
+		}
+		return checkXIDAge(ages, thresholds.XIDAgeWarn, thresholds.XIDAge)
+	})
+
+	// reported in seconds
+	transactionAge := warnFail(thresholds.TransactionAgeWarn.Seconds(), thresholds.TransactionAge.Seconds())
+	checks.AddMeasuredCheck("longTransactions", transactionAge, func() (interface{}, string, error) {
+		sessions, err := admin.OldestTransactions(ctx, localConn, sessionLimit)
+		if err != nil {
+			return nil, "", err
+		}
+		return checkLongTransactions(sessions, thresholds.TransactionAgeWarn, thresholds.TransactionAge)
+	})
+
+	checks.AddMeasuredCheck("xminHolders", warnFail(thresholds.XminAgeWarn, thresholds.XminAge), func() (interface{}, string, error) {
+		sessions, err := admin.XminHolders(ctx, localConn, sessionLimit)
+		if err != nil {
+			return nil, "", err
+		}
+		return checkXminHolders(sessions, thresholds.XminAgeWarn, thresholds.XminAge)
+	})
+
 	return checks, nil
 }
 
+// checkReplicationSlots fails if a slot is inactive or retains more than
+// maxRetained bytes of WAL. Stolon's own slots are inactive for as long as
+// their keeper restarts, so they only warn until they retain too much. The
+// value is the most WAL retained by a slot.
+func checkReplicationSlots(slots []admin.ReplicationSlot, maxRetained int64) (interface{}, string, error) {
+	var retained int64
+	var failing, warning []string
+
+	for _, slot := range slots {
+		if slot.RetainedBytes != nil && *slot.RetainedBytes > retained {
+			retained = *slot.RetainedBytes
+		}
+
+		switch {
+		case slot.RetainedBytes != nil && *slot.RetainedBytes > maxRetained:
+			problem := fmt.Sprintf("%s retains %s of WAL", slot.Name, dataSize(uint64(*slot.RetainedBytes)))
+			if !slot.Active {
+				problem = fmt.Sprintf("%s is inactive and retains %s of WAL", slot.Name, dataSize(uint64(*slot.RetainedBytes)))
+			}
+			failing = append(failing, problem)
+		case !slot.Active && strings.HasPrefix(slot.Name, "stolon_"):
+			warning = append(warning, fmt.Sprintf("%s is inactive", slot.Name))
+		case !slot.Active:
+			failing = append(failing, fmt.Sprintf("%s is inactive", slot.Name))
+		}
+	}
+
+	if err := graded(failing, warning); err != nil {
+		return retained, "", err
+	}
+
+	return retained, fmt.Sprintf("%d slots, at most %s of WAL retained", len(slots), dataSize(uint64(retained))), nil
+}
+
+// sessionLimit is the number of sessions named by the transaction checks.
+const sessionLimit = 5
+
+func warnFail(warn, fail interface{}) map[string]interface{} {
+	return map[string]interface{}{"warn": warn, "fail": fail}
+}
+
+// graded fails listing the failing problems if there are any, otherwise
+// warns listing the warning ones.
+func graded(failing, warning []string) error {
+	if len(failing) > 0 {
+		return fmt.Errorf("%s", strings.Join(failing, ", "))
+	}
+	if len(warning) > 0 {
+		return Warnf("%s", strings.Join(warning, ", "))
+	}
+	return nil
+}
+
+// checkXIDAge grades the age(datfrozenxid) of each database. The value is
+// the oldest age.
+func checkXIDAge(ages []admin.DatabaseXIDAge, warn, fail int64) (interface{}, string, error) {
+	var oldest admin.DatabaseXIDAge
+	var failing, warning []string
+
+	for _, a := range ages {
+		if a.Age > oldest.Age {
+			oldest = a
+		}
+
+		problem := fmt.Sprintf("database %s is at xid age %d", a.Database, a.Age)
+		switch {
+		case a.Age >= fail:
+			failing = append(failing, problem)
+		case a.Age >= warn:
+			warning = append(warning, problem)
+		}
+	}
+
+	if err := graded(failing, warning); err != nil {
+		return oldest.Age, "", err
+	}
+
+	return oldest.Age, fmt.Sprintf("oldest xid age is %d on %s", oldest.Age, oldest.Database), nil
+}
+
+// checkLongTransactions grades sessions by how long they have been in a
+// transaction. The value is the oldest in seconds.
+func checkLongTransactions(sessions []admin.Session, warn, fail time.Duration) (interface{}, string, error) {
+	var oldest time.Duration
+	var failing, warning []string
+
+	for _, s := range sessions {
+		if s.TransactionAge > oldest {
+			oldest = s.TransactionAge
+		}
+
+		problem := fmt.Sprintf("pid %d on %s (%s) has been in a transaction for %s",
+			s.PID, s.Database, s.State, s.TransactionAge.Round(time.Second))
+		switch {
+		case s.TransactionAge >= fail:
+			failing = append(failing, problem)
+		case s.TransactionAge >= warn:
+			warning = append(warning, problem)
+		}
+	}
+
+	if err := graded(failing, warning); err != nil {
+		return oldest.Seconds(), "", err
+	}
+
+	if len(sessions) == 0 {
+		return oldest.Seconds(), "no open transactions", nil
+	}
+	return oldest.Seconds(), fmt.Sprintf("oldest transaction is %s old", oldest.Round(time.Second)), nil
+}
+
+// checkXminHolders grades sessions by the age of the xmin they hold, which
+// vacuum can't clean up past. The value is the oldest age.
+func checkXminHolders(sessions []admin.Session, warn, fail int64) (interface{}, string, error) {
+	var oldest int64
+	var failing, warning []string
+
+	for _, s := range sessions {
+		if s.XminAge == nil {
+			continue
+		}
+		age := *s.XminAge
+		if age > oldest {
+			oldest = age
+		}
+
+		problem := fmt.Sprintf("pid %d on %s (%s) holds an xmin %d transactions old", s.PID, s.Database, s.State, age)
+		switch {
+		case age >= fail:
+			failing = append(failing, problem)
+		case age >= warn:
+			warning = append(warning, problem)
+		}
+	}
+
+	if err := graded(failing, warning); err != nil {
+		return oldest, "", err
+	}
+
+	return oldest, fmt.Sprintf("oldest xmin held is %d transactions old", oldest), nil
+}
+
 func connectedToLeader(ctx context.Context, conn *pgx.Conn, leaderAddr string) (string, error) {
 	ldrAddr, err := resolvePrimaryFromStandby(ctx, conn)
 	if err != nil {
@@ -0,0 +1,195 @@
+package flycheck
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckReplicationSlots(t *testing.T) {
+	bytes := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name     string
+		slots    []admin.ReplicationSlot
+		retained int64
+		msg      string
+		warning  bool
+		err      string
+	}{
+		{
+			name: "no slots",
+		},
+		{
+			name: "healthy",
+			slots: []admin.ReplicationSlot{
+				{Name: "stolon_a", Active: true, RetainedBytes: bytes(1024)},
+				{Name: "stolon_b", Active: true, RetainedBytes: bytes(4096)},
+			},
+			retained: 4096,
+			msg:      "2 slots, at most 4 KB of WAL retained",
+		},
+		{
+			name: "inactive during a keeper restart",
+			slots: []admin.ReplicationSlot{
+				{Name: "stolon_a", Active: true, RetainedBytes: bytes(1024)},
+				{Name: "stolon_b", Active: false, RetainedBytes: bytes(2048)},
+			},
+			retained: 2048,
+			warning:  true,
+			err:      "stolon_b is inactive",
+		},
+		{
+			name: "inactive",
+			slots: []admin.ReplicationSlot{
+				{Name: "stolon_a", Active: true, RetainedBytes: bytes(1024)},
+				{Name: "debezium", Active: false, RetainedBytes: bytes(512)},
+			},
+			retained: 1024,
+			err:      "debezium is inactive",
+		},
+		{
+			name: "inactive retaining too much",
+			slots: []admin.ReplicationSlot{
+				{Name: "stolon_a", Active: true, RetainedBytes: bytes(1024)},
+				{Name: "debezium", Active: false, RetainedBytes: bytes(2 << 20)},
+			},
+			retained: 2 << 20,
+			err:      "debezium is inactive and retains 2 MB of WAL",
+		},
+		{
+			name: "retaining too much",
+			slots: []admin.ReplicationSlot{
+				{Name: "stolon_a", Active: true, RetainedBytes: bytes(2 << 20)},
+			},
+			retained: 2 << 20,
+			err:      "stolon_a retains 2 MB of WAL",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			value, msg, err := checkReplicationSlots(tc.slots, 1<<20)
+			assert.Equal(t, tc.retained, value)
+
+			if tc.err != "" {
+				assertGraded(t, err, tc.warning, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			if tc.msg != "" {
+				assert.Equal(t, tc.msg, msg)
+			}
+			assert.NotEmpty(t, msg)
+		})
+	}
+}
+
+func TestCheckXIDAge(t *testing.T) {
+	tests := []struct {
+		name    string
+		ages    []admin.DatabaseXIDAge
+		warning bool
+		err     string
+	}{
+		{
+			name: "young",
+			ages: []admin.DatabaseXIDAge{{Database: "app", Age: 100}, {Database: "postgres", Age: 50}},
+		},
+		{
+			name:    "warning",
+			ages:    []admin.DatabaseXIDAge{{Database: "app", Age: 1500}, {Database: "postgres", Age: 50}},
+			warning: true,
+			err:     "database app is at xid age 1500",
+		},
+		{
+			name: "failing",
+			ages: []admin.DatabaseXIDAge{{Database: "app", Age: 2500}, {Database: "other", Age: 1500}},
+			err:  "database app is at xid age 2500",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			value, _, err := checkXIDAge(tc.ages, 1000, 2000)
+			assert.Equal(t, tc.ages[0].Age, value)
+			assertGraded(t, err, tc.warning, tc.err)
+		})
+	}
+}
+
+func TestCheckLongTransactions(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions []admin.Session
+		warning  bool
+		err      string
+	}{
+		{
+			name: "no transactions",
+		},
+		{
+			name:     "short",
+			sessions: []admin.Session{{PID: 10, Database: "app", State: "active", TransactionAge: time.Second}},
+		},
+		{
+			name:     "idle in transaction",
+			sessions: []admin.Session{{PID: 10, Database: "app", State: "idle in transaction", TransactionAge: 10 * time.Minute}},
+			warning:  true,
+			err:      "pid 10 on app (idle in transaction) has been in a transaction for 10m0s",
+		},
+		{
+			name: "failing",
+			sessions: []admin.Session{
+				{PID: 10, Database: "app", State: "active", TransactionAge: 2 * time.Hour},
+				{PID: 11, Database: "app", State: "active", TransactionAge: 10 * time.Minute},
+			},
+			err: "pid 10 on app (active) has been in a transaction for 2h0m0s",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := checkLongTransactions(tc.sessions, 5*time.Minute, time.Hour)
+			assertGraded(t, err, tc.warning, tc.err)
+		})
+	}
+}
+
+func TestCheckXminHolders(t *testing.T) {
+	age := func(n int64) *int64 { return &n }
+
+	sessions := []admin.Session{
+		{PID: 20, Database: "app", State: "idle in transaction", XminAge: age(300)},
+		{PID: 21, Database: "app", State: "active", XminAge: age(150)},
+		{PID: 22, Database: "app", State: "active"},
+	}
+
+	value, _, err := checkXminHolders(sessions, 100, 200)
+	assert.Equal(t, int64(300), value)
+	assertGraded(t, err, false, "pid 20 on app (idle in transaction) holds an xmin 300 transactions old")
+
+	value, msg, err := checkXminHolders(sessions[2:], 100, 200)
+	assert.Equal(t, int64(0), value)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, msg)
+}
+
+func assertGraded(t *testing.T, err error, warning bool, expected string) {
+	t.Helper()
+
+	if expected == "" {
+		assert.NoError(t, err)
+		return
+	}
+
+	require.Error(t, err)
+	assert.Equal(t, expected, err.Error())
+
+	var w *Warning
+	assert.Equal(t, warning, errors.As(err, &w))
+}
@@ -2,16 +2,15 @@ package flycheck
 
 import (
 	"context"
-	"fmt"
-	chk "github.com/superfly/fly-checks/check"
 
 	"github.com/fly-examples/postgres-ha/pkg/flypg"
 	"github.com/fly-examples/postgres-ha/pkg/flypg/admin"
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
 	"github.com/pkg/errors"
 )
 
 // PostgreSQLRole outputs current role
-func PostgreSQLRole(ctx context.Context, checks *chk.CheckSuite) (*chk.CheckSuite, error) {
+func PostgreSQLRole(ctx context.Context, checks *Suite, thresholds Thresholds, logger *supervisor.Logger) (*Suite, error) {
 	node, err := flypg.NewNode()
 	if err != nil {
 		return checks, errors.Wrap(err, "failed to initialize node")
@@ -27,20 +26,24 @@ func PostgreSQLRole(ctx context.Context, checks *chk.CheckSuite) (*chk.CheckSuit
 		conn.Close(ctx)
 	}
 
-	checks.AddCheck("role", func() (string, error) {
-		// checkDisk usage is >90% return "readonly"
+	checks.AddMeasuredCheck("role", thresholds.ReadonlyDiskUsed, func() (interface{}, string, error) {
+		// report "readonly" once disk usage is over the threshold
 		size, available, err := diskUsage("/data/")
 
 		if err != nil {
-			fmt.Printf("failed to get disk usage: %s\n", err)
+			logger.Error("failed to get disk usage:", err)
+			role, err := admin.ResolveRole(ctx, conn)
+			return nil, role, err
 		}
 
 		used := float64(size-available) / float64(size) * 100
 
-		if used > 90 {
-			return "readonly", nil
+		if used > thresholds.ReadonlyDiskUsed {
+			return used, "readonly", nil
 		}
-		return admin.ResolveRole(ctx, conn)
+
+		role, err := admin.ResolveRole(ctx, conn)
+		return used, role, err
 	})
 	return checks, nil
 }
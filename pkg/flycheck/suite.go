@@ -0,0 +1,180 @@
+package flycheck
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	chk "github.com/superfly/fly-checks/check"
+)
+
+const (
+	StatusPassing = "passing"
+	// StatusWarning is reported for checks that passed but are getting
+	// close to their threshold.
+	StatusWarning = "warning"
+	StatusFailing = "failing"
+	StatusTimeout = "timeout"
+	// StatusSkipped is reported for checks that never ran, e.g. because an
+	// earlier one used up the suite's time.
+	StatusSkipped = "skipped"
+)
+
+// Warning is returned by a check that passes, but should be looked at.
+type Warning struct {
+	msg string
+}
+
+func Warnf(format string, a ...interface{}) error {
+	return &Warning{msg: fmt.Sprintf(format, a...)}
+}
+
+func (w *Warning) Error() string {
+	return w.msg
+}
+
+// MeasuredFunction is a check that also returns the value it held against
+// its threshold.
+type MeasuredFunction func() (value interface{}, msg string, err error)
+
+// Suite is a check suite that keeps what each of its checks measured, so
+// results can be reported as json.
+type Suite struct {
+	*chk.CheckSuite
+
+	mu       sync.Mutex
+	measured []*measurement
+}
+
+type measurement struct {
+	name       string
+	threshold  interface{}
+	value      interface{}
+	message    string
+	err        error
+	warning    bool
+	start, end time.Time
+}
+
+func NewSuite(name string) *Suite {
+	return &Suite{CheckSuite: chk.NewCheckSuite(name)}
+}
+
+// AddCheck adds a check that has no threshold.
+func (s *Suite) AddCheck(name string, fn chk.CheckFunction) *chk.Check {
+	return s.AddMeasuredCheck(name, nil, func() (interface{}, string, error) {
+		msg, err := fn()
+		return nil, msg, err
+	})
+}
+
+// AddMeasuredCheck adds a check that reports the value it measured and the
+// threshold it was held to.
+func (s *Suite) AddMeasuredCheck(name string, threshold interface{}, fn MeasuredFunction) *chk.Check {
+	m := &measurement{name: name, threshold: threshold}
+
+	s.mu.Lock()
+	s.measured = append(s.measured, m)
+	s.mu.Unlock()
+
+	return s.CheckSuite.AddCheck(name, func() (string, error) {
+		s.mu.Lock()
+		m.start = time.Now()
+		s.mu.Unlock()
+
+		value, msg, err := fn()
+
+		var warning *Warning
+		if errors.As(err, &warning) {
+			msg, err = "WARNING: "+warning.Error(), nil
+		}
+
+		s.mu.Lock()
+		m.value, m.message, m.err = value, msg, err
+		m.warning = warning != nil
+		m.end = time.Now()
+		s.mu.Unlock()
+
+		return msg, err
+	})
+}
+
+type CheckResult struct {
+	Name       string      `json:"name"`
+	Status     string      `json:"status"`
+	Message    string      `json:"message,omitempty"`
+	Value      interface{} `json:"value,omitempty"`
+	Threshold  interface{} `json:"threshold,omitempty"`
+	DurationMs float64     `json:"duration_ms"`
+}
+
+type SuiteResult struct {
+	Name   string        `json:"name"`
+	Passed bool          `json:"passed"`
+	Error  string        `json:"error,omitempty"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// Results reports every check of the suite, including those that timed out
+// or never ran.
+func (s *Suite) Results() SuiteResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := SuiteResult{
+		Name:   s.Name,
+		Checks: []CheckResult{},
+	}
+
+	if s.ErrOnSetup != nil {
+		result.Error = s.ErrOnSetup.Error()
+		return result
+	}
+
+	result.Passed = true
+
+	for _, m := range s.measured {
+		check := CheckResult{
+			Name:      m.name,
+			Value:     m.value,
+			Threshold: m.threshold,
+		}
+
+		switch {
+		case m.start.IsZero():
+			check.Status = StatusSkipped
+		case m.end.IsZero():
+			check.Status = StatusTimeout
+			check.DurationMs = milliseconds(time.Since(m.start))
+		case m.err != nil:
+			check.Status = StatusFailing
+			check.Message = m.err.Error()
+			check.DurationMs = milliseconds(m.end.Sub(m.start))
+		default:
+			check.Status = StatusPassing
+			if m.warning {
+				check.Status = StatusWarning
+			}
+			check.Message = m.message
+			check.DurationMs = milliseconds(m.end.Sub(m.start))
+		}
+
+		if !check.Passed() {
+			result.Passed = false
+		}
+
+		result.Checks = append(result.Checks, check)
+	}
+
+	return result
+}
+
+// Passed is true for passing checks and those with a warning.
+func (c CheckResult) Passed() bool {
+	return c.Status == StatusPassing || c.Status == StatusWarning
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,121 @@
+package flycheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuiteResults(t *testing.T) {
+	suite := NewSuite("test")
+	suite.AddCheck("plain", func() (string, error) {
+		return "ok", nil
+	})
+	suite.AddMeasuredCheck("lag", 3.0, func() (interface{}, string, error) {
+		return 5.0, "", errors.New("lagging 5s")
+	})
+	suite.AddMeasuredCheck("xidAge", 100, func() (interface{}, string, error) {
+		return 90, "", Warnf("database app is at xid age 90")
+	})
+
+	// nothing has run yet
+	results := suite.Results()
+	assert.False(t, results.Passed)
+	assert.Equal(t, StatusSkipped, results.Checks[0].Status)
+
+	suite.Process(context.Background())
+
+	results = suite.Results()
+	assert.Equal(t, "test", results.Name)
+	assert.False(t, results.Passed)
+	require.Len(t, results.Checks, 3)
+
+	plain := results.Checks[0]
+	assert.Equal(t, "plain", plain.Name)
+	assert.Equal(t, StatusPassing, plain.Status)
+	assert.Equal(t, "ok", plain.Message)
+	assert.Nil(t, plain.Value)
+	assert.Nil(t, plain.Threshold)
+
+	lag := results.Checks[1]
+	assert.Equal(t, StatusFailing, lag.Status)
+	assert.Equal(t, "lagging 5s", lag.Message)
+	assert.Equal(t, 5.0, lag.Value)
+	assert.Equal(t, 3.0, lag.Threshold)
+
+	// warnings pass, with the message kept for the text output
+	xid := results.Checks[2]
+	assert.Equal(t, StatusWarning, xid.Status)
+	assert.True(t, xid.Passed())
+	assert.Equal(t, "WARNING: database app is at xid age 90", xid.Message)
+	assert.True(t, suite.Checks[2].Passed())
+}
+
+func TestJSONResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		suite  func() *Suite
+		status int
+		passed bool
+		error  string
+	}{
+		{
+			name: "passing",
+			suite: func() *Suite {
+				s := NewSuite("VM")
+				s.AddMeasuredCheck("checkDisk", 10.0, func() (interface{}, string, error) {
+					return 50.0, "50% free", nil
+				})
+				return s
+			},
+			status: http.StatusOK,
+			passed: true,
+		},
+		{
+			name: "failing",
+			suite: func() *Suite {
+				s := NewSuite("VM")
+				s.AddMeasuredCheck("checkDisk", 10.0, func() (interface{}, string, error) {
+					return 5.0, "", errors.New("5% free")
+				})
+				return s
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "setup error",
+			suite: func() *Suite {
+				s := NewSuite("PG")
+				s.ErrOnSetup = errors.New("failed to connect to proxy")
+				return s
+			},
+			status: http.StatusInternalServerError,
+			error:  "failed to connect to proxy",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			suite := tc.suite()
+			suite.Process(context.Background())
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/flycheck/vm?format=json", nil)
+			handleCheckResponse(w, r, suite, false)
+
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var result SuiteResult
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Equal(t, tc.passed, result.Passed)
+			assert.Equal(t, tc.error, result.Error)
+		})
+	}
+}
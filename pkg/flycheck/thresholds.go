@@ -0,0 +1,160 @@
+package flycheck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Thresholds are the limits checks fail at. Percentages are 0-100.
+type Thresholds struct {
+	// ReplicationLag is the replay lag a replica fails at.
+	ReplicationLag time.Duration `yaml:"replication_lag" json:"replication_lag"`
+	// Pressure is the percentage of time spent stalled on memory, cpu or io.
+	Pressure float64 `yaml:"pressure" json:"pressure"`
+	// Load1, Load5 and Load15 are load averages per cpu.
+	Load1  float64 `yaml:"load_1" json:"load_1"`
+	Load5  float64 `yaml:"load_5" json:"load_5"`
+	Load15 float64 `yaml:"load_15" json:"load_15"`
+	// MinFreeDisk is the free space on the data volume below which the
+	// disk check fails.
+	MinFreeDisk float64 `yaml:"min_free_disk" json:"min_free_disk"`
+	// ReadonlyDiskUsed is the disk usage at which the role check reports
+	// readonly.
+	ReadonlyDiskUsed float64 `yaml:"readonly_disk_used" json:"readonly_disk_used"`
+	// SlotRetainedWAL is the WAL in bytes a replication slot can hold back
+	// before the slot check fails.
+	SlotRetainedWAL int64 `yaml:"slot_retained_wal" json:"slot_retained_wal"`
+
+	// XIDAge is the age(datfrozenxid) of a database, postgres stops
+	// accepting writes when it gets close to 2^31.
+	XIDAgeWarn int64 `yaml:"xid_age_warn" json:"xid_age_warn"`
+	XIDAge     int64 `yaml:"xid_age" json:"xid_age"`
+	// TransactionAge is how long a session has been in a transaction,
+	// including sessions that are idle in transaction.
+	TransactionAgeWarn time.Duration `yaml:"transaction_age_warn" json:"transaction_age_warn"`
+	TransactionAge     time.Duration `yaml:"transaction_age" json:"transaction_age"`
+	// XminAge is the age(backend_xmin) of a session, vacuum can't clean up
+	// rows newer than the oldest one.
+	XminAgeWarn int64 `yaml:"xmin_age_warn" json:"xmin_age_warn"`
+	XminAge     int64 `yaml:"xmin_age" json:"xmin_age"`
+
+	// ArchiveReadyFiles is the number of WAL segments waiting to be
+	// archived above which the archiver check fails if it keeps growing.
+	ArchiveReadyFiles int64 `yaml:"archive_ready_files" json:"archive_ready_files"`
+	// ArchiveStaleTimeouts is how many archive_timeouts segments can wait
+	// without anything being archived.
+	ArchiveStaleTimeouts float64 `yaml:"archive_stale_timeouts" json:"archive_stale_timeouts"`
+}
+
+var DefaultThresholds = Thresholds{
+	ReplicationLag:   3 * time.Second,
+	Pressure:         10,
+	Load1:            10,
+	Load5:            4,
+	Load15:           2,
+	MinFreeDisk:      10,
+	ReadonlyDiskUsed: 90,
+	SlotRetainedWAL:  1 << 30,
+
+	XIDAgeWarn:         500000000,
+	XIDAge:             1000000000,
+	TransactionAgeWarn: 5 * time.Minute,
+	TransactionAge:     time.Hour,
+	XminAgeWarn:        10000000,
+	XminAge:            100000000,
+
+	ArchiveReadyFiles:    10,
+	ArchiveStaleTimeouts: 3,
+}
+
+// LoadThresholds starts from DefaultThresholds, applies the yaml or json
+// file at FLYCHECK_CONFIG if set, then FLYCHECK_* environment variables.
+func LoadThresholds() (Thresholds, error) {
+	t := DefaultThresholds
+
+	if path := os.Getenv("FLYCHECK_CONFIG"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return t, err
+		}
+		if err := yaml.Unmarshal(data, &t); err != nil {
+			return t, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	if err := t.applyEnv(os.LookupEnv); err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+func (t *Thresholds) applyEnv(lookup func(string) (string, bool)) error {
+	floats := map[string]*float64{
+		"FLYCHECK_PRESSURE":               &t.Pressure,
+		"FLYCHECK_LOAD_1":                 &t.Load1,
+		"FLYCHECK_LOAD_5":                 &t.Load5,
+		"FLYCHECK_LOAD_15":                &t.Load15,
+		"FLYCHECK_MIN_FREE_DISK":          &t.MinFreeDisk,
+		"FLYCHECK_READONLY_DISK_USED":     &t.ReadonlyDiskUsed,
+		"FLYCHECK_ARCHIVE_STALE_TIMEOUTS": &t.ArchiveStaleTimeouts,
+	}
+
+	for name, field := range floats {
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = f
+	}
+
+	ints := map[string]*int64{
+		"FLYCHECK_SLOT_RETAINED_WAL":   &t.SlotRetainedWAL,
+		"FLYCHECK_XID_AGE_WARN":        &t.XIDAgeWarn,
+		"FLYCHECK_XID_AGE":             &t.XIDAge,
+		"FLYCHECK_XMIN_AGE_WARN":       &t.XminAgeWarn,
+		"FLYCHECK_XMIN_AGE":            &t.XminAge,
+		"FLYCHECK_ARCHIVE_READY_FILES": &t.ArchiveReadyFiles,
+	}
+
+	for name, field := range ints {
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = n
+	}
+
+	durations := map[string]*time.Duration{
+		"FLYCHECK_REPLICATION_LAG":      &t.ReplicationLag,
+		"FLYCHECK_TRANSACTION_AGE_WARN": &t.TransactionAgeWarn,
+		"FLYCHECK_TRANSACTION_AGE":      &t.TransactionAge,
+	}
+
+	for name, field := range durations {
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = d
+	}
+
+	return nil
+}
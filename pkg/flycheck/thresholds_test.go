@@ -0,0 +1,98 @@
+package flycheck
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadThresholds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flycheck")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "thresholds.yml")
+	config := "replication_lag: 10s\nload_1: 20\nmin_free_disk: 5\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(config), 0644))
+
+	os.Setenv("FLYCHECK_CONFIG", path)
+	os.Setenv("FLYCHECK_LOAD_1", "15")
+	defer os.Unsetenv("FLYCHECK_CONFIG")
+	defer os.Unsetenv("FLYCHECK_LOAD_1")
+
+	thresholds, err := LoadThresholds()
+	require.NoError(t, err)
+
+	expected := DefaultThresholds
+	expected.ReplicationLag = 10 * time.Second
+	expected.Load1 = 15 // the environment wins over the file
+	expected.MinFreeDisk = 5
+	assert.Equal(t, expected, thresholds)
+}
+
+func TestThresholdsFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected func(*Thresholds)
+		err      string
+	}{
+		{
+			name:     "defaults",
+			expected: func(*Thresholds) {},
+		},
+		{
+			name: "overrides",
+			env: map[string]string{
+				"FLYCHECK_REPLICATION_LAG":    "500ms",
+				"FLYCHECK_PRESSURE":           "25.5",
+				"FLYCHECK_READONLY_DISK_USED": "95",
+				"FLYCHECK_XID_AGE":            "1500000000",
+				"FLYCHECK_TRANSACTION_AGE":    "30m",
+			},
+			expected: func(t *Thresholds) {
+				t.XIDAge = 1500000000
+				t.TransactionAge = 30 * time.Minute
+				t.ReplicationLag = 500 * time.Millisecond
+				t.Pressure = 25.5
+				t.ReadonlyDiskUsed = 95
+			},
+		},
+		{
+			name: "invalid number",
+			env:  map[string]string{"FLYCHECK_LOAD_5": "high"},
+			err:  "invalid FLYCHECK_LOAD_5",
+		},
+		{
+			name: "invalid duration",
+			env:  map[string]string{"FLYCHECK_REPLICATION_LAG": "3"},
+			err:  "invalid FLYCHECK_REPLICATION_LAG",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			thresholds := DefaultThresholds
+			err := thresholds.applyEnv(func(name string) (string, bool) {
+				value, ok := tc.env[name]
+				return value, ok
+			})
+
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			expected := DefaultThresholds
+			tc.expected(&expected)
+			assert.Equal(t, expected, thresholds)
+		})
+	}
+}
@@ -7,40 +7,73 @@ import (
 	"math"
 	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
 	"github.com/superfly/fly-checks/check"
 )
 
-// CheckVM for system / disk checks
-func CheckVM(checks *check.CheckSuite) *check.CheckSuite {
+// CheckVM for system / disk checks, and the processes run by svisor if
+// it isn't nil.
+func CheckVM(checks *Suite, svisor *supervisor.Supervisor, thresholds Thresholds) *Suite {
 
-	checks.AddCheck("checkDisk", func() (string, error) {
-		return checkDisk("/data/")
+	checks.AddMeasuredCheck("checkDisk", thresholds.MinFreeDisk, func() (interface{}, string, error) {
+		return checkDisk("/data/", thresholds.MinFreeDisk)
 	})
 
-	checks.AddCheck("checkLoad", func() (string, error) {
-		return checkLoad()
+	loadThresholds := map[string]float64{
+		"1m":  thresholds.Load1,
+		"5m":  thresholds.Load5,
+		"15m": thresholds.Load15,
+	}
+	checks.AddMeasuredCheck("checkLoad", loadThresholds, func() (interface{}, string, error) {
+		return checkLoad(thresholds)
 	})
 
 	pressureNames := []string{"memory", "cpu", "io"}
 	for _, n := range pressureNames {
 		name := n
-		checks.AddCheck(name, func() (string, error) {
-			return checkPressure(name)
+		checks.AddMeasuredCheck(name, thresholds.Pressure, func() (interface{}, string, error) {
+			return checkPressure(name, thresholds.Pressure)
+		})
+	}
+
+	if svisor != nil {
+		checks.AddCheck("processes", func() (string, error) {
+			return checkProcesses(svisor.Processes())
 		})
 	}
 
 	return checks
 }
 
-func checkPressure(name string) (string, error) {
+// checkProcesses fails if any supervised process is crash looping.
+func checkProcesses(statuses []supervisor.ProcessStatus) (string, error) {
+	var degraded []string
+	for _, status := range statuses {
+		if status.Degraded {
+			degraded = append(degraded, fmt.Sprintf("%s (%d consecutive crashes)", status.Name, status.Crashes))
+		}
+	}
+
+	if len(degraded) > 0 {
+		return "", fmt.Errorf("processes are crash looping: %s", strings.Join(degraded, ", "))
+	}
+
+	return fmt.Sprintf("%d processes healthy", len(statuses)), nil
+}
+
+// checkPressure fails if the system spent more than limit percent of any
+// period stalled on the named resource. The value is the percentage for
+// each period.
+func checkPressure(name string, limit float64) (interface{}, string, error) {
 	var avg10, avg60, avg300, counter float64
 	//var rest string
 	raw, err := ioutil.ReadFile("/proc/pressure/" + name)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
 	_, err = fmt.Sscanf(
@@ -49,85 +82,96 @@ func checkPressure(name string) (string, error) {
 		&avg10, &avg60, &avg300, &counter,
 	)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
 	avg10Dur, err := pressureToDuration(avg10, 10.0)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 	avg60Dur, err := pressureToDuration(avg60, 60.0)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
 	avg300Dur, err := pressureToDuration(avg300, 300.0)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	// Trigger failure if pressure exceeds 10 percent.
-	if avg10 > 10 {
-		return "", fmt.Errorf("system spent %s of the last 10 seconds waiting on %s", check.RoundDuration(avg10Dur, 2), name)
+	value := map[string]float64{"avg10": avg10, "avg60": avg60, "avg300": avg300}
+
+	if avg10 > limit {
+		return value, "", fmt.Errorf("system spent %s of the last 10 seconds waiting on %s", check.RoundDuration(avg10Dur, 2), name)
 	}
 
-	if avg60 > 10 {
-		return "", fmt.Errorf("system spent %s of the last 60 seconds waiting on %s", check.RoundDuration(avg60Dur, 2), name)
+	if avg60 > limit {
+		return value, "", fmt.Errorf("system spent %s of the last 60 seconds waiting on %s", check.RoundDuration(avg60Dur, 2), name)
 	}
 
-	if avg300 > 10 {
-		return "", fmt.Errorf("system spent %s of the last 300 seconds waiting on %s", check.RoundDuration(avg300Dur, 2), name)
+	if avg300 > limit {
+		return value, "", fmt.Errorf("system spent %s of the last 300 seconds waiting on %s", check.RoundDuration(avg300Dur, 2), name)
 	}
 
-	return fmt.Sprintf("system spent %s of the last 60s waiting on %s", check.RoundDuration(avg60Dur, 2), name), nil
+	return value, fmt.Sprintf("system spent %s of the last 60s waiting on %s", check.RoundDuration(avg60Dur, 2), name), nil
 }
 
-func checkLoad() (string, error) {
-	var loadAverage1, loadAverage5, loadAverage10 float64
+// checkLoad compares the load averages per cpu with the thresholds. The value
+// is the load per cpu over each period.
+func checkLoad(thresholds Thresholds) (interface{}, string, error) {
+	var loadAverage1, loadAverage5, loadAverage15 float64
 	var runningProcesses, totalProcesses, lastProcessID int
 	raw, err := ioutil.ReadFile("/proc/loadavg")
 
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
 	cpus := float64(runtime.NumCPU())
 	_, err = fmt.Sscanf(string(raw), "%f %f %f %d/%d %d",
-		&loadAverage1, &loadAverage5, &loadAverage10,
+		&loadAverage1, &loadAverage5, &loadAverage15,
 		&runningProcesses, &totalProcesses,
 		&lastProcessID)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	if loadAverage1/cpus > 10 {
-		return "", fmt.Errorf("1 minute load average is very high: %.2f", loadAverage1)
+	value := map[string]float64{
+		"1m":  loadAverage1 / cpus,
+		"5m":  loadAverage5 / cpus,
+		"15m": loadAverage15 / cpus,
 	}
-	if loadAverage5/cpus > 4 {
-		return "", fmt.Errorf("5 minute load average is high: %.2f", loadAverage5)
+
+	if loadAverage1/cpus > thresholds.Load1 {
+		return value, "", fmt.Errorf("1 minute load average is very high: %.2f", loadAverage1)
 	}
-	if loadAverage10/cpus > 2 {
-		return "", fmt.Errorf("10 minute load average is high: %.2f", loadAverage10)
+	if loadAverage5/cpus > thresholds.Load5 {
+		return value, "", fmt.Errorf("5 minute load average is high: %.2f", loadAverage5)
+	}
+	if loadAverage15/cpus > thresholds.Load15 {
+		return value, "", fmt.Errorf("15 minute load average is high: %.2f", loadAverage15)
 	}
 
-	return fmt.Sprintf("load averages: %.2f %.2f %.2f", loadAverage10, loadAverage5, loadAverage1), nil
+	return value, fmt.Sprintf("load averages: %.2f %.2f %.2f", loadAverage15, loadAverage5, loadAverage1), nil
 }
 
-func checkDisk(dir string) (string, error) {
+// checkDisk fails if less than minFree percent of dir is free. The value is
+// the percentage free.
+func checkDisk(dir string, minFree float64) (interface{}, string, error) {
 	// Available blocks * size per block = available space in bytes
 	size, available, err := diskUsage(dir)
 	if err != nil {
-		return "", fmt.Errorf("%s: %s", dir, err)
+		return nil, "", fmt.Errorf("%s: %s", dir, err)
 	}
 
 	pct := float64(available) / float64(size)
 	msg := fmt.Sprintf("%s (%.1f%%) free space on %s", dataSize(available), pct*100, dir)
 
-	if pct < 0.1 {
-		return "", errors.New(msg)
+	if pct*100 < minFree {
+		return pct * 100, "", errors.New(msg)
 	}
 
-	return msg, nil
+	return pct * 100, msg, nil
 }
 
 func diskUsage(dir string) (size uint64, available uint64, err error) {
@@ -159,6 +203,10 @@ func round(val float64, roundOn float64, places int) (newVal float64) {
 	return
 }
 func dataSize(size uint64) string {
+	if size == 0 {
+		return "0 B"
+	}
+
 	var suffixes [5]string
 	suffixes[0] = "B"
 	suffixes[1] = "KB"
@@ -8,64 +8,44 @@ import (
 	"github.com/pkg/errors"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fly-examples/postgres-ha/pkg/flypg"
 	"github.com/jackc/pgx/v4"
 )
 
 func CreateUser(ctx context.Context, pg *pgx.Conn, username string, password string) error {
-	sql := fmt.Sprintf(`CREATE USER %s WITH LOGIN PASSWORD '%s'`, username, password)
-
-	_, err := pg.Exec(ctx, sql)
+	stmt, err := createUserStmt(username, password)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return stmt.exec(ctx, pg)
 }
 
 func GrantSuperuser(ctx context.Context, pg *pgx.Conn, username string) error {
-	sql := fmt.Sprintf("ALTER USER %s WITH SUPERUSER;", username)
-
-	_, err := pg.Exec(ctx, sql)
-	if err != nil {
-		return err
-	}
+	return alterUserStmt(username, "SUPERUSER").exec(ctx, pg)
+}
 
-	return nil
+func RevokeSuperuser(ctx context.Context, pg *pgx.Conn, username string) error {
+	return alterUserStmt(username, "NOSUPERUSER").exec(ctx, pg)
 }
 
 func GrantReplication(ctx context.Context, pg *pgx.Conn, username string) error {
-	sql := fmt.Sprintf("ALTER USER %s WITH REPLICATION;", username)
-
-	_, err := pg.Exec(ctx, sql)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return alterUserStmt(username, "REPLICATION").exec(ctx, pg)
 }
 
 func ChangePassword(ctx context.Context, pg *pgx.Conn, username, password string) error {
-	sql := fmt.Sprintf("ALTER USER %s WITH LOGIN PASSWORD '%s';", username, password)
-
-	_, err := pg.Exec(ctx, sql)
+	stmt, err := changePasswordStmt(username, password)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return stmt.exec(ctx, pg)
 }
 
 func ListDatabases(ctx context.Context, pg *pgx.Conn) ([]DbInfo, error) {
-	sql := `
-		SELECT d.datname,
-					(SELECT array_agg(u.usename::text order by u.usename) 
-						from pg_user u 
-						where has_database_privilege(u.usename, d.datname, 'CONNECT')) as allowed_users
-		from pg_database d where d.datistemplate = false
-		order by d.datname;
-		`
+	sql := dbInfoSQL + " WHERE d.datistemplate = false ORDER BY d.datname"
 
 	rows, err := pg.Query(ctx, sql)
 	if err != nil {
@@ -95,12 +75,28 @@ type UserInfo struct {
 }
 
 func (ui UserInfo) IsPassword(password string) bool {
-	if !strings.HasPrefix(ui.PasswordHash, "md5") {
+	switch ui.PasswordEncryption() {
+	case EncryptionMD5:
+		encoded := fmt.Sprintf("md5%x", md5.Sum([]byte(password+ui.Username)))
+		return encoded == ui.PasswordHash
+	case EncryptionSCRAM:
+		return verifySCRAM(ui.PasswordHash, password)
+	default:
 		return false
 	}
+}
 
-	encoded := fmt.Sprintf("md5%x", md5.Sum([]byte(password+ui.Username)))
-	return encoded == ui.PasswordHash
+// PasswordEncryption returns how the user's password is hashed, or an empty
+// string if the user has no password.
+func (ui UserInfo) PasswordEncryption() string {
+	switch {
+	case strings.HasPrefix(ui.PasswordHash, scramPrefix):
+		return EncryptionSCRAM
+	case strings.HasPrefix(ui.PasswordHash, "md5"):
+		return EncryptionMD5
+	default:
+		return ""
+	}
 }
 
 type DbInfo struct {
@@ -109,22 +105,7 @@ type DbInfo struct {
 }
 
 func ListUsers(ctx context.Context, pg *pgx.Conn) ([]UserInfo, error) {
-	sql := `
-	select u.usename,
-		usesuper as superuser,
-		userepl as repluser,
-		a.rolpassword as passwordhash,
-    (
-		select array_agg(d.datname::text order by d.datname)
-			from pg_database d
-				WHERE datistemplate = false
-				AND has_database_privilege(u.usename, d.datname, 'CONNECT')
-	) as 
-		allowed_databases
-	from 
-		pg_user u join pg_authid a on u.usesysid = a.oid 
-	order by u.usename
-	`
+	sql := userInfoSQL + " ORDER BY u.usename"
 
 	rows, err := pg.Query(ctx, sql)
 	if err != nil {
@@ -146,26 +127,7 @@ func ListUsers(ctx context.Context, pg *pgx.Conn) ([]UserInfo, error) {
 }
 
 func FindUser(ctx context.Context, pg *pgx.Conn, username string) (*UserInfo, error) {
-	sql := `
-	select 
-		u.usename,
-        usesuper as superuser,
-        userepl as repluser,
-    	a.rolpassword as passwordhash,
-    (
-        SELECT 
-			array_agg(d.datname::text order by d.datname)
-        	from pg_database d
-        WHERE datistemplate = false AND has_database_privilege(u.usename, d.datname, 'CONNECT')
-    ) AS 
-		allowed_databases
-    FROM 
-		pg_user u join pg_authid a on u.usesysid = a.oid 
-	WHERE u.usename='%s';`
-
-	sql = fmt.Sprintf(sql, username)
-
-	row := pg.QueryRow(ctx, sql)
+	row := findUserStmt(username).queryRow(ctx, pg)
 
 	var user = UserInfo{}
 
@@ -173,53 +135,23 @@ func FindUser(ctx context.Context, pg *pgx.Conn, username string) (*UserInfo, er
 		return nil, err
 	}
 	return &user, nil
-
 }
 
+// DeleteUser drops a user, it does nothing if the user doesn't exist.
 func DeleteUser(ctx context.Context, pg *pgx.Conn, username string) error {
-	sql := fmt.Sprintf("DROP USER %s", username)
-
-	_, err := pg.Exec(ctx, sql)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return dropUserStmt(username).exec(ctx, pg)
 }
 
 func CreateDatabase(ctx context.Context, pg *pgx.Conn, name string) error {
-	sql := fmt.Sprintf("CREATE DATABASE %s;", name)
-
-	_, err := pg.Exec(ctx, sql)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return createDatabaseStmt(name).exec(ctx, pg)
 }
 
 func DeleteDatabase(ctx context.Context, pg *pgx.Conn, name string) error {
-	sql := fmt.Sprintf("DROP DATABASE %s;", name)
-
-	_, err := pg.Exec(ctx, sql)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return dropDatabaseStmt(name).exec(ctx, pg)
 }
 
 func FindDatabase(ctx context.Context, pg *pgx.Conn, name string) (*DbInfo, error) {
-	sql := `
-	SELECT 
-		datname, 
-		(SELECT array_agg(u.usename::text order by u.usename) FROM pg_user u WHERE has_database_privilege(u.usename, d.datname, 'CONNECT')) as allowed_users 
-	FROM pg_database d WHERE d.datname='%s';
-	`
-
-	sql = fmt.Sprintf(sql, name)
-
-	row := pg.QueryRow(ctx, sql)
+	row := findDatabaseStmt(name).queryRow(ctx, pg)
 
 	db := new(DbInfo)
 	if err := row.Scan(&db.Name, &db.Users); err != nil {
@@ -230,14 +162,11 @@ func FindDatabase(ctx context.Context, pg *pgx.Conn, name string) (*DbInfo, erro
 }
 
 func GrantAccess(ctx context.Context, pg *pgx.Conn, database, username string) error {
-	sql := fmt.Sprintf("GRANT ALL PRIVILEGES ON DATABASE %q TO %q", database, username)
-
-	_, err := pg.Exec(ctx, sql)
-	if err != nil {
-		return err
-	}
+	return grantAccessStmt(database, username).exec(ctx, pg)
+}
 
-	return nil
+func RevokeAccess(ctx context.Context, pg *pgx.Conn, database, username string) error {
+	return revokeAccessStmt(database, username).exec(ctx, pg)
 }
 
 func ResolveRole(ctx context.Context, pg *pgx.Conn) (string, error) {
@@ -277,6 +206,160 @@ func ResolveReplicationLag(ctx context.Context, pg *pgx.Conn) ([]*ReplicationSta
 	return stats, nil
 }
 
+type ReplicationSlot struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Active bool   `json:"active"`
+	// RetainedBytes is the WAL kept for the slot, nil if it never reserved
+	// any.
+	RetainedBytes *int64 `json:"retained_bytes"`
+}
+
+// ListReplicationSlots returns the slots of the instance with the WAL each
+// retains. It only works on the master.
+func ListReplicationSlots(ctx context.Context, pg *pgx.Conn) ([]ReplicationSlot, error) {
+	sql := `select slot_name, slot_type, active, (pg_current_wal_lsn() - restart_lsn)::bigint
+			from pg_replication_slots order by slot_name;`
+
+	rows, err := pg.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slots := []ReplicationSlot{}
+	for rows.Next() {
+		var s ReplicationSlot
+		if err := rows.Scan(&s.Name, &s.Type, &s.Active, &s.RetainedBytes); err != nil {
+			return nil, err
+		}
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
+
+type DatabaseXIDAge struct {
+	Database string `json:"database"`
+	Age      int64  `json:"age"`
+}
+
+// ListXIDAges returns age(datfrozenxid) of every database that accepts
+// connections, oldest first.
+func ListXIDAges(ctx context.Context, pg *pgx.Conn) ([]DatabaseXIDAge, error) {
+	sql := `select datname, age(datfrozenxid)::bigint from pg_database
+			where datallowconn order by 2 desc;`
+
+	rows, err := pg.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ages := []DatabaseXIDAge{}
+	for rows.Next() {
+		var a DatabaseXIDAge
+		if err := rows.Scan(&a.Database, &a.Age); err != nil {
+			return nil, err
+		}
+		ages = append(ages, a)
+	}
+	return ages, rows.Err()
+}
+
+// Session is a backend from pg_stat_activity.
+type Session struct {
+	PID            int           `json:"pid"`
+	Database       string        `json:"database"`
+	Username       string        `json:"username"`
+	State          string        `json:"state"`
+	TransactionAge time.Duration `json:"transaction_age"`
+	// XminAge is age(backend_xmin), nil if the session doesn't hold back
+	// vacuum.
+	XminAge *int64 `json:"xmin_age"`
+	Query   string `json:"query"`
+}
+
+const sessionsSQL = `select pid, coalesce(datname, ''), coalesce(usename, ''), coalesce(state, ''),
+			coalesce(extract(epoch from now() - xact_start), 0)::float8, age(backend_xmin)::bigint, left(query, 200)
+			from pg_stat_activity where pid <> pg_backend_pid()`
+
+// OldestTransactions returns up to limit sessions with an open transaction,
+// longest running first.
+func OldestTransactions(ctx context.Context, pg *pgx.Conn, limit int) ([]Session, error) {
+	return listSessions(ctx, pg, sessionsSQL+` and xact_start is not null order by xact_start limit $1`, limit)
+}
+
+// XminHolders returns up to limit sessions holding back the xmin horizon,
+// oldest xmin first.
+func XminHolders(ctx context.Context, pg *pgx.Conn, limit int) ([]Session, error) {
+	return listSessions(ctx, pg, sessionsSQL+` and backend_xmin is not null order by age(backend_xmin) desc limit $1`, limit)
+}
+
+func listSessions(ctx context.Context, pg *pgx.Conn, sql string, limit int) ([]Session, error) {
+	rows, err := pg.Query(ctx, sql, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		var seconds float64
+		if err := rows.Scan(&s.PID, &s.Database, &s.Username, &s.State, &seconds, &s.XminAge, &s.Query); err != nil {
+			return nil, err
+		}
+		s.TransactionAge = time.Duration(seconds * float64(time.Second))
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// ArchiverStatus is pg_stat_archiver along with the archive settings and the
+// number of WAL segments waiting to be archived.
+type ArchiverStatus struct {
+	ArchiveMode string `json:"archive_mode"`
+	// ArchiveTimeout is in seconds.
+	ArchiveTimeout   int        `json:"archive_timeout"`
+	ArchivedCount    int64      `json:"archived_count"`
+	LastArchivedWAL  string     `json:"last_archived_wal"`
+	LastArchivedTime *time.Time `json:"last_archived_time"`
+	FailedCount      int64      `json:"failed_count"`
+	LastFailedWAL    string     `json:"last_failed_wal"`
+	LastFailedTime   *time.Time `json:"last_failed_time"`
+	StatsReset       *time.Time `json:"stats_reset"`
+	// ReadyFiles counts the .ready files in pg_wal/archive_status.
+	ReadyFiles int64 `json:"ready_files"`
+}
+
+// Failing is true if the last attempt to archive a segment failed.
+func (s *ArchiverStatus) Failing() bool {
+	if s.LastFailedTime == nil {
+		return false
+	}
+	return s.LastArchivedTime == nil || s.LastFailedTime.After(*s.LastArchivedTime)
+}
+
+func ResolveArchiverStatus(ctx context.Context, pg *pgx.Conn) (*ArchiverStatus, error) {
+	sql := `select current_setting('archive_mode'),
+			(select setting::int from pg_settings where name = 'archive_timeout'),
+			archived_count, coalesce(last_archived_wal, ''), last_archived_time,
+			failed_count, coalesce(last_failed_wal, ''), last_failed_time, stats_reset,
+			(select count(*) from pg_ls_archive_statusdir() where name like '%.ready')
+			from pg_stat_archiver;`
+
+	var s ArchiverStatus
+	err := pg.QueryRow(ctx, sql).Scan(&s.ArchiveMode, &s.ArchiveTimeout,
+		&s.ArchivedCount, &s.LastArchivedWAL, &s.LastArchivedTime,
+		&s.FailedCount, &s.LastFailedWAL, &s.LastFailedTime, &s.StatsReset,
+		&s.ReadyFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
 func SetReadonly(ctx context.Context, pg *pgx.Conn, enable bool) error {
 	role, err := ResolveRole(ctx, pg)
 	if err != nil {
@@ -297,8 +380,7 @@ func SetReadonly(ctx context.Context, pg *pgx.Conn, enable bool) error {
 			continue
 		}
 
-		sql := fmt.Sprintf("ALTER DATABASE %q SET default_transaction_read_only=%v;", db.Name, enable)
-		if _, err = pg.Exec(ctx, sql); err != nil {
+		if err := setReadonlyStmt(db.Name, enable).exec(ctx, pg); err != nil {
 			return fmt.Errorf("failed to alter readonly state on db %s: %s", db.Name, err)
 		}
 	}
@@ -306,28 +388,23 @@ func SetReadonly(ctx context.Context, pg *pgx.Conn, enable bool) error {
 	return nil
 }
 
+// TerminateClientSessions ends every client session but the current one, so
+// they reconnect with database level settings such as read-only mode.
+func TerminateClientSessions(ctx context.Context, pg *pgx.Conn) error {
+	_, err := pg.Exec(ctx, `select pg_terminate_backend(pid) from pg_stat_activity
+			where pid <> pg_backend_pid() and backend_type = 'client backend'`)
+	return err
+}
+
 func ResolveSettings(ctx context.Context, pg *pgx.Conn, list []string) (*flypg.Settings, error) {
 	node, err := flypg.NewNode()
 	if err != nil {
 		return nil, err
 	}
-	sValues := "'" + strings.Join(list, "', '") + "'"
-
-	sql := fmt.Sprintf(`
-	SELECT
-		name, 
-		setting, 
-		vartype, 
-		min_val, 
-		max_val, 
-		enumvals, 
-		context, 
-		unit, 
-		short_desc, 
-		pending_restart 
-	FROM pg_settings WHERE name IN (%s);`, sValues)
 
-	rows, err := pg.Query(ctx, sql)
+	stmt := resolveSettingsStmt(list)
+
+	rows, err := pg.Query(ctx, stmt.sql, stmt.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -363,6 +440,28 @@ func ResolveSettings(ctx context.Context, pg *pgx.Conn, list []string) (*flypg.S
 	return settings, nil
 }
 
+// CurrentSettings returns each setting formatted with its unit, the way SHOW
+// does. Unknown names are left out.
+func CurrentSettings(ctx context.Context, pg *pgx.Conn, names []string) (map[string]string, error) {
+	sql := `select name, current_setting(name) from pg_settings where name = any($1);`
+
+	rows, err := pg.Query(ctx, sql, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		settings[name] = value
+	}
+	return settings, rows.Err()
+}
+
 func populatePgSettings(dataDir string) (map[string]string, error) {
 	pathToFile := fmt.Sprintf("%s/postgres/postgresql.conf", dataDir)
 	file, err := os.Open(pathToFile)
@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type Extension struct {
+	Name             string  `json:"name"`
+	DefaultVersion   *string `json:"default_version"`
+	InstalledVersion *string `json:"installed_version"`
+	Comment          *string `json:"comment"`
+}
+
+type ExtensionOptions struct {
+	Schema  string `json:"schema,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Cascade creates the extensions it depends on, or drops the objects
+	// that depend on it.
+	Cascade bool `json:"cascade,omitempty"`
+}
+
+// ListExtensions returns the extensions available to the database pg is
+// connected to, with the installed version of those created in it.
+func ListExtensions(ctx context.Context, pg *pgx.Conn) ([]Extension, error) {
+	sql := `select name, default_version, installed_version, comment
+			from pg_available_extensions order by name;`
+
+	rows, err := pg.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	extensions := []Extension{}
+	for rows.Next() {
+		var e Extension
+		if err := rows.Scan(&e.Name, &e.DefaultVersion, &e.InstalledVersion, &e.Comment); err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, e)
+	}
+	return extensions, rows.Err()
+}
+
+// CreateExtension creates an extension in the database pg is connected to.
+func CreateExtension(ctx context.Context, pg *pgx.Conn, name string, opts ExtensionOptions) error {
+	stmt, err := createExtensionStmt(name, opts)
+	if err != nil {
+		return err
+	}
+	return stmt.exec(ctx, pg)
+}
+
+// UpdateExtension updates an extension to version, or to its default version
+// when version is empty.
+func UpdateExtension(ctx context.Context, pg *pgx.Conn, name, version string) error {
+	stmt, err := updateExtensionStmt(name, version)
+	if err != nil {
+		return err
+	}
+	return stmt.exec(ctx, pg)
+}
+
+func DropExtension(ctx context.Context, pg *pgx.Conn, name string, cascade bool) error {
+	return dropExtensionStmt(name, cascade).exec(ctx, pg)
+}
@@ -0,0 +1,193 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+)
+
+type RoleInfo struct {
+	Name            string     `json:"name"`
+	SuperUser       bool       `json:"superuser"`
+	CreateDB        bool       `json:"createdb"`
+	CreateRole      bool       `json:"createrole"`
+	Login           bool       `json:"login"`
+	Replication     bool       `json:"replication"`
+	ConnectionLimit int        `json:"connection_limit"`
+	ValidUntil      *time.Time `json:"valid_until,omitempty"`
+	MemberOf        []string   `json:"member_of"`
+}
+
+// RoleOptions changes a role's attributes. Unset fields are left alone.
+type RoleOptions struct {
+	Superuser  *bool `json:"superuser,omitempty"`
+	CreateDB   *bool `json:"createdb,omitempty"`
+	CreateRole *bool `json:"createrole,omitempty"`
+	Login      *bool `json:"login,omitempty"`
+	// ConnectionLimit of -1 removes the limit.
+	ConnectionLimit *int `json:"connection_limit,omitempty"`
+	// ValidUntil is an RFC 3339 timestamp, or "infinity" to never expire.
+	ValidUntil *string `json:"valid_until,omitempty"`
+}
+
+// PrivilegeGrant grants a role a preset or explicit privileges on a table,
+// or on every table in a schema if no table is given.
+type PrivilegeGrant struct {
+	Role       string   `json:"role"`
+	Schema     string   `json:"schema,omitempty"`
+	Table      string   `json:"table,omitempty"`
+	Preset     string   `json:"preset,omitempty"`
+	Privileges []string `json:"privileges,omitempty"`
+}
+
+func (g PrivilegeGrant) schema() string {
+	if g.Schema == "" {
+		return "public"
+	}
+	return g.Schema
+}
+
+func ListRoles(ctx context.Context, pg *pgx.Conn) ([]RoleInfo, error) {
+	rows, err := pg.Query(ctx, roleInfoSQL+" ORDER BY r.rolname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []RoleInfo{}
+
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, *role)
+	}
+
+	return values, rows.Err()
+}
+
+func FindRole(ctx context.Context, pg *pgx.Conn, name string) (*RoleInfo, error) {
+	return scanRole(pg.QueryRow(ctx, roleInfoSQL+" AND r.rolname = $1", name))
+}
+
+func scanRole(row pgx.Row) (*RoleInfo, error) {
+	var role RoleInfo
+	var validUntil pgtype.Timestamptz
+
+	if err := row.Scan(&role.Name, &role.SuperUser, &role.CreateDB, &role.CreateRole, &role.Login,
+		&role.Replication, &role.ConnectionLimit, &validUntil, &role.MemberOf); err != nil {
+		return nil, err
+	}
+
+	if validUntil.Status == pgtype.Present && validUntil.InfinityModifier == pgtype.None {
+		role.ValidUntil = &validUntil.Time
+	}
+
+	return &role, nil
+}
+
+// UpdateRole applies role options in a single transaction.
+func UpdateRole(ctx context.Context, pg *pgx.Conn, name string, opts RoleOptions) error {
+	stmts, err := updateRoleStmts(name, opts)
+	if err != nil {
+		return err
+	}
+	if len(stmts) == 0 {
+		return fmt.Errorf("no role options were specified")
+	}
+
+	return execAll(ctx, pg, stmts)
+}
+
+func updateRoleStmts(name string, opts RoleOptions) ([]statement, error) {
+	var stmts []statement
+
+	attributes := map[string]*bool{
+		"superuser":  opts.Superuser,
+		"createdb":   opts.CreateDB,
+		"createrole": opts.CreateRole,
+		"login":      opts.Login,
+	}
+
+	names := make([]string, 0, len(attributes))
+	for attribute := range attributes {
+		names = append(names, attribute)
+	}
+	sort.Strings(names)
+
+	for _, attribute := range names {
+		if enable := attributes[attribute]; enable != nil {
+			stmt, err := roleAttributeStmt(name, attribute, *enable)
+			if err != nil {
+				return nil, err
+			}
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	if opts.ConnectionLimit != nil {
+		if *opts.ConnectionLimit < -1 {
+			return nil, fmt.Errorf("connection limit must be -1 or greater")
+		}
+		stmts = append(stmts, connectionLimitStmt(name, *opts.ConnectionLimit))
+	}
+
+	if opts.ValidUntil != nil {
+		var until *time.Time
+		if *opts.ValidUntil != "infinity" {
+			t, err := time.Parse(time.RFC3339, *opts.ValidUntil)
+			if err != nil {
+				return nil, fmt.Errorf("invalid valid_until, expected an RFC 3339 timestamp or infinity: %w", err)
+			}
+			until = &t
+		}
+		stmts = append(stmts, validUntilStmt(name, until))
+	}
+
+	return stmts, nil
+}
+
+// CreateGroup creates a role that can't log in, for granting privileges to
+// its members.
+func CreateGroup(ctx context.Context, pg *pgx.Conn, name string) error {
+	return createGroupStmt(name).exec(ctx, pg)
+}
+
+// GrantRole makes member a member of role.
+func GrantRole(ctx context.Context, pg *pgx.Conn, role, member string) error {
+	return grantRoleStmt(role, member).exec(ctx, pg)
+}
+
+func RevokeRole(ctx context.Context, pg *pgx.Conn, role, member string) error {
+	return revokeRoleStmt(role, member).exec(ctx, pg)
+}
+
+// GrantPrivileges applies a schema or table grant. pg must be connected to
+// the database the schema is in.
+func GrantPrivileges(ctx context.Context, pg *pgx.Conn, grant PrivilegeGrant) error {
+	if grant.Role == "" {
+		return fmt.Errorf("a role is required")
+	}
+
+	stmts, err := grantPrivilegesStmts(grant)
+	if err != nil {
+		return err
+	}
+
+	return execAll(ctx, pg, stmts)
+}
+
+// RevokePrivileges revokes a schema or table grant. pg must be connected to
+// the database the schema is in.
+func RevokePrivileges(ctx context.Context, pg *pgx.Conn, grant PrivilegeGrant) error {
+	if grant.Role == "" {
+		return fmt.Errorf("a role is required")
+	}
+
+	return execAll(ctx, pg, revokePrivilegesStmts(grant))
+}
@@ -0,0 +1,95 @@
+package admin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	EncryptionMD5   = "md5"
+	EncryptionSCRAM = "scram-sha-256"
+
+	scramPrefix = "SCRAM-SHA-256$"
+)
+
+// verifySCRAM checks a password against a SCRAM-SHA-256 verifier as stored
+// in pg_authid, "SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>".
+// Postgres runs passwords through SASLprep first, which doesn't change
+// ASCII passwords.
+func verifySCRAM(verifier, password string) bool {
+	if !strings.HasPrefix(verifier, scramPrefix) {
+		return false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(verifier, scramPrefix), "$")
+	if len(parts) != 2 {
+		return false
+	}
+
+	params := strings.SplitN(parts[0], ":", 2)
+	keys := strings.SplitN(parts[1], ":", 2)
+	if len(params) != 2 || len(keys) != 2 {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(params[0])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(params[1])
+	if err != nil {
+		return false
+	}
+	storedKey, err := base64.StdEncoding.DecodeString(keys[0])
+	if err != nil {
+		return false
+	}
+	serverKey, err := base64.StdEncoding.DecodeString(keys[1])
+	if err != nil {
+		return false
+	}
+
+	salted := pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+
+	clientKey := scramHMAC(salted, "Client Key")
+	computedStoredKey := sha256.Sum256(clientKey)
+
+	return hmac.Equal(computedStoredKey[:], storedKey) && hmac.Equal(scramHMAC(salted, "Server Key"), serverKey)
+}
+
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// PasswordEncryption returns the password_encryption the server hashes new
+// passwords with.
+func PasswordEncryption(ctx context.Context, pg *pgx.Conn) (string, error) {
+	var encryption string
+	if err := pg.QueryRow(ctx, "SHOW password_encryption").Scan(&encryption); err != nil {
+		return "", err
+	}
+
+	// Before Postgres 14 "on" meant md5.
+	if encryption == "on" {
+		encryption = EncryptionMD5
+	}
+
+	return encryption, nil
+}
+
+// SetSessionPasswordEncryption changes how passwords set over this
+// connection are hashed, regardless of the server configuration.
+func SetSessionPasswordEncryption(ctx context.Context, pg *pgx.Conn, encryption string) error {
+	_, err := pg.Exec(ctx, "SELECT set_config('password_encryption', $1, false)", encryption)
+	return err
+}
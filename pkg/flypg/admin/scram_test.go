@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Generated for the password "pencil" with the salt and iteration count
+// from RFC 7677.
+const pencilVerifier = "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ==$WG5d8oPm3OtcPnkdi4Uo7BkeZkBFzpcXkuLmtbsT4qY=:wfPLwcE6nTWhTAmQ7tl2KeoiWGPlZqQxSrmfPwDl2dU="
+
+// md5 of "pencil" followed by the username "user".
+const pencilMD5 = "md520c46e3762c864548e296b33c3406aa9"
+
+func TestIsPassword(t *testing.T) {
+	cases := map[string]struct {
+		user     UserInfo
+		password string
+		expected bool
+	}{
+		"md5 match": {
+			user:     UserInfo{Username: "user", PasswordHash: pencilMD5},
+			password: "pencil",
+			expected: true,
+		},
+		"md5 mismatch": {
+			user:     UserInfo{Username: "user", PasswordHash: pencilMD5},
+			password: "pen",
+			expected: false,
+		},
+		"scram match": {
+			user:     UserInfo{Username: "user", PasswordHash: pencilVerifier},
+			password: "pencil",
+			expected: true,
+		},
+		"scram mismatch": {
+			user:     UserInfo{Username: "user", PasswordHash: pencilVerifier},
+			password: "pen",
+			expected: false,
+		},
+		"scram malformed": {
+			user:     UserInfo{Username: "user", PasswordHash: "SCRAM-SHA-256$4096:W22ZaJ0SNY7soEsUEjb6gQ=="},
+			password: "pencil",
+			expected: false,
+		},
+		"scram bad iterations": {
+			user:     UserInfo{Username: "user", PasswordHash: "SCRAM-SHA-256$0:W22ZaJ0SNY7soEsUEjb6gQ==$a:b"},
+			password: "pencil",
+			expected: false,
+		},
+		"no password": {
+			user:     UserInfo{Username: "user"},
+			password: "",
+			expected: false,
+		},
+	}
+
+	for name, c := range cases {
+		assert.Equal(t, c.expected, c.user.IsPassword(c.password), name)
+	}
+}
+
+func TestPasswordEncryption(t *testing.T) {
+	assert.Equal(t, EncryptionSCRAM, UserInfo{PasswordHash: pencilVerifier}.PasswordEncryption())
+	assert.Equal(t, EncryptionMD5, UserInfo{PasswordHash: "md5abc"}.PasswordEncryption())
+	assert.Equal(t, "", UserInfo{}.PasswordEncryption())
+}
@@ -0,0 +1,375 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// statement is a SQL statement along with its bind arguments. Values go in
+// args wherever postgres accepts a parameter. Identifiers and the few values
+// utility statements can't take as parameters are quoted with quoteIdent and
+// quoteLiteral.
+type statement struct {
+	sql  string
+	args []interface{}
+}
+
+func (s statement) exec(ctx context.Context, pg *pgx.Conn) error {
+	_, err := pg.Exec(ctx, s.sql, s.args...)
+	return err
+}
+
+func (s statement) queryRow(ctx context.Context, pg *pgx.Conn) pgx.Row {
+	return pg.QueryRow(ctx, s.sql, s.args...)
+}
+
+// quoteIdent quotes a database, role or other object name.
+func quoteIdent(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+// quoteLiteral quotes a string the same way postgres' quote_literal does. It
+// is only needed where postgres doesn't accept bind parameters, e.g. the
+// password in CREATE ROLE.
+func quoteLiteral(value string) (string, error) {
+	if strings.ContainsRune(value, 0) {
+		return "", fmt.Errorf("value can't contain a null character")
+	}
+
+	quoted := "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	if strings.Contains(value, `\`) {
+		quoted = "E" + strings.ReplaceAll(quoted, `\`, `\\`)
+	}
+
+	return quoted, nil
+}
+
+func createUserStmt(username, password string) (statement, error) {
+	literal, err := quoteLiteral(password)
+	if err != nil {
+		return statement{}, fmt.Errorf("invalid password: %w", err)
+	}
+
+	return statement{sql: fmt.Sprintf("CREATE USER %s WITH LOGIN PASSWORD %s", quoteIdent(username), literal)}, nil
+}
+
+func changePasswordStmt(username, password string) (statement, error) {
+	literal, err := quoteLiteral(password)
+	if err != nil {
+		return statement{}, fmt.Errorf("invalid password: %w", err)
+	}
+
+	return statement{sql: fmt.Sprintf("ALTER USER %s WITH LOGIN PASSWORD %s", quoteIdent(username), literal)}, nil
+}
+
+// alterUserStmt sets role options such as SUPERUSER. The options are
+// keywords, never user input.
+func alterUserStmt(username string, option string) statement {
+	return statement{sql: fmt.Sprintf("ALTER USER %s WITH %s", quoteIdent(username), option)}
+}
+
+func dropUserStmt(username string) statement {
+	return statement{sql: fmt.Sprintf("DROP USER IF EXISTS %s", quoteIdent(username))}
+}
+
+func createDatabaseStmt(name string) statement {
+	return statement{sql: fmt.Sprintf("CREATE DATABASE %s", quoteIdent(name))}
+}
+
+func dropDatabaseStmt(name string) statement {
+	return statement{sql: fmt.Sprintf("DROP DATABASE %s", quoteIdent(name))}
+}
+
+func grantAccessStmt(database, username string) statement {
+	return statement{sql: fmt.Sprintf("GRANT ALL PRIVILEGES ON DATABASE %s TO %s", quoteIdent(database), quoteIdent(username))}
+}
+
+func revokeAccessStmt(database, username string) statement {
+	return statement{sql: fmt.Sprintf("REVOKE ALL PRIVILEGES ON DATABASE %s FROM %s", quoteIdent(database), quoteIdent(username))}
+}
+
+func setReadonlyStmt(database string, enable bool) statement {
+	return statement{sql: fmt.Sprintf("ALTER DATABASE %s SET default_transaction_read_only=%t", quoteIdent(database), enable)}
+}
+
+func findUserStmt(username string) statement {
+	return statement{sql: userInfoSQL + " WHERE u.usename = $1", args: []interface{}{username}}
+}
+
+func findDatabaseStmt(name string) statement {
+	return statement{sql: dbInfoSQL + " WHERE d.datname = $1", args: []interface{}{name}}
+}
+
+func resolveSettingsStmt(names []string) statement {
+	return statement{sql: settingsSQL + " WHERE name = ANY($1)", args: []interface{}{names}}
+}
+
+func createExtensionStmt(name string, opts ExtensionOptions) (statement, error) {
+	sql := fmt.Sprintf("CREATE EXTENSION %s", quoteIdent(name))
+	if opts.Schema != "" {
+		sql += " SCHEMA " + quoteIdent(opts.Schema)
+	}
+	if opts.Version != "" {
+		literal, err := quoteLiteral(opts.Version)
+		if err != nil {
+			return statement{}, fmt.Errorf("invalid version: %w", err)
+		}
+		sql += " VERSION " + literal
+	}
+	if opts.Cascade {
+		sql += " CASCADE"
+	}
+	return statement{sql: sql}, nil
+}
+
+func updateExtensionStmt(name, version string) (statement, error) {
+	sql := fmt.Sprintf("ALTER EXTENSION %s UPDATE", quoteIdent(name))
+	if version != "" {
+		literal, err := quoteLiteral(version)
+		if err != nil {
+			return statement{}, fmt.Errorf("invalid version: %w", err)
+		}
+		sql += " TO " + literal
+	}
+	return statement{sql: sql}, nil
+}
+
+func dropExtensionStmt(name string, cascade bool) statement {
+	sql := fmt.Sprintf("DROP EXTENSION %s", quoteIdent(name))
+	if cascade {
+		sql += " CASCADE"
+	}
+	return statement{sql: sql}
+}
+
+const userInfoSQL = `
+	SELECT
+		u.usename,
+		usesuper AS superuser,
+		userepl AS repluser,
+		coalesce(a.rolpassword, '') AS passwordhash,
+		(
+			SELECT array_agg(d.datname::text ORDER BY d.datname)
+			FROM pg_database d
+			WHERE datistemplate = false AND has_database_privilege(u.usename, d.datname, 'CONNECT')
+		) AS allowed_databases
+	FROM pg_user u JOIN pg_authid a ON u.usesysid = a.oid`
+
+const dbInfoSQL = `
+	SELECT
+		d.datname,
+		(
+			SELECT array_agg(u.usename::text ORDER BY u.usename)
+			FROM pg_user u
+			WHERE has_database_privilege(u.usename, d.datname, 'CONNECT')
+		) AS allowed_users
+	FROM pg_database d`
+
+const settingsSQL = `
+	SELECT
+		name,
+		setting,
+		vartype,
+		min_val,
+		max_val,
+		enumvals,
+		context,
+		unit,
+		short_desc,
+		pending_restart
+	FROM pg_settings`
+
+// roleAttributes maps role attribute names to their enable and disable keywords.
+var roleAttributes = map[string][2]string{
+	"superuser":  {"SUPERUSER", "NOSUPERUSER"},
+	"createdb":   {"CREATEDB", "NOCREATEDB"},
+	"createrole": {"CREATEROLE", "NOCREATEROLE"},
+	"login":      {"LOGIN", "NOLOGIN"},
+}
+
+func roleAttributeStmt(name, attribute string, enable bool) (statement, error) {
+	keywords, ok := roleAttributes[attribute]
+	if !ok {
+		return statement{}, fmt.Errorf("unknown role attribute %q", attribute)
+	}
+
+	keyword := keywords[1]
+	if enable {
+		keyword = keywords[0]
+	}
+
+	return alterUserStmt(name, keyword), nil
+}
+
+func connectionLimitStmt(name string, limit int) statement {
+	return statement{sql: fmt.Sprintf("ALTER ROLE %s CONNECTION LIMIT %d", quoteIdent(name), limit)}
+}
+
+// validUntilStmt expires a role's password at the given time, or never if
+// until is nil.
+func validUntilStmt(name string, until *time.Time) statement {
+	value := "'infinity'"
+	if until != nil {
+		// A formatted timestamp never needs escaping.
+		value = "'" + until.UTC().Format(time.RFC3339) + "'"
+	}
+
+	return statement{sql: fmt.Sprintf("ALTER ROLE %s VALID UNTIL %s", quoteIdent(name), value)}
+}
+
+func createGroupStmt(name string) statement {
+	return statement{sql: fmt.Sprintf("CREATE ROLE %s NOLOGIN", quoteIdent(name))}
+}
+
+func grantRoleStmt(role, member string) statement {
+	return statement{sql: fmt.Sprintf("GRANT %s TO %s", quoteIdent(role), quoteIdent(member))}
+}
+
+func revokeRoleStmt(role, member string) statement {
+	return statement{sql: fmt.Sprintf("REVOKE %s FROM %s", quoteIdent(role), quoteIdent(member))}
+}
+
+const (
+	PresetReadOnly  = "read-only"
+	PresetReadWrite = "read-write"
+)
+
+// privilegePresets maps presets to their table and sequence privileges.
+var privilegePresets = map[string][2]string{
+	PresetReadOnly:  {"SELECT", "SELECT"},
+	PresetReadWrite: {"SELECT, INSERT, UPDATE, DELETE", "USAGE, SELECT"},
+}
+
+var tablePrivileges = map[string]bool{
+	"SELECT":     true,
+	"INSERT":     true,
+	"UPDATE":     true,
+	"DELETE":     true,
+	"TRUNCATE":   true,
+	"REFERENCES": true,
+	"TRIGGER":    true,
+	"ALL":        true,
+}
+
+// resolvePrivileges returns the table and sequence privileges for a grant.
+// Explicit privileges only apply to tables.
+func resolvePrivileges(grant PrivilegeGrant) (string, string, error) {
+	if grant.Preset != "" {
+		if len(grant.Privileges) > 0 {
+			return "", "", fmt.Errorf("either a preset or privileges can be specified, not both")
+		}
+
+		preset, ok := privilegePresets[grant.Preset]
+		if !ok {
+			return "", "", fmt.Errorf("unknown privilege preset %q", grant.Preset)
+		}
+		return preset[0], preset[1], nil
+	}
+
+	if len(grant.Privileges) == 0 {
+		return "", "", fmt.Errorf("a preset or privileges are required")
+	}
+
+	privileges := make([]string, len(grant.Privileges))
+	for i, p := range grant.Privileges {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		if !tablePrivileges[p] {
+			return "", "", fmt.Errorf("unknown table privilege %q", grant.Privileges[i])
+		}
+		privileges[i] = p
+	}
+
+	return strings.Join(privileges, ", "), "", nil
+}
+
+// grantPrivilegesStmts grants privileges on a single table, or on every
+// table and sequence in a schema. Schema grants also set default privileges
+// so tables created later by the admin user are covered.
+func grantPrivilegesStmts(grant PrivilegeGrant) ([]statement, error) {
+	tablePrivs, seqPrivs, err := resolvePrivileges(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, role := quoteIdent(grant.schema()), quoteIdent(grant.Role)
+
+	stmts := []statement{
+		{sql: fmt.Sprintf("GRANT USAGE ON SCHEMA %s TO %s", schema, role)},
+	}
+
+	if grant.Table != "" {
+		table := pgx.Identifier{grant.schema(), grant.Table}.Sanitize()
+		return append(stmts, statement{sql: fmt.Sprintf("GRANT %s ON TABLE %s TO %s", tablePrivs, table, role)}), nil
+	}
+
+	stmts = append(stmts,
+		statement{sql: fmt.Sprintf("GRANT %s ON ALL TABLES IN SCHEMA %s TO %s", tablePrivs, schema, role)},
+		statement{sql: fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA %s GRANT %s ON TABLES TO %s", schema, tablePrivs, role)},
+	)
+	if seqPrivs != "" {
+		stmts = append(stmts,
+			statement{sql: fmt.Sprintf("GRANT %s ON ALL SEQUENCES IN SCHEMA %s TO %s", seqPrivs, schema, role)},
+			statement{sql: fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA %s GRANT %s ON SEQUENCES TO %s", schema, seqPrivs, role)},
+		)
+	}
+
+	return stmts, nil
+}
+
+// revokePrivilegesStmts undoes grantPrivilegesStmts. Revoking a table grant
+// leaves schema usage in place, as other tables may still be granted.
+func revokePrivilegesStmts(grant PrivilegeGrant) []statement {
+	schema, role := quoteIdent(grant.schema()), quoteIdent(grant.Role)
+
+	if grant.Table != "" {
+		table := pgx.Identifier{grant.schema(), grant.Table}.Sanitize()
+		return []statement{{sql: fmt.Sprintf("REVOKE ALL ON TABLE %s FROM %s", table, role)}}
+	}
+
+	return []statement{
+		{sql: fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA %s REVOKE ALL ON TABLES FROM %s", schema, role)},
+		{sql: fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA %s REVOKE ALL ON SEQUENCES FROM %s", schema, role)},
+		{sql: fmt.Sprintf("REVOKE ALL ON ALL TABLES IN SCHEMA %s FROM %s", schema, role)},
+		{sql: fmt.Sprintf("REVOKE ALL ON ALL SEQUENCES IN SCHEMA %s FROM %s", schema, role)},
+		{sql: fmt.Sprintf("REVOKE USAGE ON SCHEMA %s FROM %s", schema, role)},
+	}
+}
+
+// execAll runs statements in a single transaction.
+func execAll(ctx context.Context, pg *pgx.Conn, stmts []statement) error {
+	tx, err := pg.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(ctx, stmt.sql, stmt.args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+const roleInfoSQL = `
+	SELECT
+		r.rolname,
+		r.rolsuper,
+		r.rolcreatedb,
+		r.rolcreaterole,
+		r.rolcanlogin,
+		r.rolreplication,
+		r.rolconnlimit,
+		r.rolvaliduntil,
+		coalesce((
+			SELECT array_agg(g.rolname::text ORDER BY g.rolname)
+			FROM pg_auth_members m JOIN pg_roles g ON m.roleid = g.oid
+			WHERE m.member = r.oid
+		), '{}') AS member_of
+	FROM pg_roles r
+	WHERE r.rolname !~ '^pg_'`
@@ -0,0 +1,313 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	cases := map[string]string{
+		"flypgadmin":                  `"flypgadmin"`,
+		"MixedCase":                   `"MixedCase"`,
+		`bob"; DROP DATABASE app; --`: `"bob""; DROP DATABASE app; --"`,
+		`a.b`:                         `"a.b"`,
+		"null\x00byte":                `"nullbyte"`,
+		`"quoted"`:                    `"""quoted"""`,
+	}
+
+	for name, expected := range cases {
+		assert.Equal(t, expected, quoteIdent(name), name)
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	cases := map[string]string{
+		"secret":                           `'secret'`,
+		"it's":                             `'it''s'`,
+		`x'; ALTER USER bob SUPERUSER; --`: `'x''; ALTER USER bob SUPERUSER; --'`,
+		`back\slash`:                       `E'back\\slash'`,
+		`\'; DROP TABLE t; --`:             `E'\\''; DROP TABLE t; --'`,
+		"":                                 `''`,
+	}
+
+	for value, expected := range cases {
+		quoted, err := quoteLiteral(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, quoted, value)
+	}
+
+	_, err := quoteLiteral("null\x00byte")
+	assert.Error(t, err)
+}
+
+func TestStatements(t *testing.T) {
+	hostile := `bob"; DROP DATABASE app; --`
+
+	cases := map[string]struct {
+		stmt statement
+		sql  string
+		args []interface{}
+	}{
+		"alter user": {
+			stmt: alterUserStmt(hostile, "SUPERUSER"),
+			sql:  `ALTER USER "bob""; DROP DATABASE app; --" WITH SUPERUSER`,
+		},
+		"drop user": {
+			stmt: dropUserStmt(hostile),
+			sql:  `DROP USER IF EXISTS "bob""; DROP DATABASE app; --"`,
+		},
+		"create database": {
+			stmt: createDatabaseStmt(hostile),
+			sql:  `CREATE DATABASE "bob""; DROP DATABASE app; --"`,
+		},
+		"drop database": {
+			stmt: dropDatabaseStmt(hostile),
+			sql:  `DROP DATABASE "bob""; DROP DATABASE app; --"`,
+		},
+		"grant access": {
+			stmt: grantAccessStmt(hostile, "app"),
+			sql:  `GRANT ALL PRIVILEGES ON DATABASE "bob""; DROP DATABASE app; --" TO "app"`,
+		},
+		"revoke access": {
+			stmt: revokeAccessStmt("app", hostile),
+			sql:  `REVOKE ALL PRIVILEGES ON DATABASE "app" FROM "bob""; DROP DATABASE app; --"`,
+		},
+		"set readonly": {
+			stmt: setReadonlyStmt(hostile, true),
+			sql:  `ALTER DATABASE "bob""; DROP DATABASE app; --" SET default_transaction_read_only=true`,
+		},
+		"find user": {
+			stmt: findUserStmt(hostile),
+			sql:  userInfoSQL + " WHERE u.usename = $1",
+			args: []interface{}{hostile},
+		},
+		"find database": {
+			stmt: findDatabaseStmt(hostile),
+			sql:  dbInfoSQL + " WHERE d.datname = $1",
+			args: []interface{}{hostile},
+		},
+		"resolve settings": {
+			stmt: resolveSettingsStmt([]string{"work_mem", hostile}),
+			sql:  settingsSQL + " WHERE name = ANY($1)",
+			args: []interface{}{[]string{"work_mem", hostile}},
+		},
+		"drop extension": {
+			stmt: dropExtensionStmt(hostile, false),
+			sql:  `DROP EXTENSION "bob""; DROP DATABASE app; --"`,
+		},
+		"drop extension cascade": {
+			stmt: dropExtensionStmt("pg_cron", true),
+			sql:  `DROP EXTENSION "pg_cron" CASCADE`,
+		},
+	}
+
+	for name, c := range cases {
+		assert.Equal(t, c.sql, c.stmt.sql, name)
+		assert.Equal(t, c.args, c.stmt.args, name)
+	}
+}
+
+func TestPasswordStatements(t *testing.T) {
+	cases := map[string]struct {
+		username string
+		password string
+		create   string
+		change   string
+	}{
+		"plain": {
+			username: "app",
+			password: "secret",
+			create:   `CREATE USER "app" WITH LOGIN PASSWORD 'secret'`,
+			change:   `ALTER USER "app" WITH LOGIN PASSWORD 'secret'`,
+		},
+		"quote in password": {
+			username: "app",
+			password: `x'; ALTER USER app SUPERUSER; --`,
+			create:   `CREATE USER "app" WITH LOGIN PASSWORD 'x''; ALTER USER app SUPERUSER; --'`,
+			change:   `ALTER USER "app" WITH LOGIN PASSWORD 'x''; ALTER USER app SUPERUSER; --'`,
+		},
+		"backslash in password": {
+			username: `o'neil`,
+			password: `\'`,
+			create:   `CREATE USER "o'neil" WITH LOGIN PASSWORD E'\\'''`,
+			change:   `ALTER USER "o'neil" WITH LOGIN PASSWORD E'\\'''`,
+		},
+	}
+
+	for name, c := range cases {
+		stmt, err := createUserStmt(c.username, c.password)
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.create, stmt.sql, name)
+
+		stmt, err = changePasswordStmt(c.username, c.password)
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.change, stmt.sql, name)
+	}
+
+	_, err := createUserStmt("app", "null\x00byte")
+	assert.Error(t, err)
+}
+
+func TestUpdateRoleStmts(t *testing.T) {
+	yes, no := true, false
+	limit, badLimit := 10, -2
+	until, infinity, badUntil := "2030-01-02T03:04:05Z", "infinity", "tomorrow"
+
+	cases := map[string]struct {
+		opts RoleOptions
+		sql  []string
+		err  bool
+	}{
+		"attributes": {
+			opts: RoleOptions{Superuser: &no, CreateDB: &yes, CreateRole: &yes, Login: &no},
+			sql: []string{
+				`ALTER USER "o'neil" WITH CREATEDB`,
+				`ALTER USER "o'neil" WITH CREATEROLE`,
+				`ALTER USER "o'neil" WITH NOLOGIN`,
+				`ALTER USER "o'neil" WITH NOSUPERUSER`,
+			},
+		},
+		"connection limit": {
+			opts: RoleOptions{ConnectionLimit: &limit},
+			sql:  []string{`ALTER ROLE "o'neil" CONNECTION LIMIT 10`},
+		},
+		"invalid connection limit": {
+			opts: RoleOptions{ConnectionLimit: &badLimit},
+			err:  true,
+		},
+		"valid until": {
+			opts: RoleOptions{ValidUntil: &until},
+			sql:  []string{`ALTER ROLE "o'neil" VALID UNTIL '2030-01-02T03:04:05Z'`},
+		},
+		"valid until infinity": {
+			opts: RoleOptions{ValidUntil: &infinity},
+			sql:  []string{`ALTER ROLE "o'neil" VALID UNTIL 'infinity'`},
+		},
+		"invalid valid until": {
+			opts: RoleOptions{ValidUntil: &badUntil},
+			err:  true,
+		},
+	}
+
+	for name, c := range cases {
+		stmts, err := updateRoleStmts(`o'neil`, c.opts)
+		if c.err {
+			assert.Error(t, err, name)
+			continue
+		}
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.sql, stmtSQL(stmts), name)
+	}
+}
+
+func TestMembershipStmts(t *testing.T) {
+	assert.Equal(t, `GRANT "read""ers" TO "bob; --"`, grantRoleStmt(`read"ers`, "bob; --").sql)
+	assert.Equal(t, `REVOKE "read""ers" FROM "bob; --"`, revokeRoleStmt(`read"ers`, "bob; --").sql)
+	assert.Equal(t, `CREATE ROLE "read""ers" NOLOGIN`, createGroupStmt(`read"ers`).sql)
+}
+
+func TestGrantPrivilegesStmts(t *testing.T) {
+	cases := map[string]struct {
+		grant PrivilegeGrant
+		sql   []string
+		err   bool
+	}{
+		"read-only schema": {
+			grant: PrivilegeGrant{Role: "app", Preset: PresetReadOnly},
+			sql: []string{
+				`GRANT USAGE ON SCHEMA "public" TO "app"`,
+				`GRANT SELECT ON ALL TABLES IN SCHEMA "public" TO "app"`,
+				`ALTER DEFAULT PRIVILEGES IN SCHEMA "public" GRANT SELECT ON TABLES TO "app"`,
+				`GRANT SELECT ON ALL SEQUENCES IN SCHEMA "public" TO "app"`,
+				`ALTER DEFAULT PRIVILEGES IN SCHEMA "public" GRANT SELECT ON SEQUENCES TO "app"`,
+			},
+		},
+		"read-write hostile schema": {
+			grant: PrivilegeGrant{Role: "app", Schema: `s"; DROP SCHEMA x; --`, Preset: PresetReadWrite},
+			sql: []string{
+				`GRANT USAGE ON SCHEMA "s""; DROP SCHEMA x; --" TO "app"`,
+				`GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA "s""; DROP SCHEMA x; --" TO "app"`,
+				`ALTER DEFAULT PRIVILEGES IN SCHEMA "s""; DROP SCHEMA x; --" GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO "app"`,
+				`GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA "s""; DROP SCHEMA x; --" TO "app"`,
+				`ALTER DEFAULT PRIVILEGES IN SCHEMA "s""; DROP SCHEMA x; --" GRANT USAGE, SELECT ON SEQUENCES TO "app"`,
+			},
+		},
+		"table privileges": {
+			grant: PrivilegeGrant{Role: "app", Schema: "sales", Table: "orders", Privileges: []string{"select", " insert"}},
+			sql: []string{
+				`GRANT USAGE ON SCHEMA "sales" TO "app"`,
+				`GRANT SELECT, INSERT ON TABLE "sales"."orders" TO "app"`,
+			},
+		},
+		"injected privilege": {
+			grant: PrivilegeGrant{Role: "app", Privileges: []string{"SELECT ON pg_authid TO app; --"}},
+			err:   true,
+		},
+		"unknown preset": {
+			grant: PrivilegeGrant{Role: "app", Preset: "owner"},
+			err:   true,
+		},
+		"preset and privileges": {
+			grant: PrivilegeGrant{Role: "app", Preset: PresetReadOnly, Privileges: []string{"SELECT"}},
+			err:   true,
+		},
+		"nothing to grant": {
+			grant: PrivilegeGrant{Role: "app"},
+			err:   true,
+		},
+	}
+
+	for name, c := range cases {
+		stmts, err := grantPrivilegesStmts(c.grant)
+		if c.err {
+			assert.Error(t, err, name)
+			continue
+		}
+		assert.NoError(t, err, name)
+		assert.Equal(t, c.sql, stmtSQL(stmts), name)
+	}
+}
+
+func TestRevokePrivilegesStmts(t *testing.T) {
+	stmts := revokePrivilegesStmts(PrivilegeGrant{Role: "app", Schema: "sales", Table: "orders"})
+	assert.Equal(t, []string{`REVOKE ALL ON TABLE "sales"."orders" FROM "app"`}, stmtSQL(stmts))
+
+	stmts = revokePrivilegesStmts(PrivilegeGrant{Role: "app"})
+	assert.Equal(t, []string{
+		`ALTER DEFAULT PRIVILEGES IN SCHEMA "public" REVOKE ALL ON TABLES FROM "app"`,
+		`ALTER DEFAULT PRIVILEGES IN SCHEMA "public" REVOKE ALL ON SEQUENCES FROM "app"`,
+		`REVOKE ALL ON ALL TABLES IN SCHEMA "public" FROM "app"`,
+		`REVOKE ALL ON ALL SEQUENCES IN SCHEMA "public" FROM "app"`,
+		`REVOKE USAGE ON SCHEMA "public" FROM "app"`,
+	}, stmtSQL(stmts))
+}
+
+func stmtSQL(stmts []statement) []string {
+	sql := []string{}
+	for _, s := range stmts {
+		sql = append(sql, s.sql)
+	}
+	return sql
+}
+
+func TestExtensionStmts(t *testing.T) {
+	stmt, err := createExtensionStmt("postgis_topology", ExtensionOptions{Schema: "gis", Version: "3.1.4", Cascade: true})
+	assert.NoError(t, err)
+	assert.Equal(t, `CREATE EXTENSION "postgis_topology" SCHEMA "gis" VERSION '3.1.4' CASCADE`, stmt.sql)
+
+	stmt, err = createExtensionStmt("pg_stat_statements", ExtensionOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, `CREATE EXTENSION "pg_stat_statements"`, stmt.sql)
+
+	stmt, err = updateExtensionStmt("timescaledb", "2.5'; DROP TABLE t; --")
+	assert.NoError(t, err)
+	assert.Equal(t, `ALTER EXTENSION "timescaledb" UPDATE TO '2.5''; DROP TABLE t; --'`, stmt.sql)
+
+	stmt, err = updateExtensionStmt("timescaledb", "")
+	assert.NoError(t, err)
+	assert.Equal(t, `ALTER EXTENSION "timescaledb" UPDATE`, stmt.sql)
+
+	_, err = updateExtensionStmt("timescaledb", "null\x00byte")
+	assert.Error(t, err)
+}
@@ -18,6 +18,10 @@ func PGPort() string {
 }
 
 func openConnection(ctx context.Context, hosts []string, mode string, creds Credentials) (*pgx.Conn, error) {
+	return openDatabaseConnection(ctx, hosts, mode, "postgres", creds)
+}
+
+func openDatabaseConnection(ctx context.Context, hosts []string, mode string, database string, creds Credentials) (*pgx.Conn, error) {
 	if mode == "" {
 		mode = "any"
 	}
@@ -32,6 +36,7 @@ func openConnection(ctx context.Context, hosts []string, mode string, creds Cred
 		if err != nil {
 			return nil, err
 		}
+		conf.Database = database
 		conf.User = creds.Username
 		conf.Password = creds.Password
 		conf.ConnectTimeout = 5 * time.Second
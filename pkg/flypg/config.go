@@ -8,7 +8,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -17,9 +16,20 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
+// Logger is what InitConfig logs through, so its lines end up in the same
+// stream as the processes it configures.
+type Logger interface {
+	Printf(format string, a ...interface{})
+}
+
 const InitModeNew = "new"
 const InitModeExisting = "existing"
 
+// OverridesFilename holds the tuning profile and pg parameters that win over
+// generated ones. It lives on the volume so overrides survive deploys,
+// CLUSTER_SPEC_OVERRIDES can point it elsewhere.
+const OverridesFilename = "/data/cluster-spec.overrides.json"
+
 type Config struct {
 	InitMode                  string            `json:"initMode"`
 	ExistingConfig            map[string]string `json:"existingConfig"`
@@ -28,43 +38,71 @@ type Config struct {
 	DeadKeeperRemovalInterval string            `json:"deadKeeperRemovalInterval"`
 }
 
+// Overrides are kept apart from the cluster spec, stolon doesn't know about
+// them.
+type Overrides struct {
+	// Profile is the tuning profile, PG_TUNING_PROFILE takes precedence.
+	Profile      string            `json:"profile,omitempty"`
+	PGParameters map[string]string `json:"pgParameters,omitempty"`
+}
+
 type KeeperState struct {
 	UID        string `json:"UID"`
 	ClusterUID string `json:"ClusterUID"`
 }
 
-func InitConfig(filename string) (*Config, error) {
+func InitConfig(filename string, logger Logger) (*Config, error) {
 	filename, err := filepath.Abs(filename)
 	if err != nil {
 		log.Fatalln("error cleaning filename", err)
 	}
 
-	// Detect if TimescaleDB is installed.
-	preloadShared := []string{}
-	tsEnabled, err := strconv.ParseBool(os.Getenv("TIMESCALEDB_ENABLED"))
-	if err == nil && tsEnabled {
-		preloadShared = append(preloadShared, "timescaledb")
+	preloadShared, err := PreloadLibraries()
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("cluster spec filename", filename)
-	cfg, err := readConfig(filename)
+	logger.Printf("cluster spec filename %s", filename)
+	current, err := readConfig(filename)
 	if err == nil {
-		fmt.Println("cluster spec already exists")
-		writeJson(os.Stdout, cfg)
-		// return
+		logger.Printf("cluster spec already exists")
 	} else if !os.IsNotExist(err) {
 		return nil, errors.Wrap(err, "error loading cluster spec")
 	}
 
-	mem, err := memTotal()
+	// The spec generated on the previous boot tells hand edits apart from
+	// generated values. Without one, everything on disk is treated as
+	// generated.
+	generated, err := readConfig(generatedFilename(filename))
+	if os.IsNotExist(err) {
+		generated = current
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error loading generated cluster spec")
+	}
+
+	overrides, err := readOverrides(overridesFilename())
+	if err == nil {
+		logger.Printf("cluster spec overrides %s", overridesFilename())
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "error loading cluster spec overrides")
+	}
+
+	res, err := SystemResources()
 	if err != nil {
 		return nil, errors.Wrap(err, "error fetching total system memory")
 	}
 
-	fmt.Printf("system memory: %dmb vcpu count: %d\n", mem, runtime.NumCPU())
+	profile, err := TuningProfile()
+	if err != nil {
+		return nil, errors.Wrap(err, "error resolving tuning profile")
+	}
 
-	workMem := max(4, (mem / 64))
-	maintenanceWorkMem := max(64, (mem / 20))
+	logger.Printf("system memory: %dmb vcpu count: %d tuning profile: %s", res.MemoryMb, res.CPUs, profile)
+
+	tuning, err := TuningParameters(profile, res)
+	if err != nil {
+		return nil, err
+	}
 
 	initMode := InitModeNew
 	existingConfig := map[string]string{}
@@ -98,29 +136,25 @@ func InitConfig(filename string) (*Config, error) {
 		}
 	}
 
-	cfg = Config{
+	cfg := Config{
 		InitMode:                  initMode,
 		ExistingConfig:            existingConfig,
 		MaxStandbysPerSender:      50,
 		DeadKeeperRemovalInterval: "1h",
 		PGParameters: map[string]string{
-			"random_page_cost":                "1.1",
-			"effective_io_concurrency":        "200",
-			"shared_buffers":                  fmt.Sprintf("%dMB", mem/4),
-			"effective_cache_size":            fmt.Sprintf("%dMB", 3*mem/4),
-			"maintenance_work_mem":            fmt.Sprintf("%dMB", maintenanceWorkMem),
-			"work_mem":                        fmt.Sprintf("%dMB", workMem),
-			"max_connections":                 "300",
-			"max_worker_processes":            "8",
-			"max_parallel_workers":            "8",
-			"max_parallel_workers_per_gather": "2",
-			"wal_compression":                 "on",
-			"archive_mode":                    "on",
-			"archive_command":                 "if [ $ENABLE_WALG ]; then /usr/local/bin/wal-g wal-push \"%p\"; fi",
-			"archive_timeout":                 "60",
+			"random_page_cost":         "1.1",
+			"effective_io_concurrency": "200",
+			"wal_compression":          "on",
+			"archive_mode":             "on",
+			"archive_command":          "if [ $ENABLE_WALG ]; then /usr/local/bin/wal-g wal-push \"%p\"; fi",
+			"archive_timeout":          "60",
 		},
 	}
 
+	for name, value := range tuning {
+		cfg.PGParameters[name] = value
+	}
+
 	if len(preloadShared) > 0 {
 		cfg.PGParameters["shared_preload_libraries"] = strings.Join(preloadShared, ",")
 	}
@@ -163,17 +197,53 @@ func InitConfig(filename string) (*Config, error) {
 		}
 	}
 
-	writeJson(os.Stdout, cfg)
+	defaults := cfg
+
+	params, changes := mergeParameters(cfg.PGParameters, generated.PGParameters, current.PGParameters, overrides.PGParameters)
+	cfg.PGParameters = params
+
+	logChanges(logger, changes)
+	if spec, err := json.Marshal(cfg); err == nil {
+		logger.Printf("cluster spec %s", spec)
+	}
 
 	if err := writeConfig(filename, cfg); err != nil {
 		return nil, errors.Wrap(err, "error writing cluster-spec.json")
 	}
 
-	fmt.Println("generated new config")
+	if err := writeConfig(generatedFilename(filename), defaults); err != nil {
+		return nil, errors.Wrap(err, "error writing generated cluster spec")
+	}
+
+	logger.Printf("generated new config")
 
 	return &cfg, nil
 }
 
+// generatedFilename is where the spec is kept as generated, before edits and
+// overrides are merged in.
+func generatedFilename(filename string) string {
+	return filename + ".generated"
+}
+
+func overridesFilename() string {
+	if path := os.Getenv("CLUSTER_SPEC_OVERRIDES"); path != "" {
+		return path
+	}
+	return OverridesFilename
+}
+
+func readOverrides(filename string) (overrides Overrides, err error) {
+	var data []byte
+	data, err = os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(data, &overrides)
+	return
+}
+
 func readConfig(filename string) (cfg Config, err error) {
 	var data []byte
 	data, err = os.ReadFile(filename)
@@ -0,0 +1,103 @@
+package flypg
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	reasonDefault  = "default changed"
+	reasonOverride = "override"
+	reasonEdited   = "edited in cluster spec"
+)
+
+// parameterChange is a pg parameter that differs from the spec written on the
+// previous boot, or an edit that was kept over a new default.
+type parameterChange struct {
+	Name string
+	// Old and New are empty when the parameter is unset.
+	Old, New string
+	// Default is the generated value when it lost to an edit or override.
+	Default string
+	Reason  string
+}
+
+// mergeParameters does a three-way merge of pg parameters. defaults are
+// generated on this boot, generated are the ones generated on the previous
+// boot and current are the ones in the spec on disk. Parameters that differ
+// between generated and current were edited by hand and are kept, overrides
+// win over everything else.
+func mergeParameters(defaults, generated, current, overrides map[string]string) (map[string]string, []parameterChange) {
+	names := map[string]bool{}
+	for _, params := range []map[string]string{defaults, generated, current, overrides} {
+		for name := range params {
+			names[name] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	merged := map[string]string{}
+	changes := []parameterChange{}
+
+	for _, name := range sorted {
+		def, hasDef := defaults[name]
+		cur, hasCur := current[name]
+		gen, hasGen := generated[name]
+
+		value, ok, reason := def, hasDef, reasonDefault
+		if override, hasOverride := overrides[name]; hasOverride {
+			value, ok, reason = override, true, reasonOverride
+		} else if hasCur != hasGen || cur != gen {
+			value, ok, reason = cur, hasCur, reasonEdited
+		}
+
+		if ok {
+			merged[name] = value
+		}
+
+		switch {
+		case ok != hasCur || value != cur:
+			change := parameterChange{Name: name, Old: cur, New: value, Reason: reason}
+			if reason != reasonDefault && value != def {
+				change.Default = def
+			}
+			changes = append(changes, change)
+		case reason != reasonDefault && (ok != hasDef || value != def):
+			changes = append(changes, parameterChange{Name: name, Old: cur, New: value, Default: def, Reason: reason})
+		}
+	}
+
+	return merged, changes
+}
+
+// logChanges logs a line for every change.
+func logChanges(logger Logger, changes []parameterChange) {
+	if len(changes) == 0 {
+		logger.Printf("pg parameters unchanged")
+		return
+	}
+
+	logger.Printf("pg parameters:")
+	for _, c := range changes {
+		line := fmt.Sprintf("  %s: %s -> %s (%s)", c.Name, unset(c.Old), unset(c.New), c.Reason)
+		if c.Old == c.New {
+			line = fmt.Sprintf("  %s: kept %s (%s)", c.Name, unset(c.New), c.Reason)
+		}
+		if c.Default != "" {
+			line += fmt.Sprintf(", default is %s", c.Default)
+		}
+		logger.Printf("%s", line)
+	}
+}
+
+func unset(value string) string {
+	if value == "" {
+		return "<unset>"
+	}
+	return value
+}
@@ -0,0 +1,105 @@
+package flypg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeParameters(t *testing.T) {
+	cases := []struct {
+		name                                   string
+		defaults, generated, current, override map[string]string
+		merged                                 map[string]string
+		changes                                []parameterChange
+	}{
+		{
+			name:     "first boot",
+			defaults: map[string]string{"work_mem": "4MB"},
+			merged:   map[string]string{"work_mem": "4MB"},
+			changes: []parameterChange{
+				{Name: "work_mem", New: "4MB", Reason: reasonDefault},
+			},
+		},
+		{
+			name:      "unchanged",
+			defaults:  map[string]string{"work_mem": "4MB"},
+			generated: map[string]string{"work_mem": "4MB"},
+			current:   map[string]string{"work_mem": "4MB"},
+			merged:    map[string]string{"work_mem": "4MB"},
+			changes:   []parameterChange{},
+		},
+		{
+			name:      "resize",
+			defaults:  map[string]string{"work_mem": "8MB"},
+			generated: map[string]string{"work_mem": "4MB"},
+			current:   map[string]string{"work_mem": "4MB"},
+			merged:    map[string]string{"work_mem": "8MB"},
+			changes: []parameterChange{
+				{Name: "work_mem", Old: "4MB", New: "8MB", Reason: reasonDefault},
+			},
+		},
+		{
+			name:      "edit kept",
+			defaults:  map[string]string{"work_mem": "8MB"},
+			generated: map[string]string{"work_mem": "4MB"},
+			current:   map[string]string{"work_mem": "16MB"},
+			merged:    map[string]string{"work_mem": "16MB"},
+			changes: []parameterChange{
+				{Name: "work_mem", Old: "16MB", New: "16MB", Default: "8MB", Reason: reasonEdited},
+			},
+		},
+		{
+			name:      "added and removed by hand",
+			defaults:  map[string]string{"work_mem": "4MB"},
+			generated: map[string]string{"work_mem": "4MB"},
+			current:   map[string]string{"log_min_duration_statement": "1000"},
+			merged:    map[string]string{"log_min_duration_statement": "1000"},
+			changes: []parameterChange{
+				{Name: "log_min_duration_statement", Old: "1000", New: "1000", Reason: reasonEdited},
+				{Name: "work_mem", Default: "4MB", Reason: reasonEdited},
+			},
+		},
+		{
+			name:      "override",
+			defaults:  map[string]string{"work_mem": "8MB", "max_connections": "300"},
+			generated: map[string]string{"work_mem": "4MB", "max_connections": "300"},
+			current:   map[string]string{"work_mem": "16MB", "max_connections": "300"},
+			override:  map[string]string{"work_mem": "32MB"},
+			merged:    map[string]string{"work_mem": "32MB", "max_connections": "300"},
+			changes: []parameterChange{
+				{Name: "work_mem", Old: "16MB", New: "32MB", Default: "8MB", Reason: reasonOverride},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			merged, changes := mergeParameters(c.defaults, c.generated, c.current, c.override)
+			assert.Equal(t, c.merged, merged)
+			assert.Equal(t, c.changes, changes)
+		})
+	}
+}
+
+// lineLogger keeps every logged line.
+type lineLogger []string
+
+func (l *lineLogger) Printf(format string, a ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, a...))
+}
+
+func TestLogChanges(t *testing.T) {
+	var lines lineLogger
+	logChanges(&lines, []parameterChange{
+		{Name: "shared_buffers", Old: "256MB", New: "512MB", Reason: reasonDefault},
+		{Name: "work_mem", Old: "16MB", New: "16MB", Default: "8MB", Reason: reasonEdited},
+	})
+
+	assert.Equal(t, lineLogger{
+		"pg parameters:",
+		"  shared_buffers: 256MB -> 512MB (default changed)",
+		"  work_mem: kept 16MB (edited in cluster spec), default is 8MB",
+	}, lines)
+}
@@ -0,0 +1,170 @@
+package flypg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+)
+
+// Revisions are recorded as pending before the cluster spec is patched, and
+// marked applied or failed afterwards. A revision left pending may or may
+// not have been applied.
+const (
+	RevisionPending = "pending"
+	RevisionApplied = "applied"
+	RevisionFailed  = "failed"
+)
+
+// SettingsRevision is a change to the pg parameters of the cluster spec.
+type SettingsRevision struct {
+	Revision int64     `json:"revision"`
+	Time     time.Time `json:"time"`
+	Caller   string    `json:"caller"`
+	Reason   string    `json:"reason,omitempty"`
+	Status   string    `json:"status"`
+	// Before and After hold the parameters that changed, null is unset.
+	Before map[string]*string `json:"before"`
+	After  map[string]*string `json:"after"`
+	// Generation is the cluster generation the change was applied at.
+	Generation int64 `json:"generation"`
+}
+
+// SettingsHistory keeps settings revisions in the backend store, next to the
+// stolon cluster data, so every member sees the same history.
+type SettingsHistory struct {
+	client *stolon.Client
+}
+
+func NewSettingsHistory(client *stolon.Client) *SettingsHistory {
+	return &SettingsHistory{client: client}
+}
+
+func (h *SettingsHistory) prefix() string {
+	return h.client.Key("flypg/settings/history") + "/"
+}
+
+// Record stores rev as the next revision.
+func (h *SettingsHistory) Record(ctx context.Context, rev *SettingsRevision) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		revs, err := h.List(ctx)
+		if err != nil {
+			return err
+		}
+
+		rev.Revision = 1
+		if len(revs) > 0 {
+			rev.Revision = revs[len(revs)-1].Revision + 1
+		}
+
+		data, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+
+		// Creating the key fails if another change took the revision.
+		err = h.client.Store().AtomicPut(ctx, h.key(rev.Revision), data, nil)
+		if errors.Is(err, stolon.ErrKeyModified) {
+			continue
+		}
+		return err
+	}
+
+	return fmt.Errorf("failed to record settings revision: %w", stolon.ErrKeyModified)
+}
+
+// SetStatus updates the status of a recorded revision.
+func (h *SettingsHistory) SetStatus(ctx context.Context, rev *SettingsRevision, status string) error {
+	rev.Status = status
+
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+
+	return h.client.Store().Put(ctx, h.key(rev.Revision), data)
+}
+
+func (h *SettingsHistory) key(revision int64) string {
+	return fmt.Sprintf("%s%010d", h.prefix(), revision)
+}
+
+// List returns every revision, oldest first.
+func (h *SettingsHistory) List(ctx context.Context) ([]SettingsRevision, error) {
+	pairs, err := h.client.Store().List(ctx, h.prefix())
+	if err != nil {
+		return nil, err
+	}
+
+	revs := []SettingsRevision{}
+	for _, pair := range pairs {
+		var rev SettingsRevision
+		if err := json.Unmarshal(pair.Value, &rev); err != nil {
+			return nil, fmt.Errorf("error decoding settings revision %s: %w", pair.Key, err)
+		}
+		revs = append(revs, rev)
+	}
+
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].Revision < revs[j].Revision
+	})
+
+	return revs, nil
+}
+
+// DiffParameters returns the current and new values of the parameters that
+// params changes.
+func DiffParameters(current map[string]string, params map[string]*string) (before, after map[string]*string) {
+	before, after = map[string]*string{}, map[string]*string{}
+
+	for name, value := range params {
+		var old *string
+		if v, ok := current[name]; ok {
+			old = &v
+		}
+
+		if (old == nil) == (value == nil) && (old == nil || *old == *value) {
+			continue
+		}
+
+		before[name], after[name] = old, value
+	}
+
+	return before, after
+}
+
+// RollbackParameters returns the parameters that undo every revision after
+// to. Each gets the value it had before the first of them changed it. Changes
+// made outside of the history, and revisions that failed, aren't undone.
+func RollbackParameters(history []SettingsRevision, to int64) (map[string]*string, error) {
+	found := to == 0
+	params := map[string]*string{}
+
+	// Walking back from the latest revision leaves the oldest value.
+	for i := len(history) - 1; i >= 0; i-- {
+		rev := history[i]
+		if rev.Revision <= to {
+			found = found || rev.Revision == to
+			break
+		}
+		if rev.Status == RevisionFailed {
+			continue
+		}
+		for name, value := range rev.Before {
+			params[name] = value
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("settings revision %d doesn't exist", to)
+	}
+	if len(params) == 0 {
+		return nil, fmt.Errorf("settings revision %d is the latest", to)
+	}
+
+	return params, nil
+}
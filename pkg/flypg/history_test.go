@@ -0,0 +1,127 @@
+package flypg
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffParameters(t *testing.T) {
+	current := map[string]string{"work_mem": "4MB", "max_connections": "300"}
+
+	before, after := DiffParameters(current, map[string]*string{
+		"work_mem":                   strPtr("8MB"),
+		"max_connections":            strPtr("300"),
+		"log_min_duration_statement": strPtr("1s"),
+		"random_page_cost":           nil,
+	})
+
+	assert.Equal(t, map[string]*string{"work_mem": strPtr("4MB"), "log_min_duration_statement": nil}, before)
+	assert.Equal(t, map[string]*string{"work_mem": strPtr("8MB"), "log_min_duration_statement": strPtr("1s")}, after)
+}
+
+func TestRollbackParameters(t *testing.T) {
+	history := []SettingsRevision{
+		{Revision: 1, Before: map[string]*string{"work_mem": strPtr("4MB")}, After: map[string]*string{"work_mem": strPtr("8MB")}},
+		{Revision: 2, Before: map[string]*string{"work_mem": strPtr("8MB"), "jit": nil}, After: map[string]*string{"work_mem": strPtr("16MB"), "jit": strPtr("off")}},
+		{Revision: 3, Before: map[string]*string{"work_mem": strPtr("16MB")}, After: map[string]*string{"work_mem": strPtr("1GB")}},
+	}
+
+	params, err := RollbackParameters(history, 2)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*string{"work_mem": strPtr("16MB")}, params)
+
+	params, err = RollbackParameters(history, 1)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*string{"work_mem": strPtr("8MB"), "jit": nil}, params)
+
+	params, err = RollbackParameters(history, 0)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*string{"work_mem": strPtr("4MB"), "jit": nil}, params)
+
+	_, err = RollbackParameters(history, 3)
+	assert.EqualError(t, err, "settings revision 3 is the latest")
+
+	failed := append(history, SettingsRevision{Revision: 4, Status: RevisionFailed,
+		Before: map[string]*string{"jit": strPtr("on")}, After: map[string]*string{"jit": strPtr("off")}})
+	params, err = RollbackParameters(failed, 2)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*string{"work_mem": strPtr("16MB")}, params)
+
+	_, err = RollbackParameters(history, 7)
+	assert.EqualError(t, err, "settings revision 7 doesn't exist")
+}
+
+func TestSettingsHistory(t *testing.T) {
+	ctx := context.Background()
+	history := NewSettingsHistory(stolon.NewClient(newMemStore(), "stolon/cluster", "app"))
+
+	revs, err := history.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, revs)
+
+	var last *SettingsRevision
+	for _, caller := range []string{"alice", "bob"} {
+		last = &SettingsRevision{Caller: caller, Status: RevisionPending}
+		require.NoError(t, history.Record(ctx, last))
+	}
+	require.NoError(t, history.SetStatus(ctx, last, RevisionApplied))
+
+	revs, err = history.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, revs, 2)
+	assert.Equal(t, int64(1), revs[0].Revision)
+	assert.Equal(t, "alice", revs[0].Caller)
+	assert.Equal(t, int64(2), revs[1].Revision)
+	assert.Equal(t, "bob", revs[1].Caller)
+	assert.Equal(t, RevisionPending, revs[0].Status)
+	assert.Equal(t, RevisionApplied, revs[1].Status)
+}
+
+// memStore is an in-memory stolon.Store.
+type memStore struct {
+	values map[string]*stolon.KVPair
+	rev    uint64
+}
+
+func newMemStore() *memStore {
+	return &memStore{values: map[string]*stolon.KVPair{}}
+}
+
+func (s *memStore) Get(ctx context.Context, key string) (*stolon.KVPair, error) {
+	pair, ok := s.values[key]
+	if !ok {
+		return nil, stolon.ErrKeyNotFound
+	}
+	return pair, nil
+}
+
+func (s *memStore) Put(ctx context.Context, key string, value []byte) error {
+	s.rev++
+	s.values[key] = &stolon.KVPair{Key: key, Value: value, Revision: s.rev}
+	return nil
+}
+
+func (s *memStore) AtomicPut(ctx context.Context, key string, value []byte, previous *stolon.KVPair) error {
+	current, ok := s.values[key]
+	if (previous == nil && ok) || (previous != nil && (!ok || current.Revision != previous.Revision)) {
+		return stolon.ErrKeyModified
+	}
+	return s.Put(ctx, key, value)
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]*stolon.KVPair, error) {
+	pairs := []*stolon.KVPair{}
+	for key, pair := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, pair)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs, nil
+}
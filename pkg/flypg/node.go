@@ -182,3 +182,10 @@ func (n *Node) NewProxyConnection(ctx context.Context) (*pgx.Conn, error) {
 	host := net.JoinHostPort(n.PrivateIP.String(), strconv.Itoa(n.PGProxyPort))
 	return openConnection(ctx, []string{host}, "any", n.SUCredentials)
 }
+
+// NewProxyDatabaseConnection connects to a specific database through the
+// proxy, for changes that only apply within a database such as schema grants.
+func (n *Node) NewProxyDatabaseConnection(ctx context.Context, database string) (*pgx.Conn, error) {
+	host := net.JoinHostPort(n.PrivateIP.String(), strconv.Itoa(n.PGProxyPort))
+	return openDatabaseConnection(ctx, []string{host}, "any", database, n.SUCredentials)
+}
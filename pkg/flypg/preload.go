@@ -0,0 +1,77 @@
+package flypg
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// preloadLibraries are the libraries shared_preload_libraries can be set to,
+// anything else keeps postgres from starting.
+var preloadLibraries = map[string]bool{
+	"auto_explain":       true,
+	"pg_cron":            true,
+	"pg_stat_statements": true,
+	"timescaledb":        true,
+}
+
+// PreloadLibrary returns the library an extension needs in
+// shared_preload_libraries before it can be created.
+func PreloadLibrary(extension string) (string, bool) {
+	switch extension {
+	case "pg_cron", "pg_stat_statements", "timescaledb":
+		return extension, true
+	}
+	return "", false
+}
+
+// PreloadLibraries reads the comma separated PG_PRELOAD_LIBRARIES.
+// TIMESCALEDB_ENABLED still adds timescaledb.
+func PreloadLibraries() ([]string, error) {
+	libs := ParsePreloadLibraries(os.Getenv("PG_PRELOAD_LIBRARIES"))
+
+	if enabled, err := strconv.ParseBool(os.Getenv("TIMESCALEDB_ENABLED")); err == nil && enabled {
+		libs, _ = AddPreloadLibrary(libs, "timescaledb")
+	}
+
+	for _, lib := range libs {
+		if !preloadLibraries[lib] {
+			return nil, fmt.Errorf("unsupported preload library %q, expected one of %v", lib, supportedPreloadLibraries())
+		}
+	}
+
+	return libs, nil
+}
+
+// ParsePreloadLibraries splits a shared_preload_libraries value.
+func ParsePreloadLibraries(value string) []string {
+	libs := []string{}
+	for _, lib := range strings.Split(value, ",") {
+		lib = strings.Trim(strings.TrimSpace(lib), `"`)
+		if lib != "" {
+			libs, _ = AddPreloadLibrary(libs, lib)
+		}
+	}
+	return libs
+}
+
+// AddPreloadLibrary appends lib unless it is already in libs.
+func AddPreloadLibrary(libs []string, lib string) ([]string, bool) {
+	for _, l := range libs {
+		if l == lib {
+			return libs, false
+		}
+	}
+	return append(libs, lib), true
+}
+
+func supportedPreloadLibraries() []string {
+	libs := make([]string, 0, len(preloadLibraries))
+	for lib := range preloadLibraries {
+		libs = append(libs, lib)
+	}
+	sort.Strings(libs)
+	return libs
+}
@@ -0,0 +1,29 @@
+package flypg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePreloadLibraries(t *testing.T) {
+	cases := map[string][]string{
+		"":                              {},
+		"timescaledb":                   {"timescaledb"},
+		"pg_stat_statements, pg_cron":   {"pg_stat_statements", "pg_cron"},
+		`"auto_explain",,auto_explain `: {"auto_explain"},
+	}
+
+	for value, expected := range cases {
+		assert.Equal(t, expected, ParsePreloadLibraries(value), value)
+	}
+}
+
+func TestPreloadLibrary(t *testing.T) {
+	lib, ok := PreloadLibrary("pg_cron")
+	assert.True(t, ok)
+	assert.Equal(t, "pg_cron", lib)
+
+	_, ok = PreloadLibrary("postgis")
+	assert.False(t, ok)
+}
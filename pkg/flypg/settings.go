@@ -1,5 +1,13 @@
 package flypg
 
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
 type Setting struct {
 	Name           *string   `json:"name,omitempty"`
 	Setting        *string   `json:"setting,omitempty"`
@@ -17,3 +25,136 @@ type Setting struct {
 type Settings struct {
 	Settings []Setting `json:"settings,omitempty"`
 }
+
+// How a setting change takes effect, classified by its pg_settings context.
+const (
+	ApplyReload  = "reload"
+	ApplyRestart = "restart"
+	// ApplySuperuser settings are applied on reload, but only superusers can
+	// override them in a session.
+	ApplySuperuser = "superuser"
+)
+
+var (
+	numericValue = regexp.MustCompile(`^\s*([-+]?(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][-+]?[0-9]+)?)\s*([a-zA-Z]*)\s*$`)
+
+	memoryUnits = map[string]float64{"B": 1, "kB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40}
+	timeUnits   = map[string]float64{"us": 1, "ms": 1e3, "s": 1e6, "min": 60e6, "h": 3600e6, "d": 86400e6}
+
+	boolValues = map[string]bool{
+		"on": true, "off": true, "true": true, "false": true, "yes": true, "no": true,
+		"1": true, "0": true, "t": true, "f": true, "y": true, "n": true,
+	}
+)
+
+// Apply returns how a change to the setting takes effect.
+func (s Setting) Apply() (string, error) {
+	switch value(s.Context) {
+	case "postmaster":
+		return ApplyRestart, nil
+	case "superuser", "superuser-backend":
+		return ApplySuperuser, nil
+	case "sighup", "backend", "user":
+		return ApplyReload, nil
+	}
+	return "", fmt.Errorf("%s can't be changed", value(s.Name))
+}
+
+// Validate checks v against the type and range of the setting, the way
+// postgres would parse it.
+func (s Setting) Validate(v string) error {
+	switch value(s.VarType) {
+	case "bool":
+		if !boolValues[strings.ToLower(strings.TrimSpace(v))] {
+			return fmt.Errorf("%q is not a boolean", v)
+		}
+	case "enum":
+		values := make([]string, 0, len(s.EnumVals))
+		for _, e := range s.EnumVals {
+			if strings.EqualFold(value(e), strings.TrimSpace(v)) {
+				return nil
+			}
+			values = append(values, value(e))
+		}
+		return fmt.Errorf("%q is not one of %s", v, strings.Join(values, ", "))
+	case "integer", "real":
+		n, err := s.parseNumeric(v)
+		if err != nil {
+			return err
+		}
+		lo, errLo := strconv.ParseFloat(value(s.MinVal), 64)
+		hi, errHi := strconv.ParseFloat(value(s.MaxVal), 64)
+		if errLo == nil && errHi == nil && (n < lo || n > hi) {
+			return fmt.Errorf("%q is out of range, expected %s to %s%s", v, value(s.MinVal), value(s.MaxVal), value(s.Unit))
+		}
+	}
+	return nil
+}
+
+// parseNumeric returns v in the unit of the setting.
+func (s Setting) parseNumeric(v string) (float64, error) {
+	match := numericValue.FindStringSubmatch(v)
+	if match == nil {
+		return 0, fmt.Errorf("%q is not a number", v)
+	}
+
+	n, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", v)
+	}
+
+	suffix := match[2]
+	if suffix == "" {
+		return n, nil
+	}
+
+	base, units, ok := parseUnit(value(s.Unit))
+	if !ok {
+		return 0, fmt.Errorf("%q can't have a unit", v)
+	}
+
+	factor, ok := units[suffix]
+	if !ok {
+		names := make([]string, 0, len(units))
+		for name := range units {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return units[names[i]] < units[names[j]] })
+		return 0, fmt.Errorf("invalid unit %q, expected one of %s", suffix, strings.Join(names, ", "))
+	}
+
+	return n * factor / base, nil
+}
+
+// parseUnit returns the size of a pg_settings unit such as 8kB or ms, and
+// the units values of the same kind can be written in.
+func parseUnit(unit string) (float64, map[string]float64, bool) {
+	i := 0
+	for i < len(unit) && unit[i] >= '0' && unit[i] <= '9' {
+		i++
+	}
+
+	multiplier := 1.0
+	if i > 0 {
+		n, err := strconv.Atoi(unit[:i])
+		if err != nil {
+			return 0, nil, false
+		}
+		multiplier = float64(n)
+	}
+
+	if size, ok := memoryUnits[unit[i:]]; ok {
+		return multiplier * size, memoryUnits, true
+	}
+	if size, ok := timeUnits[unit[i:]]; ok {
+		return multiplier * size, timeUnits, true
+	}
+	return 0, nil, false
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,85 @@
+package flypg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingValidate(t *testing.T) {
+	sharedBuffers := setting("shared_buffers", "integer", "postmaster", "8kB", "16", "1073741823")
+	workMem := setting("work_mem", "integer", "user", "kB", "64", "2147483647")
+	timeout := setting("statement_timeout", "integer", "user", "ms", "0", "2147483647")
+	completion := setting("checkpoint_completion_target", "real", "sighup", "", "0", "1")
+	fsync := setting("fsync", "bool", "sighup", "", "", "")
+	walLevel := setting("wal_level", "enum", "postmaster", "", "", "")
+	walLevel.EnumVals = []*string{strPtr("minimal"), strPtr("replica"), strPtr("logical")}
+
+	cases := []struct {
+		setting Setting
+		value   string
+		err     string
+	}{
+		{setting: sharedBuffers, value: "128MB"},
+		{setting: sharedBuffers, value: "16384"},
+		{setting: sharedBuffers, value: "64kB", err: `"64kB" is out of range, expected 16 to 10737418238kB`},
+		{setting: sharedBuffers, value: "1 GB"},
+		{setting: sharedBuffers, value: "1gb", err: `invalid unit "gb", expected one of B, kB, MB, GB, TB`},
+		{setting: sharedBuffers, value: "lots", err: `"lots" is not a number`},
+		{setting: workMem, value: "4MB"},
+		{setting: workMem, value: "32kB", err: `"32kB" is out of range, expected 64 to 2147483647kB`},
+		{setting: timeout, value: "5min"},
+		{setting: timeout, value: "5MB", err: `invalid unit "MB", expected one of us, ms, s, min, h, d`},
+		{setting: completion, value: "0.9"},
+		{setting: completion, value: "1.5", err: `"1.5" is out of range, expected 0 to 1`},
+		{setting: completion, value: "0.9s", err: `"0.9s" can't have a unit`},
+		{setting: fsync, value: "ON"},
+		{setting: fsync, value: "maybe", err: `"maybe" is not a boolean`},
+		{setting: walLevel, value: "Logical"},
+		{setting: walLevel, value: "archive", err: `"archive" is not one of minimal, replica, logical`},
+	}
+
+	for _, c := range cases {
+		err := c.setting.Validate(c.value)
+		if c.err == "" {
+			assert.NoError(t, err, c.value)
+		} else {
+			assert.EqualError(t, err, c.err, c.value)
+		}
+	}
+}
+
+func TestSettingApply(t *testing.T) {
+	cases := map[string]string{
+		"postmaster":        ApplyRestart,
+		"sighup":            ApplyReload,
+		"user":              ApplyReload,
+		"backend":           ApplyReload,
+		"superuser":         ApplySuperuser,
+		"superuser-backend": ApplySuperuser,
+	}
+
+	for context, expected := range cases {
+		apply, err := setting("x", "integer", context, "", "", "").Apply()
+		assert.NoError(t, err, context)
+		assert.Equal(t, expected, apply, context)
+	}
+
+	_, err := setting("block_size", "integer", "internal", "", "", "").Apply()
+	assert.EqualError(t, err, "block_size can't be changed")
+}
+
+func setting(name, vartype, context, unit, min, max string) Setting {
+	return Setting{
+		Name:    strPtr(name),
+		VarType: strPtr(vartype),
+		Context: strPtr(context),
+		Unit:    strPtr(unit),
+		MinVal:  strPtr(min),
+		MaxVal:  strPtr(max),
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
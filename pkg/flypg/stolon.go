@@ -1,17 +1,20 @@
 package flypg
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
-	"github.com/pkg/errors"
+	"github.com/fly-examples/postgres-ha/pkg/privnet"
+	"github.com/jackc/pgx/v4"
 )
 
-var ErrClusterNotInitialized = errors.New("cluster not initialized")
+var ErrClusterNotInitialized = stolon.ErrClusterNotInitialized
 
 func keeperUID(privateIP net.IP) string {
 	if data, err := os.ReadFile("/data/keeperstate"); err == nil {
@@ -23,6 +26,12 @@ func keeperUID(privateIP net.IP) string {
 		}
 	}
 
+	return KeeperUIDFromIP(privateIP)
+}
+
+// KeeperUIDFromIP returns the keeper uid a node with the given private ip
+// registers with, unless it was seeded from an existing keeperstate.
+func KeeperUIDFromIP(privateIP net.IP) string {
 	if privateIP == nil || privateIP.IsUnspecified() || privateIP.IsLoopback() {
 		return "local"
 	}
@@ -31,24 +40,77 @@ func keeperUID(privateIP net.IP) string {
 	return strings.Join(parts[4:], "")
 }
 
-func (n *Node) GetStolonClusterData() (s stolon.ClusterData, err error) {
-	cmd := exec.Command("stolonctl", "clusterdata", "read")
-	cmd.Env = append(os.Environ(),
-		"STOLONCTL_CLUSTER_NAME="+n.AppName,
-		"STOLONCTL_STORE_BACKEND="+n.BackendStore,
-		"STOLONCTL_STORE_URL="+n.BackendStoreURL.String(),
-		"STOLONCTL_STORE_NODE="+n.StoreNode,
-	)
+// RegionKeeperUIDs returns the keeper uids of the nodes running in a region.
+func (n *Node) RegionKeeperUIDs(ctx context.Context, region string) ([]string, error) {
+	addrs, err := privnet.RegionPeers(ctx, n.AppName, region)
+	if err != nil {
+		return nil, err
+	}
+
+	uids := make([]string, len(addrs))
+	for i, addr := range addrs {
+		uids[i] = KeeperUIDFromIP(addr.IP)
+	}
+
+	return uids, nil
+}
+
+// NewStolonClient returns a client for the cluster data stored in the
+// node's backend store.
+func (n *Node) NewStolonClient() (*stolon.Client, error) {
+	store, err := stolon.NewStore(n.BackendStore, n.BackendStoreURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return stolon.NewClient(store, stolon.StorePrefix(n.BackendStoreURL), n.AppName), nil
+}
+
+func (n *Node) GetStolonClusterData(ctx context.Context) (*stolon.ClusterData, error) {
+	client, err := n.NewStolonClient()
+	if err != nil {
+		return nil, err
+	}
+
+	cd, err := client.ClusterData(ctx)
+	if err != nil {
+		if errors.Is(err, stolon.ErrClusterNotInitialized) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error checking stolon status: %w", err)
+	}
+
+	return cd, nil
+}
 
-	output, err := cmd.CombinedOutput()
+// KeeperRegions maps the keeper uid of every node in the app to its region.
+func (n *Node) KeeperRegions(ctx context.Context) (map[string]string, error) {
+	regions, err := privnet.AllRegions(ctx, n.AppName)
 	if err != nil {
-		if strings.HasPrefix(string(output), "nil cluster data: ") {
-			return s, ErrClusterNotInitialized
+		return nil, err
+	}
+
+	keeperRegions := map[string]string{n.KeeperUID: n.Region}
+	for _, region := range regions {
+		uids, err := n.RegionKeeperUIDs(ctx, region)
+		if err != nil {
+			return nil, err
+		}
+		for _, uid := range uids {
+			keeperRegions[uid] = region
 		}
-		return s, errors.Wrap(err, "error checking stolon status: "+string(output))
 	}
 
-	err = json.Unmarshal(output, &s)
+	return keeperRegions, nil
+}
+
+// NewDBConnection opens a read-write connection to the postgres instance
+// managed by a stolon db.
+func (n *Node) NewDBConnection(ctx context.Context, db *stolon.DB) (*pgx.Conn, error) {
+	if db.Status.ListenAddress == "" {
+		return nil, fmt.Errorf("db %s has no listen address", db.UID)
+	}
 
-	return
+	host := net.JoinHostPort(db.Status.ListenAddress, db.Status.Port)
+	return openConnection(ctx, []string{host}, "read-write", n.SUCredentials)
 }
@@ -30,6 +30,28 @@ func (cd *ClusterData) FindDB(keeperUID string) *DB {
 	return nil
 }
 
+// MasterDB returns the db currently elected as master, if any.
+func (cd *ClusterData) MasterDB() *DB {
+	if cd.Cluster == nil {
+		return nil
+	}
+	return cd.DBs[cd.Cluster.Status.Master]
+}
+
+// MasterKeeperUID returns the uid of the keeper running the master db.
+func (cd *ClusterData) MasterKeeperUID() string {
+	if db := cd.MasterDB(); db != nil && db.Spec != nil {
+		return db.Spec.KeeperUID
+	}
+	return ""
+}
+
+// ApplicationName returns the application_name a standby db uses when
+// connecting to its master, as seen in pg_stat_replication.
+func ApplicationName(dbUID string) string {
+	return "stolon_" + dbUID
+}
+
 type Cluster struct {
 	UID        string    `json:"uid,omitempty"`
 	Generation int64     `json:"generation,omitempty"`
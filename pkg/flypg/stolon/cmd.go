@@ -1,7 +1,6 @@
 package stolon
 
 import (
-	"encoding/json"
 	"os/exec"
 )
 
@@ -18,17 +17,3 @@ func Failkeeper(currentMaster string, env []string) ([]byte, error) {
 
 	return Ctl([]string{cmd, args}, env)
 }
-
-func FetchClusterData(env []string) (*ClusterData, error) {
-	args := []string{"clusterdata", "read"}
-	result, err := Ctl(args, env)
-	if err != nil {
-		return nil, err
-	}
-	data := new(ClusterData)
-	if err := json.Unmarshal(result, data); err != nil {
-		return nil, err
-	}
-
-	return data, nil
-}
@@ -0,0 +1,224 @@
+package stolon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxStandbyLag is the MaxStandbyLag stolon uses when the cluster
+// spec does not set one.
+const DefaultMaxStandbyLag = 1024 * 1024
+
+// KeeperPlan reports how a keeper would be treated if the master failed.
+type KeeperPlan struct {
+	KeeperUID               string   `json:"keeper_uid"`
+	DBUID                   string   `json:"db_uid,omitempty"`
+	Region                  string   `json:"region,omitempty"`
+	Role                    string   `json:"role,omitempty"`
+	Healthy                 bool     `json:"healthy"`
+	DBHealthy               bool     `json:"db_healthy"`
+	ForceFail               bool     `json:"force_fail,omitempty"`
+	CanBeMaster             bool     `json:"can_be_master"`
+	CanBeSynchronousReplica *bool    `json:"can_be_synchronous_replica,omitempty"`
+	SynchronousStandby      bool     `json:"synchronous_standby,omitempty"`
+	TimelineID              uint64   `json:"timeline_id"`
+	XLogPos                 uint64   `json:"xlog_pos"`
+	XLogLag                 uint64   `json:"xlog_lag"`
+	ReplicationLag          *int64   `json:"replication_lag,omitempty"`
+	WithinMaxStandbyLag     bool     `json:"within_max_standby_lag"`
+	Eligible                bool     `json:"eligible"`
+	Exclusions              []string `json:"exclusions,omitempty"`
+}
+
+// FailoverPlan describes which keeper stolon would likely elect if the
+// current master failed, and why the others would be excluded.
+type FailoverPlan struct {
+	MasterKeeperUID        string       `json:"master_keeper_uid"`
+	MasterDBUID            string       `json:"master_db_uid"`
+	MaxStandbyLag          uint32       `json:"max_standby_lag"`
+	SynchronousReplication bool         `json:"synchronous_replication"`
+	Candidate              string       `json:"candidate,omitempty"`
+	Reason                 string       `json:"reason"`
+	Keepers                []KeeperPlan `json:"keepers"`
+}
+
+// PlanOptions holds information the cluster data does not contain.
+type PlanOptions struct {
+	// ReplicationLags in bytes as reported by pg_stat_replication, keyed by db uid.
+	ReplicationLags map[string]int64
+	// Regions keyed by keeper uid.
+	Regions map[string]string
+}
+
+// PlanFailover mirrors the checks the stolon sentinel runs when electing a
+// new master.
+func PlanFailover(cd *ClusterData, opts PlanOptions) *FailoverPlan {
+	plan := &FailoverPlan{
+		MasterKeeperUID: cd.MasterKeeperUID(),
+		MaxStandbyLag:   DefaultMaxStandbyLag,
+		Keepers:         []KeeperPlan{},
+	}
+
+	if spec := cd.clusterSpec(); spec != nil {
+		if spec.MaxStandbyLag != nil {
+			plan.MaxStandbyLag = *spec.MaxStandbyLag
+		}
+		if spec.SynchronousReplication != nil {
+			plan.SynchronousReplication = *spec.SynchronousReplication
+		}
+	}
+
+	master := cd.MasterDB()
+	if master != nil {
+		plan.MasterDBUID = master.UID
+	}
+
+	for _, uid := range keeperUIDs(cd.Keepers, nil) {
+		plan.Keepers = append(plan.Keepers, planKeeper(cd, plan, master, cd.Keepers[uid], opts))
+	}
+
+	var eligible []KeeperPlan
+	for _, k := range plan.Keepers {
+		if k.Eligible {
+			eligible = append(eligible, k)
+		}
+	}
+
+	switch {
+	case master == nil:
+		plan.Reason = "no master is currently elected"
+	case len(eligible) == 0:
+		plan.Reason = "no keeper is eligible for promotion"
+	default:
+		// The sentinel prefers the standby with the most recent xlog position.
+		sort.SliceStable(eligible, func(i, j int) bool {
+			return eligible[i].XLogPos > eligible[j].XLogPos
+		})
+		plan.Candidate = eligible[0].KeeperUID
+		plan.Reason = fmt.Sprintf("keeper %s is eligible and has the most recent xlog position", plan.Candidate)
+
+		var tied []string
+		for _, k := range eligible[1:] {
+			if k.XLogPos == eligible[0].XLogPos {
+				tied = append(tied, k.KeeperUID)
+			}
+		}
+		if len(tied) > 0 {
+			plan.Reason += fmt.Sprintf(", tied with %s", strings.Join(tied, ", "))
+		}
+	}
+
+	return plan
+}
+
+func planKeeper(cd *ClusterData, plan *FailoverPlan, master *DB, keeper *Keeper, opts PlanOptions) KeeperPlan {
+	kp := KeeperPlan{
+		KeeperUID:               keeper.UID,
+		Region:                  opts.Regions[keeper.UID],
+		Healthy:                 keeper.Status.Healthy,
+		ForceFail:               keeper.Status.ForceFail,
+		CanBeMaster:             keeper.Status.CanBeMaster,
+		CanBeSynchronousReplica: keeper.Status.CanBeSynchronousReplica,
+	}
+
+	exclude := func(format string, args ...interface{}) {
+		kp.Exclusions = append(kp.Exclusions, fmt.Sprintf(format, args...))
+	}
+
+	if keeper.UID == plan.MasterKeeperUID {
+		exclude("is the current master")
+	}
+	if !keeper.Status.Healthy {
+		exclude("keeper is unhealthy")
+	}
+	if keeper.Status.ForceFail {
+		exclude("keeper is being force failed")
+	}
+	if !keeper.Status.CanBeMaster {
+		exclude("keeper can't be master")
+	}
+
+	db := cd.FindDB(keeper.UID)
+	if db == nil {
+		exclude("keeper has no db assigned")
+		return kp
+	}
+
+	kp.DBUID = db.UID
+	kp.DBHealthy = db.Status.Healthy
+	kp.TimelineID = db.Status.TimelineID
+	kp.XLogPos = db.Status.XLogPos
+	if db.Spec != nil {
+		kp.Role = db.Spec.Role
+	}
+	if lag, ok := opts.ReplicationLags[db.UID]; ok {
+		kp.ReplicationLag = &lag
+	}
+
+	if !db.Status.Healthy {
+		exclude("db is unhealthy")
+	}
+	if db.Status.CurrentGeneration != db.Generation {
+		exclude("db has not converged to generation %d (currently %d)", db.Generation, db.Status.CurrentGeneration)
+	}
+
+	if master != nil && db.UID != master.UID {
+		if db.Status.TimelineID != master.Status.TimelineID {
+			exclude("db timeline %d differs from master timeline %d", db.Status.TimelineID, master.Status.TimelineID)
+		}
+
+		if master.Status.XLogPos > db.Status.XLogPos {
+			kp.XLogLag = master.Status.XLogPos - db.Status.XLogPos
+		}
+		kp.WithinMaxStandbyLag = kp.XLogLag <= uint64(plan.MaxStandbyLag)
+		if !kp.WithinMaxStandbyLag {
+			exclude("db lags %d bytes behind the master, max standby lag is %d", kp.XLogLag, plan.MaxStandbyLag)
+		}
+
+		if plan.SynchronousReplication {
+			for _, uid := range master.Status.SynchronousStandbys {
+				if uid == db.UID {
+					kp.SynchronousStandby = true
+				}
+			}
+			if !kp.SynchronousStandby {
+				exclude("db is not a synchronous standby")
+			}
+		}
+	}
+
+	kp.Eligible = master != nil && len(kp.Exclusions) == 0
+
+	return kp
+}
+
+// Keeper returns the plan for a single keeper.
+func (p *FailoverPlan) Keeper(uid string) *KeeperPlan {
+	for i := range p.Keepers {
+		if p.Keepers[i].KeeperUID == uid {
+			return &p.Keepers[i]
+		}
+	}
+	return nil
+}
+
+// Err explains why no keeper can be promoted, or returns nil if one can.
+func (p *FailoverPlan) Err() error {
+	if p.Candidate != "" {
+		return nil
+	}
+
+	var reasons []string
+	for _, k := range p.Keepers {
+		if k.KeeperUID == p.MasterKeeperUID {
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("keeper %s: %s", k.KeeperUID, strings.Join(k.Exclusions, ", ")))
+	}
+
+	if len(reasons) == 0 {
+		return fmt.Errorf("no eligible keepers available to accommodate failover: %s", p.Reason)
+	}
+	return fmt.Errorf("no eligible keepers available to accommodate failover: %s", strings.Join(reasons, "; "))
+}
@@ -0,0 +1,165 @@
+package stolon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPlanClusterData() *ClusterData {
+	cd := testClusterData()
+	cd.Keepers["k1"].Status.CanBeMaster = true
+	cd.Keepers["k2"].Status.CanBeMaster = true
+	cd.Keepers["k3"] = &Keeper{UID: "k3", Status: KeeperStatus{Healthy: true, CanBeMaster: true}}
+	cd.DBs["db3"] = &DB{UID: "db3", Generation: 1, Spec: &DBSpec{KeeperUID: "k3", Role: "standby"}, Status: DBStatus{Healthy: true}}
+
+	for _, db := range cd.DBs {
+		db.Status.CurrentGeneration = db.Generation
+		db.Status.TimelineID = 1
+		db.Status.XLogPos = 1000
+	}
+	cd.DBs["db3"].Status.XLogPos = 900
+
+	return cd
+}
+
+func TestPlanFailover(t *testing.T) {
+	cases := map[string]struct {
+		change    func(cd *ClusterData)
+		candidate string
+		excluded  map[string]string
+	}{
+		"most recent standby wins": {
+			change:    func(cd *ClusterData) {},
+			candidate: "k2",
+		},
+		"unhealthy keeper": {
+			change: func(cd *ClusterData) {
+				cd.Keepers["k2"].Status.Healthy = false
+			},
+			candidate: "k3",
+			excluded:  map[string]string{"k2": "keeper is unhealthy"},
+		},
+		"force failed keeper": {
+			change: func(cd *ClusterData) {
+				cd.Keepers["k2"].Status.ForceFail = true
+			},
+			candidate: "k3",
+			excluded:  map[string]string{"k2": "keeper is being force failed"},
+		},
+		"keeper can't be master": {
+			change: func(cd *ClusterData) {
+				cd.Keepers["k2"].Status.CanBeMaster = false
+			},
+			candidate: "k3",
+			excluded:  map[string]string{"k2": "keeper can't be master"},
+		},
+		"unhealthy db": {
+			change: func(cd *ClusterData) {
+				cd.DBs["db2"].Status.Healthy = false
+			},
+			candidate: "k3",
+			excluded:  map[string]string{"k2": "db is unhealthy"},
+		},
+		"db not converged": {
+			change: func(cd *ClusterData) {
+				cd.DBs["db2"].Generation = 2
+			},
+			candidate: "k3",
+			excluded:  map[string]string{"k2": "db has not converged to generation 2 (currently 1)"},
+		},
+		"timeline mismatch": {
+			change: func(cd *ClusterData) {
+				cd.DBs["db2"].Status.TimelineID = 2
+			},
+			candidate: "k3",
+			excluded:  map[string]string{"k2": "db timeline 2 differs from master timeline 1"},
+		},
+		"lag exceeds max standby lag": {
+			change: func(cd *ClusterData) {
+				lag := uint32(50)
+				cd.Cluster.Spec.MaxStandbyLag = &lag
+			},
+			candidate: "k2",
+			excluded:  map[string]string{"k3": "db lags 100 bytes behind the master, max standby lag is 50"},
+		},
+		"synchronous replication": {
+			change: func(cd *ClusterData) {
+				sync := true
+				cd.Cluster.Spec.SynchronousReplication = &sync
+				cd.DBs["db1"].Status.SynchronousStandbys = []string{"db3"}
+			},
+			candidate: "k3",
+			excluded:  map[string]string{"k2": "db is not a synchronous standby"},
+		},
+		"no master": {
+			change: func(cd *ClusterData) {
+				cd.Cluster.Status.Master = ""
+			},
+		},
+		"no eligible keepers": {
+			change: func(cd *ClusterData) {
+				cd.Keepers["k2"].Status.Healthy = false
+				cd.Keepers["k3"].Status.CanBeMaster = false
+			},
+		},
+	}
+
+	for name, c := range cases {
+		cd := testPlanClusterData()
+		c.change(cd)
+
+		plan := PlanFailover(cd, PlanOptions{})
+		assert.Equal(t, c.candidate, plan.Candidate, name)
+
+		if c.candidate == "" {
+			assert.Error(t, plan.Err(), name)
+		} else {
+			assert.NoError(t, plan.Err(), name)
+		}
+
+		for uid, exclusion := range c.excluded {
+			kp := plan.Keeper(uid)
+			require.NotNil(t, kp, name)
+			assert.False(t, kp.Eligible, name)
+			assert.Contains(t, kp.Exclusions, exclusion, name)
+		}
+	}
+}
+
+func TestPlanFailoverMasterExcluded(t *testing.T) {
+	plan := PlanFailover(testPlanClusterData(), PlanOptions{})
+
+	master := plan.Keeper("k1")
+	require.NotNil(t, master)
+	assert.False(t, master.Eligible)
+	assert.Equal(t, []string{"is the current master"}, master.Exclusions)
+	assert.Equal(t, "k1", plan.MasterKeeperUID)
+	assert.Equal(t, "db1", plan.MasterDBUID)
+}
+
+func TestPlanFailoverOptions(t *testing.T) {
+	opts := PlanOptions{
+		ReplicationLags: map[string]int64{"db2": 0, "db3": 100},
+		Regions:         map[string]string{"k1": "ord", "k2": "ord", "k3": "syd"},
+	}
+	plan := PlanFailover(testPlanClusterData(), opts)
+
+	k3 := plan.Keeper("k3")
+	require.NotNil(t, k3)
+	assert.Equal(t, "syd", k3.Region)
+	require.NotNil(t, k3.ReplicationLag)
+	assert.Equal(t, int64(100), *k3.ReplicationLag)
+	assert.Equal(t, uint64(100), k3.XLogLag)
+	assert.True(t, k3.WithinMaxStandbyLag)
+}
+
+func TestPlanFailoverTie(t *testing.T) {
+	cd := testPlanClusterData()
+	cd.DBs["db3"].Status.XLogPos = 1000
+
+	plan := PlanFailover(cd, PlanOptions{})
+	assert.Equal(t, "k2", plan.Candidate)
+	assert.Contains(t, plan.Reason, "tied with k3")
+}
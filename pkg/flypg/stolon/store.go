@@ -0,0 +1,162 @@
+package stolon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	BackendConsul = "consul"
+	BackendEtcdV3 = "etcdv3"
+
+	// DefaultStorePrefix matches the default --store-prefix used by the stolon
+	// components.
+	DefaultStorePrefix = "stolon/cluster"
+)
+
+var (
+	ErrClusterNotInitialized = errors.New("cluster not initialized")
+	ErrStoreUnreachable      = errors.New("store unreachable")
+	ErrKeyNotFound           = errors.New("key not found")
+	ErrKeyModified           = errors.New("key was modified")
+)
+
+// KVPair is a single value read from the backend store along with the
+// revision it was last modified at.
+type KVPair struct {
+	Key      string
+	Value    []byte
+	Revision uint64
+}
+
+// Store is the minimal key/value interface needed to read and write the
+// stolon cluster data.
+type Store interface {
+	// Get returns ErrKeyNotFound if the key does not exist.
+	Get(ctx context.Context, key string) (*KVPair, error)
+	Put(ctx context.Context, key string, value []byte) error
+	// AtomicPut only writes the value if the key was not modified since
+	// previous was read. A nil previous means the key must not exist yet.
+	// It returns ErrKeyModified if the check fails.
+	AtomicPut(ctx context.Context, key string, value []byte, previous *KVPair) error
+	// List returns every key starting with prefix, or none if there are
+	// no such keys.
+	List(ctx context.Context, prefix string) ([]*KVPair, error)
+}
+
+// NewStore returns a Store for the given backend. Credentials embedded in
+// the endpoint url are used to authenticate against the backend.
+func NewStore(backend string, endpoint *url.URL) (Store, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	switch backend {
+	case BackendConsul:
+		return newConsulStore(client, endpoint), nil
+	case BackendEtcdV3:
+		return newEtcdStore(client, endpoint), nil
+	default:
+		return nil, fmt.Errorf("backend store %q is not supported", backend)
+	}
+}
+
+// StorePrefix returns the key prefix stolon uses for the given store url.
+// Any path on the url namespaces the keys within the store.
+func StorePrefix(endpoint *url.URL) string {
+	return path.Join(strings.Trim(endpoint.Path, "/"), DefaultStorePrefix)
+}
+
+// Client reads and writes the cluster data of a single stolon cluster.
+type Client struct {
+	store       Store
+	prefix      string
+	clusterName string
+}
+
+func NewClient(store Store, prefix string, clusterName string) *Client {
+	return &Client{
+		store:       store,
+		prefix:      prefix,
+		clusterName: clusterName,
+	}
+}
+
+func (c *Client) Store() Store {
+	return c.store
+}
+
+// Key returns the full store key for a key relative to the cluster path.
+func (c *Client) Key(name string) string {
+	return path.Join(c.prefix, c.clusterName, name)
+}
+
+// ClusterData returns the current cluster data. ErrClusterNotInitialized is
+// returned when no sentinel has written it yet.
+func (c *Client) ClusterData(ctx context.Context) (*ClusterData, error) {
+	cd, _, err := c.readClusterData(ctx)
+	return cd, err
+}
+
+// Sentinels returns the uids of the sentinels that are running. Sentinels
+// publish their info with a ttl, so stopped ones drop out.
+func (c *Client) Sentinels(ctx context.Context) ([]string, error) {
+	prefix := c.Key("sentinels/info") + "/"
+
+	pairs, err := c.store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	uids := []string{}
+	for _, pair := range pairs {
+		if uid := strings.TrimPrefix(pair.Key, prefix); uid != "" {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+
+	return uids, nil
+}
+
+func (c *Client) readClusterData(ctx context.Context) (*ClusterData, *KVPair, error) {
+	pair, err := c.store.Get(ctx, c.Key("clusterdata"))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, nil, ErrClusterNotInitialized
+		}
+		return nil, nil, err
+	}
+
+	var cd *ClusterData
+	if err := json.Unmarshal(pair.Value, &cd); err != nil {
+		return nil, nil, fmt.Errorf("error decoding cluster data: %w", err)
+	}
+
+	// The sentinel may have written an empty cluster data.
+	if cd == nil {
+		return nil, nil, ErrClusterNotInitialized
+	}
+
+	return cd, pair, nil
+}
+
+func unreachable(err error) error {
+	return fmt.Errorf("%w: %s", ErrStoreUnreachable, err)
+}
+
+func checkResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode >= 500:
+		return unreachable(fmt.Errorf("unexpected status code: %d", resp.StatusCode))
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
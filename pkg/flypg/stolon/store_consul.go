@@ -0,0 +1,160 @@
+package stolon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type consulStore struct {
+	client *http.Client
+	addr   string
+	token  string
+}
+
+// newConsulStore talks to the Consul KV HTTP API. The ACL token is taken
+// from the password of the endpoint url, i.e. https://:token@host/prefix.
+func newConsulStore(client *http.Client, endpoint *url.URL) *consulStore {
+	s := &consulStore{
+		client: client,
+		addr:   endpoint.Scheme + "://" + endpoint.Host,
+	}
+
+	if endpoint.User != nil {
+		if token, ok := endpoint.User.Password(); ok {
+			s.token = token
+		} else {
+			s.token = endpoint.User.Username()
+		}
+	}
+
+	return s
+}
+
+type consulKV struct {
+	Key         string
+	Value       []byte
+	ModifyIndex uint64
+}
+
+func (s *consulStore) Get(ctx context.Context, key string) (*KVPair, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var kvs []consulKV
+	if err := json.NewDecoder(resp.Body).Decode(&kvs); err != nil {
+		return nil, err
+	}
+	if len(kvs) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	return &KVPair{Key: key, Value: kvs[0].Value, Revision: kvs[0].ModifyIndex}, nil
+}
+
+func (s *consulStore) List(ctx context.Context, prefix string) ([]*KVPair, error) {
+	resp, err := s.do(ctx, http.MethodGet, prefix, url.Values{"recurse": {"true"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return []*KVPair{}, nil
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var kvs []consulKV
+	if err := json.NewDecoder(resp.Body).Decode(&kvs); err != nil {
+		return nil, err
+	}
+
+	pairs := make([]*KVPair, 0, len(kvs))
+	for _, kv := range kvs {
+		pairs = append(pairs, &KVPair{Key: kv.Key, Value: kv.Value, Revision: kv.ModifyIndex})
+	}
+
+	return pairs, nil
+}
+
+func (s *consulStore) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.put(ctx, key, value, nil)
+	return err
+}
+
+func (s *consulStore) AtomicPut(ctx context.Context, key string, value []byte, previous *KVPair) error {
+	// A cas index of 0 only succeeds if the key does not exist.
+	index := uint64(0)
+	if previous != nil {
+		index = previous.Revision
+	}
+
+	ok, err := s.put(ctx, key, value, url.Values{"cas": {strconv.FormatUint(index, 10)}})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrKeyModified
+	}
+
+	return nil
+}
+
+func (s *consulStore) put(ctx context.Context, key string, value []byte, params url.Values) (bool, error) {
+	resp, err := s.do(ctx, http.MethodPut, key, params, value)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return false, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(string(body)) == "true", nil
+}
+
+func (s *consulStore) do(ctx context.Context, method string, key string, params url.Values, body []byte) (*http.Response, error) {
+	u := s.addr + "/v1/kv/" + strings.TrimPrefix(key, "/")
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, unreachable(err)
+	}
+
+	return resp, nil
+}
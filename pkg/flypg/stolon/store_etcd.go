@@ -0,0 +1,271 @@
+package stolon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+type etcdStore struct {
+	client   *http.Client
+	addr     string
+	username string
+	password string
+
+	mu    sync.Mutex
+	token string
+}
+
+// newEtcdStore talks to the etcd v3 JSON gateway. Basic auth credentials on
+// the endpoint url are exchanged for an auth token on first use.
+func newEtcdStore(client *http.Client, endpoint *url.URL) *etcdStore {
+	s := &etcdStore{
+		client: client,
+		addr:   endpoint.Scheme + "://" + endpoint.Host,
+	}
+
+	if endpoint.User != nil {
+		s.username = endpoint.User.Username()
+		s.password, _ = endpoint.User.Password()
+	}
+
+	return s
+}
+
+type etcdKV struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKV `json:"kvs"`
+}
+
+type etcdCompare struct {
+	Key            []byte `json:"key"`
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	ModRevision    string `json:"mod_revision,omitempty"`
+	CreateRevision string `json:"create_revision,omitempty"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare            `json:"compare"`
+	Success []map[string]interface{} `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+func (s *etcdStore) Get(ctx context.Context, key string) (*KVPair, error) {
+	var out etcdRangeResponse
+	if err := s.call(ctx, "/v3/kv/range", map[string]interface{}{"key": []byte(key)}, &out); err != nil {
+		return nil, err
+	}
+
+	if len(out.Kvs) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	revision, err := parseRevision(out.Kvs[0].ModRevision)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KVPair{Key: key, Value: out.Kvs[0].Value, Revision: revision}, nil
+}
+
+func (s *etcdStore) List(ctx context.Context, prefix string) ([]*KVPair, error) {
+	in := map[string]interface{}{"key": []byte(prefix), "range_end": prefixEnd(prefix)}
+
+	var out etcdRangeResponse
+	if err := s.call(ctx, "/v3/kv/range", in, &out); err != nil {
+		return nil, err
+	}
+
+	pairs := make([]*KVPair, 0, len(out.Kvs))
+	for _, kv := range out.Kvs {
+		revision, err := parseRevision(kv.ModRevision)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, &KVPair{Key: string(kv.Key), Value: kv.Value, Revision: revision})
+	}
+
+	return pairs, nil
+}
+
+// prefixEnd is the range_end that makes a range request match every key
+// starting with prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// every byte is 0xff, range to the end of the keyspace
+	return []byte{0}
+}
+
+func (s *etcdStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.call(ctx, "/v3/kv/put", etcdPutRequest{Key: []byte(key), Value: value}, nil)
+}
+
+func (s *etcdStore) AtomicPut(ctx context.Context, key string, value []byte, previous *KVPair) error {
+	// A create revision of 0 only matches keys that do not exist.
+	cmp := etcdCompare{Key: []byte(key), Target: "CREATE", Result: "EQUAL", CreateRevision: "0"}
+	if previous != nil {
+		cmp = etcdCompare{
+			Key:         []byte(key),
+			Target:      "MOD",
+			Result:      "EQUAL",
+			ModRevision: strconv.FormatUint(previous.Revision, 10),
+		}
+	}
+
+	in := etcdTxnRequest{
+		Compare: []etcdCompare{cmp},
+		Success: []map[string]interface{}{
+			{"request_put": etcdPutRequest{Key: []byte(key), Value: value}},
+		},
+	}
+
+	var out etcdTxnResponse
+	if err := s.call(ctx, "/v3/kv/txn", in, &out); err != nil {
+		return err
+	}
+	if !out.Succeeded {
+		return ErrKeyModified
+	}
+
+	return nil
+}
+
+func (s *etcdStore) call(ctx context.Context, endpoint string, in interface{}, out interface{}) error {
+	resp, err := s.authorizedPost(ctx, endpoint, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// authorizedPost posts with the current auth token. Tokens expire, so a
+// request rejected as unauthorized is retried once with a new token.
+func (s *etcdStore) authorizedPost(ctx context.Context, endpoint string, in interface{}) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := s.authToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.post(ctx, endpoint, token, in)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || token == "" {
+			return resp, nil
+		}
+
+		s.mu.Lock()
+		if s.token == token {
+			s.token = ""
+		}
+		s.mu.Unlock()
+
+		if attempt > 0 {
+			return resp, nil
+		}
+		resp.Body.Close()
+	}
+}
+
+func (s *etcdStore) authToken(ctx context.Context) (string, error) {
+	if s.username == "" {
+		return "", nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" {
+		return s.token, nil
+	}
+
+	in := map[string]string{"name": s.username, "password": s.password}
+	resp, err := s.post(ctx, "/v3/auth/authenticate", "", in)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("error authenticating with etcd: %w", err)
+	}
+
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	s.token = out.Token
+
+	return s.token, nil
+}
+
+func (s *etcdStore) post(ctx context.Context, endpoint string, token string, in interface{}) (*http.Response, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.addr+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, unreachable(err)
+	}
+
+	return resp, nil
+}
+
+// The JSON gateway encodes 64 bit integers as strings and omits zero values.
+func parseRevision(raw string) (uint64, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(raw, 10, 64)
+}
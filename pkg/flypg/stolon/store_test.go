@@ -0,0 +1,353 @@
+package stolon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKV is an in-process key/value store that speaks just enough of the
+// Consul and etcd v3 HTTP APIs to exercise the store clients.
+type fakeKV struct {
+	mu       sync.Mutex
+	revision uint64
+	values   map[string]fakeEntry
+	down     bool
+}
+
+type fakeEntry struct {
+	value    []byte
+	created  uint64
+	modified uint64
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{values: map[string]fakeEntry{}}
+}
+
+func (f *fakeKV) set(key string, value []byte) uint64 {
+	f.revision++
+	entry, ok := f.values[key]
+	if !ok {
+		entry.created = f.revision
+	}
+	entry.value = value
+	entry.modified = f.revision
+	f.values[key] = entry
+	return f.revision
+}
+
+// keys returns the sorted keys starting with prefix, or in [prefix, end)
+// if end is set.
+func (f *fakeKV) keys(prefix, end string) []string {
+	var keys []string
+	for k := range f.values {
+		if end != "" && k >= prefix && k < end || end == "" && strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeKV) consul() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("recurse") != "" {
+				var kvs []consulKV
+				for _, k := range f.keys(key, "") {
+					kvs = append(kvs, consulKV{Key: k, Value: f.values[k].value, ModifyIndex: f.values[k].modified})
+				}
+				if len(kvs) == 0 {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				json.NewEncoder(w).Encode(kvs)
+				return
+			}
+
+			entry, ok := f.values[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode([]consulKV{{Key: key, Value: entry.value, ModifyIndex: entry.modified}})
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			if cas := r.URL.Query().Get("cas"); cas != "" {
+				index, _ := strconv.ParseUint(cas, 10, 64)
+				if f.values[key].modified != index {
+					io.WriteString(w, "false")
+					return
+				}
+			}
+			f.set(key, body)
+			io.WriteString(w, "true")
+		}
+	})
+}
+
+func (f *fakeKV) etcd() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.URL.Path == "/v3/auth/authenticate" {
+			json.NewEncoder(w).Encode(map[string]string{"token": "tok"})
+			return
+		}
+		if r.Header.Get("Authorization") != "tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			var in struct {
+				Key      []byte `json:"key"`
+				RangeEnd []byte `json:"range_end"`
+			}
+			json.NewDecoder(r.Body).Decode(&in)
+
+			out := etcdRangeResponse{}
+			if len(in.RangeEnd) > 0 {
+				for _, k := range f.keys(string(in.Key), string(in.RangeEnd)) {
+					out.Kvs = append(out.Kvs, etcdKV{
+						Key:         []byte(k),
+						Value:       f.values[k].value,
+						ModRevision: strconv.FormatUint(f.values[k].modified, 10),
+					})
+				}
+			} else if entry, ok := f.values[string(in.Key)]; ok {
+				out.Kvs = append(out.Kvs, etcdKV{
+					Key:         in.Key,
+					Value:       entry.value,
+					ModRevision: strconv.FormatUint(entry.modified, 10),
+				})
+			}
+			json.NewEncoder(w).Encode(out)
+		case "/v3/kv/put":
+			var in etcdPutRequest
+			json.NewDecoder(r.Body).Decode(&in)
+			f.set(string(in.Key), in.Value)
+			io.WriteString(w, "{}")
+		case "/v3/kv/txn":
+			var in struct {
+				Compare []etcdCompare `json:"compare"`
+				Success []struct {
+					RequestPut etcdPutRequest `json:"request_put"`
+				} `json:"success"`
+			}
+			json.NewDecoder(r.Body).Decode(&in)
+
+			cmp := in.Compare[0]
+			entry := f.values[string(cmp.Key)]
+			expected, actual := cmp.ModRevision, entry.modified
+			if cmp.Target == "CREATE" {
+				expected, actual = cmp.CreateRevision, entry.created
+			}
+			if expected != strconv.FormatUint(actual, 10) {
+				io.WriteString(w, "{}")
+				return
+			}
+
+			put := in.Success[0].RequestPut
+			f.set(string(put.Key), put.Value)
+			json.NewEncoder(w).Encode(etcdTxnResponse{Succeeded: true})
+		}
+	})
+}
+
+func newTestStores(t *testing.T, kv *fakeKV) map[string]Store {
+	stores := map[string]Store{}
+
+	for backend, handler := range map[string]http.Handler{
+		BackendConsul: kv.consul(),
+		BackendEtcdV3: kv.etcd(),
+	} {
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+
+		u, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		if backend == BackendConsul {
+			u.User = url.UserPassword("", "secret")
+		} else {
+			u.User = url.UserPassword("root", "password")
+		}
+
+		store, err := NewStore(backend, u)
+		require.NoError(t, err)
+		stores[backend] = store
+	}
+
+	return stores
+}
+
+func TestStorePrefix(t *testing.T) {
+	u, _ := url.Parse("https://:token@consul.example.com/my-app-x8y7/")
+	assert.Equal(t, "my-app-x8y7/stolon/cluster", StorePrefix(u))
+
+	u, _ = url.Parse("http://etcd:2379")
+	assert.Equal(t, "stolon/cluster", StorePrefix(u))
+}
+
+func TestClusterDataNotInitialized(t *testing.T) {
+	for backend, store := range newTestStores(t, newFakeKV()) {
+		client := NewClient(store, DefaultStorePrefix, "app")
+
+		_, err := client.ClusterData(context.TODO())
+		assert.True(t, errors.Is(err, ErrClusterNotInitialized), backend)
+	}
+
+	kv := newFakeKV()
+	kv.set("stolon/cluster/app/clusterdata", []byte("null"))
+	for backend, store := range newTestStores(t, kv) {
+		client := NewClient(store, DefaultStorePrefix, "app")
+
+		_, err := client.ClusterData(context.TODO())
+		assert.True(t, errors.Is(err, ErrClusterNotInitialized), backend)
+	}
+}
+
+func TestClusterDataUnreachable(t *testing.T) {
+	kv := newFakeKV()
+	kv.down = true
+
+	for backend, store := range newTestStores(t, kv) {
+		client := NewClient(store, DefaultStorePrefix, "app")
+
+		_, err := client.ClusterData(context.TODO())
+		assert.True(t, errors.Is(err, ErrStoreUnreachable), backend)
+		assert.False(t, errors.Is(err, ErrClusterNotInitialized), backend)
+	}
+
+	// Nothing listening at all.
+	u, _ := url.Parse("http://127.0.0.1:1")
+	store, err := NewStore(BackendConsul, u)
+	require.NoError(t, err)
+
+	_, err = NewClient(store, DefaultStorePrefix, "app").ClusterData(context.TODO())
+	assert.True(t, errors.Is(err, ErrStoreUnreachable))
+}
+
+func TestClusterDataReadWrite(t *testing.T) {
+	for backend, store := range newTestStores(t, newFakeKV()) {
+		client := NewClient(store, DefaultStorePrefix, backend)
+
+		cd := &ClusterData{
+			FormatVersion: 1,
+			Cluster:       &Cluster{UID: "c1", Generation: 1, Status: ClusterStatus{Master: "db1"}},
+			Keepers:       Keepers{"k1": {UID: "k1", Status: KeeperStatus{Healthy: true}}},
+			DBs:           DBs{"db1": {UID: "db1", Spec: &DBSpec{KeeperUID: "k1", Role: "master"}}},
+		}
+		data, err := json.Marshal(cd)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(context.TODO(), client.Key("clusterdata"), data), backend)
+
+		read, err := client.ClusterData(context.TODO())
+		require.NoError(t, err, backend)
+		assert.Equal(t, "db1", read.Cluster.Status.Master, backend)
+		assert.Equal(t, "k1", read.FindDB("k1").Spec.KeeperUID, backend)
+	}
+}
+
+func TestAtomicPut(t *testing.T) {
+	for backend, store := range newTestStores(t, newFakeKV()) {
+		ctx := context.TODO()
+		key := backend + "/key"
+
+		require.NoError(t, store.AtomicPut(ctx, key, []byte("a"), nil), backend)
+		assert.True(t, errors.Is(store.AtomicPut(ctx, key, []byte("b"), nil), ErrKeyModified), backend)
+
+		pair, err := store.Get(ctx, key)
+		require.NoError(t, err, backend)
+		assert.Equal(t, "a", string(pair.Value), backend)
+
+		require.NoError(t, store.Put(ctx, key, []byte("c")), backend)
+		assert.True(t, errors.Is(store.AtomicPut(ctx, key, []byte("d"), pair), ErrKeyModified), backend)
+
+		pair, err = store.Get(ctx, key)
+		require.NoError(t, err, backend)
+		require.NoError(t, store.AtomicPut(ctx, key, []byte("e"), pair), backend)
+
+		_, err = store.Get(ctx, backend+"/missing")
+		assert.True(t, errors.Is(err, ErrKeyNotFound), backend)
+	}
+}
+
+func TestEtcdExpiredToken(t *testing.T) {
+	kv := newFakeKV()
+	kv.set("key", []byte("value"))
+
+	store := newTestStores(t, kv)[BackendEtcdV3].(*etcdStore)
+	store.token = "expired"
+
+	pair, err := store.Get(context.TODO(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(pair.Value))
+	assert.Equal(t, "tok", store.token)
+}
+
+func TestSentinels(t *testing.T) {
+	kv := newFakeKV()
+	for backend, store := range newTestStores(t, kv) {
+		client := NewClient(store, DefaultStorePrefix, "app")
+
+		uids, err := client.Sentinels(context.TODO())
+		require.NoError(t, err, backend)
+		assert.Empty(t, uids, backend)
+	}
+
+	kv.set("stolon/cluster/app/sentinels/info/b2", []byte(`{"UID":"b2"}`))
+	kv.set("stolon/cluster/app/sentinels/info/a1", []byte(`{"UID":"a1"}`))
+	// another cluster, and a key sorting right after the prefix
+	kv.set("stolon/cluster/other/sentinels/info/c3", []byte(`{"UID":"c3"}`))
+	kv.set("stolon/cluster/app/sentinels/info0", []byte(`{}`))
+
+	for backend, store := range newTestStores(t, kv) {
+		client := NewClient(store, DefaultStorePrefix, "app")
+
+		uids, err := client.Sentinels(context.TODO())
+		require.NoError(t, err, backend)
+		assert.Equal(t, []string{"a1", "b2"}, uids, backend)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("abd"), prefixEnd("abc"))
+	assert.Equal(t, []byte("b"), prefixEnd("a\xff"))
+	assert.Equal(t, []byte{0}, prefixEnd("\xff\xff"))
+}
@@ -0,0 +1,245 @@
+package stolon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventMasterChanged       EventType = "master_changed"
+	EventPhaseChanged        EventType = "phase_changed"
+	EventSpecChanged         EventType = "spec_changed"
+	EventKeeperAdded         EventType = "keeper_added"
+	EventKeeperRemoved       EventType = "keeper_removed"
+	EventKeeperHealthy       EventType = "keeper_healthy"
+	EventKeeperUnhealthy     EventType = "keeper_unhealthy"
+	EventDBHealthy           EventType = "db_healthy"
+	EventDBUnhealthy         EventType = "db_unhealthy"
+	EventDBGenerationChanged EventType = "db_generation_changed"
+	EventStoreError          EventType = "store_error"
+)
+
+// Event describes a single change between two versions of the cluster data.
+type Event struct {
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"`
+	KeeperUID string    `json:"keeper_uid,omitempty"`
+	DBUID     string    `json:"db_uid,omitempty"`
+	Previous  string    `json:"previous,omitempty"`
+	Current   string    `json:"current,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// Diff returns the events needed to go from prev to cur.
+func Diff(prev, cur *ClusterData) []Event {
+	if prev == nil || cur == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var events []Event
+
+	if p, c := prev.MasterKeeperUID(), cur.MasterKeeperUID(); p != c {
+		events = append(events, Event{
+			Type:      EventMasterChanged,
+			KeeperUID: c,
+			DBUID:     cur.clusterStatus().Master,
+			Previous:  p,
+			Current:   c,
+		})
+	}
+
+	if p, c := prev.clusterStatus().Phase, cur.clusterStatus().Phase; p != c {
+		events = append(events, Event{Type: EventPhaseChanged, Previous: string(p), Current: string(c)})
+	}
+
+	if !reflect.DeepEqual(prev.clusterSpec(), cur.clusterSpec()) {
+		events = append(events, Event{
+			Type:     EventSpecChanged,
+			Previous: strconv.FormatInt(prev.clusterGeneration(), 10),
+			Current:  strconv.FormatInt(cur.clusterGeneration(), 10),
+		})
+	}
+
+	for _, uid := range keeperUIDs(prev.Keepers, cur.Keepers) {
+		p, c := prev.Keepers[uid], cur.Keepers[uid]
+		switch {
+		case p == nil:
+			events = append(events, Event{Type: EventKeeperAdded, KeeperUID: uid})
+		case c == nil:
+			events = append(events, Event{Type: EventKeeperRemoved, KeeperUID: uid})
+		case p.Status.Healthy && !c.Status.Healthy:
+			events = append(events, Event{Type: EventKeeperUnhealthy, KeeperUID: uid})
+		case !p.Status.Healthy && c.Status.Healthy:
+			events = append(events, Event{Type: EventKeeperHealthy, KeeperUID: uid})
+		}
+	}
+
+	for _, uid := range dbUIDs(prev.DBs, cur.DBs) {
+		p, c := prev.DBs[uid], cur.DBs[uid]
+		if p == nil || c == nil {
+			continue
+		}
+
+		keeperUID := ""
+		if c.Spec != nil {
+			keeperUID = c.Spec.KeeperUID
+		}
+
+		switch {
+		case p.Status.Healthy && !c.Status.Healthy:
+			events = append(events, Event{Type: EventDBUnhealthy, DBUID: uid, KeeperUID: keeperUID})
+		case !p.Status.Healthy && c.Status.Healthy:
+			events = append(events, Event{Type: EventDBHealthy, DBUID: uid, KeeperUID: keeperUID})
+		}
+
+		if p.Generation != c.Generation {
+			events = append(events, Event{
+				Type:      EventDBGenerationChanged,
+				DBUID:     uid,
+				KeeperUID: keeperUID,
+				Previous:  strconv.FormatInt(p.Generation, 10),
+				Current:   strconv.FormatInt(c.Generation, 10),
+			})
+		}
+	}
+
+	for i := range events {
+		events[i].Time = now
+	}
+
+	return events
+}
+
+// Watcher polls the cluster data and emits an event for every change.
+type Watcher struct {
+	client   *Client
+	interval time.Duration
+}
+
+func NewWatcher(client *Client, interval time.Duration) *Watcher {
+	return &Watcher{client: client, interval: interval}
+}
+
+// Watch emits events until ctx is done, starting from the cluster data as
+// read on the first poll.
+func (w *Watcher) Watch(ctx context.Context) <-chan Event {
+	return w.WatchFrom(ctx, nil)
+}
+
+// WatchFrom emits events relative to a previously read cluster data, so no
+// change is missed between reading it and starting the watch.
+func (w *Watcher) WatchFrom(ctx context.Context, from *ClusterData) <-chan Event {
+	events := make(chan Event)
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		prev := from
+		revision := uint64(0)
+		failing := false
+
+		for {
+			cd, pair, err := w.client.readClusterData(ctx)
+			switch {
+			case err == nil:
+				failing = false
+				if prev != nil && pair.Revision == revision {
+					break
+				}
+				for _, event := range Diff(prev, cd) {
+					if !send(ctx, events, event) {
+						return
+					}
+				}
+				prev, revision = cd, pair.Revision
+			case errors.Is(err, ErrClusterNotInitialized), ctx.Err() != nil:
+			case !failing:
+				// Only report the first error of a streak.
+				failing = true
+				if !send(ctx, events, Event{Type: EventStoreError, Time: time.Now(), Message: err.Error()}) {
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return events
+}
+
+func send(ctx context.Context, events chan<- Event, event Event) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (cd *ClusterData) clusterStatus() ClusterStatus {
+	if cd.Cluster == nil {
+		return ClusterStatus{}
+	}
+	return cd.Cluster.Status
+}
+
+func (cd *ClusterData) clusterSpec() *ClusterSpec {
+	if cd.Cluster == nil {
+		return nil
+	}
+	return cd.Cluster.Spec
+}
+
+func (cd *ClusterData) clusterGeneration() int64 {
+	if cd.Cluster == nil {
+		return 0
+	}
+	return cd.Cluster.Generation
+}
+
+func keeperUIDs(a, b Keepers) []string {
+	seen := map[string]bool{}
+	for uid := range a {
+		seen[uid] = true
+	}
+	for uid := range b {
+		seen[uid] = true
+	}
+	return sortedSet(seen)
+}
+
+func dbUIDs(a, b DBs) []string {
+	seen := map[string]bool{}
+	for uid := range a {
+		seen[uid] = true
+	}
+	for uid := range b {
+		seen[uid] = true
+	}
+	return sortedSet(seen)
+}
+
+func sortedSet(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,146 @@
+package stolon
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testClusterData() *ClusterData {
+	return &ClusterData{
+		FormatVersion: 1,
+		Cluster: &Cluster{
+			Generation: 1,
+			Spec:       &ClusterSpec{PGParameters: PGParameters{"work_mem": "4MB"}},
+			Status:     ClusterStatus{Master: "db1", Phase: ClusterPhaseNormal},
+		},
+		Keepers: Keepers{
+			"k1": {UID: "k1", Status: KeeperStatus{Healthy: true}},
+			"k2": {UID: "k2", Status: KeeperStatus{Healthy: true}},
+		},
+		DBs: DBs{
+			"db1": {UID: "db1", Generation: 1, Spec: &DBSpec{KeeperUID: "k1", Role: "master"}, Status: DBStatus{Healthy: true}},
+			"db2": {UID: "db2", Generation: 1, Spec: &DBSpec{KeeperUID: "k2", Role: "standby"}, Status: DBStatus{Healthy: true}},
+		},
+	}
+}
+
+func eventTypes(events []Event) []EventType {
+	types := []EventType{}
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestDiff(t *testing.T) {
+	cases := map[string]struct {
+		change   func(cd *ClusterData)
+		expected []EventType
+	}{
+		"unchanged": {
+			change:   func(cd *ClusterData) {},
+			expected: []EventType{},
+		},
+		"master changed": {
+			change: func(cd *ClusterData) {
+				cd.Cluster.Status.Master = "db2"
+			},
+			expected: []EventType{EventMasterChanged},
+		},
+		"keeper unhealthy": {
+			change: func(cd *ClusterData) {
+				cd.Keepers["k2"].Status.Healthy = false
+				cd.DBs["db2"].Status.Healthy = false
+			},
+			expected: []EventType{EventKeeperUnhealthy, EventDBUnhealthy},
+		},
+		"keeper added and removed": {
+			change: func(cd *ClusterData) {
+				delete(cd.Keepers, "k2")
+				cd.Keepers["k3"] = &Keeper{UID: "k3"}
+			},
+			expected: []EventType{EventKeeperRemoved, EventKeeperAdded},
+		},
+		"db generation bumped": {
+			change: func(cd *ClusterData) {
+				cd.DBs["db2"].Generation = 2
+			},
+			expected: []EventType{EventDBGenerationChanged},
+		},
+		"spec changed": {
+			change: func(cd *ClusterData) {
+				cd.Cluster.Generation = 2
+				cd.Cluster.Spec.PGParameters["work_mem"] = "8MB"
+			},
+			expected: []EventType{EventSpecChanged},
+		},
+		"phase changed": {
+			change: func(cd *ClusterData) {
+				cd.Cluster.Status.Phase = ClusterPhaseInitializing
+			},
+			expected: []EventType{EventPhaseChanged},
+		},
+	}
+
+	for name, c := range cases {
+		prev, cur := testClusterData(), testClusterData()
+		c.change(cur)
+
+		assert.Equal(t, c.expected, eventTypes(Diff(prev, cur)), name)
+	}
+}
+
+func TestDiffMasterChanged(t *testing.T) {
+	prev, cur := testClusterData(), testClusterData()
+	cur.Cluster.Status.Master = "db2"
+
+	events := Diff(prev, cur)
+	require.Len(t, events, 1)
+	assert.Equal(t, "k1", events[0].Previous)
+	assert.Equal(t, "k2", events[0].Current)
+	assert.Equal(t, "db2", events[0].DBUID)
+}
+
+func TestWatcher(t *testing.T) {
+	kv := newFakeKV()
+	store := newTestStores(t, kv)[BackendConsul]
+	client := NewClient(store, DefaultStorePrefix, "app")
+
+	write := func(cd *ClusterData) {
+		data, err := json.Marshal(cd)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(context.TODO(), client.Key("clusterdata"), data))
+	}
+
+	cd := testClusterData()
+	write(cd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events := NewWatcher(client, 10*time.Millisecond).WatchFrom(ctx, cd)
+
+	next := testClusterData()
+	next.Cluster.Status.Master = "db2"
+	write(next)
+
+	event := <-events
+	assert.Equal(t, EventMasterChanged, event.Type)
+	assert.Equal(t, "k2", event.Current)
+
+	kv.mu.Lock()
+	kv.down = true
+	kv.mu.Unlock()
+
+	event = <-events
+	assert.Equal(t, EventStoreError, event.Type)
+
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,256 @@
+package flypg
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+const (
+	ProfileDefault   = "default"
+	ProfileOLTP      = "oltp"
+	ProfileAnalytics = "analytics"
+	ProfileMixed     = "mixed"
+	ProfileSmallDev  = "small-dev"
+)
+
+// DefaultProfile generates the same parameters as before profiles existed:
+// memory settings scale with memory, max_connections is 300 and the worker
+// settings are fixed. The other profiles also scale connections and workers
+// with cpus and memory, and tune WAL and autovacuum, so they have to be
+// chosen explicitly.
+const DefaultProfile = ProfileDefault
+
+// profile describes how a workload scales with memory and cpus. Memory
+// divisors and fractions apply to the total memory in MB.
+type profile struct {
+	// legacy profiles ignore everything else, see legacyParameters.
+	legacy bool
+
+	sharedBuffers      float64
+	effectiveCacheSize float64
+	workMemDivisor     int64
+	maintenanceDivisor int64
+	minMaintenance     int64
+
+	connectionsPerCPU int64
+	minConnections    int64
+	maxConnections    int64
+
+	// gatherPerCPU is the share of cpus a single query can use in parallel.
+	gatherPerCPU float64
+	maxGather    int64
+	maxWALBuffer int64
+
+	checkpointCompletionTarget string
+
+	minAutovacuumWorkers  int64
+	maxAutovacuumWorkers  int64
+	autovacuumNaptime     string
+	autovacuumScaleFactor float64
+}
+
+var profiles = map[string]profile{
+	ProfileDefault: {legacy: true},
+	ProfileOLTP: {
+		sharedBuffers:              0.25,
+		effectiveCacheSize:         0.75,
+		workMemDivisor:             128,
+		maintenanceDivisor:         20,
+		minMaintenance:             64,
+		connectionsPerCPU:          200,
+		minConnections:             300,
+		maxConnections:             1000,
+		gatherPerCPU:               0.25,
+		maxGather:                  2,
+		maxWALBuffer:               64,
+		checkpointCompletionTarget: "0.9",
+		minAutovacuumWorkers:       3,
+		maxAutovacuumWorkers:       8,
+		autovacuumNaptime:          "15s",
+		autovacuumScaleFactor:      0.05,
+	},
+	ProfileAnalytics: {
+		sharedBuffers:              0.25,
+		effectiveCacheSize:         0.75,
+		workMemDivisor:             16,
+		maintenanceDivisor:         10,
+		minMaintenance:             64,
+		connectionsPerCPU:          25,
+		minConnections:             50,
+		maxConnections:             200,
+		gatherPerCPU:               1,
+		maxGather:                  16,
+		maxWALBuffer:               16,
+		checkpointCompletionTarget: "0.9",
+		minAutovacuumWorkers:       3,
+		maxAutovacuumWorkers:       6,
+		autovacuumNaptime:          "1min",
+		autovacuumScaleFactor:      0.2,
+	},
+	ProfileMixed: {
+		sharedBuffers:              0.25,
+		effectiveCacheSize:         0.75,
+		workMemDivisor:             64,
+		maintenanceDivisor:         20,
+		minMaintenance:             64,
+		connectionsPerCPU:          100,
+		minConnections:             300,
+		maxConnections:             500,
+		gatherPerCPU:               0.5,
+		maxGather:                  4,
+		maxWALBuffer:               16,
+		checkpointCompletionTarget: "0.9",
+		minAutovacuumWorkers:       3,
+		maxAutovacuumWorkers:       8,
+		autovacuumNaptime:          "30s",
+		autovacuumScaleFactor:      0.1,
+	},
+	ProfileSmallDev: {
+		sharedBuffers:              0.125,
+		effectiveCacheSize:         0.5,
+		workMemDivisor:             128,
+		maintenanceDivisor:         32,
+		minMaintenance:             16,
+		connectionsPerCPU:          25,
+		minConnections:             25,
+		maxConnections:             50,
+		maxWALBuffer:               4,
+		checkpointCompletionTarget: "0.7",
+		minAutovacuumWorkers:       1,
+		maxAutovacuumWorkers:       2,
+		autovacuumNaptime:          "1min",
+		autovacuumScaleFactor:      0.2,
+	},
+}
+
+// Profiles returns the names of the tuning profiles.
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resources are what the tuning profiles scale with.
+type Resources struct {
+	MemoryMb int64 `json:"memory_mb"`
+	CPUs     int   `json:"cpus"`
+}
+
+// SystemResources returns the memory and cpus of this VM.
+func SystemResources() (Resources, error) {
+	mem, err := memTotal()
+	if err != nil {
+		return Resources{}, err
+	}
+
+	return Resources{MemoryMb: mem, CPUs: runtime.NumCPU()}, nil
+}
+
+// TuningProfile returns the profile set by PG_TUNING_PROFILE, then the one
+// in the cluster spec overrides, then DefaultProfile.
+func TuningProfile() (string, error) {
+	if name := os.Getenv("PG_TUNING_PROFILE"); name != "" {
+		return name, nil
+	}
+
+	overrides, err := readOverrides(overridesFilename())
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if overrides.Profile != "" {
+		return overrides.Profile, nil
+	}
+
+	return DefaultProfile, nil
+}
+
+// TuningParameters returns the pg parameters a profile derives from res.
+func TuningParameters(name string, res Resources) (map[string]string, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tuning profile %q, expected one of %v", name, Profiles())
+	}
+
+	if p.legacy {
+		return legacyParameters(res.MemoryMb), nil
+	}
+
+	mem := res.MemoryMb
+	cpus := int64(res.CPUs)
+	if cpus < 1 {
+		cpus = 1
+	}
+
+	sharedBuffers := max(32, int64(float64(mem)*p.sharedBuffers))
+	maintenanceWorkMem := min(2048, max(p.minMaintenance, mem/p.maintenanceDivisor))
+
+	// Every connection costs a few MB before it runs anything.
+	connections := clamp(cpus*p.connectionsPerCPU, p.minConnections, p.maxConnections)
+	connections = min(connections, max(20, mem/2))
+
+	parallelWorkers := cpus
+	gather := min(p.maxGather, int64(float64(cpus)*p.gatherPerCPU))
+	parallelMaintenance := min(4, cpus/2)
+	if p.maxGather == 0 {
+		parallelMaintenance = 0
+	}
+
+	autovacuumWorkers := clamp(cpus/2, p.minAutovacuumWorkers, p.maxAutovacuumWorkers)
+	// Each autovacuum worker can use up to autovacuum_work_mem at once.
+	autovacuumWorkMem := max(16, min(maintenanceWorkMem, mem/(8*autovacuumWorkers)))
+
+	return map[string]string{
+		"shared_buffers":                   fmt.Sprintf("%dMB", sharedBuffers),
+		"effective_cache_size":             fmt.Sprintf("%dMB", int64(float64(mem)*p.effectiveCacheSize)),
+		"work_mem":                         fmt.Sprintf("%dMB", max(4, mem/p.workMemDivisor)),
+		"maintenance_work_mem":             fmt.Sprintf("%dMB", maintenanceWorkMem),
+		"max_connections":                  strconv.FormatInt(connections, 10),
+		"max_worker_processes":             strconv.FormatInt(max(8, parallelWorkers+4), 10),
+		"max_parallel_workers":             strconv.FormatInt(parallelWorkers, 10),
+		"max_parallel_workers_per_gather":  strconv.FormatInt(gather, 10),
+		"max_parallel_maintenance_workers": strconv.FormatInt(parallelMaintenance, 10),
+		"wal_buffers":                      fmt.Sprintf("%dMB", clamp(sharedBuffers/32, 1, p.maxWALBuffer)),
+		"checkpoint_completion_target":     p.checkpointCompletionTarget,
+		"autovacuum_max_workers":           strconv.FormatInt(autovacuumWorkers, 10),
+		"autovacuum_work_mem":              fmt.Sprintf("%dMB", autovacuumWorkMem),
+		"autovacuum_vacuum_cost_limit":     strconv.FormatInt(200*autovacuumWorkers, 10),
+		"autovacuum_naptime":               p.autovacuumNaptime,
+		"autovacuum_vacuum_scale_factor":   strconv.FormatFloat(p.autovacuumScaleFactor, 'f', -1, 64),
+		"autovacuum_analyze_scale_factor":  strconv.FormatFloat(p.autovacuumScaleFactor/2, 'f', -1, 64),
+	}, nil
+}
+
+// legacyParameters are the parameters generated before tuning profiles
+// existed.
+func legacyParameters(mem int64) map[string]string {
+	return map[string]string{
+		"shared_buffers":                  fmt.Sprintf("%dMB", mem/4),
+		"effective_cache_size":            fmt.Sprintf("%dMB", 3*mem/4),
+		"work_mem":                        fmt.Sprintf("%dMB", max(4, mem/64)),
+		"maintenance_work_mem":            fmt.Sprintf("%dMB", max(64, mem/20)),
+		"max_connections":                 "300",
+		"max_worker_processes":            "8",
+		"max_parallel_workers":            "8",
+		"max_parallel_workers_per_gather": "2",
+	}
+}
+
+func min(n ...int64) int64 {
+	m := n[0]
+	for _, num := range n[1:] {
+		if num < m {
+			m = num
+		}
+	}
+	return m
+}
+
+func clamp(n, lo, hi int64) int64 {
+	return max(lo, min(hi, n))
+}
@@ -0,0 +1,80 @@
+package flypg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTuningParameters(t *testing.T) {
+	t.Run("default keeps the previous parameters", func(t *testing.T) {
+		params, err := TuningParameters(DefaultProfile, Resources{MemoryMb: 1024, CPUs: 1})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]string{
+			"shared_buffers":                  "256MB",
+			"effective_cache_size":            "768MB",
+			"work_mem":                        "16MB",
+			"maintenance_work_mem":            "64MB",
+			"max_connections":                 "300",
+			"max_worker_processes":            "8",
+			"max_parallel_workers":            "8",
+			"max_parallel_workers_per_gather": "2",
+		}, params)
+
+		params, err = TuningParameters(DefaultProfile, Resources{MemoryMb: 256, CPUs: 8})
+		require.NoError(t, err)
+		assert.Equal(t, "300", params["max_connections"])
+		assert.Equal(t, "8", params["max_worker_processes"])
+	})
+
+	t.Run("mixed", func(t *testing.T) {
+		params, err := TuningParameters(ProfileMixed, Resources{MemoryMb: 1024, CPUs: 1})
+		require.NoError(t, err)
+
+		assert.Equal(t, "256MB", params["shared_buffers"])
+		assert.Equal(t, "300", params["max_connections"])
+		assert.Equal(t, "8", params["max_worker_processes"])
+		assert.Equal(t, "30s", params["autovacuum_naptime"])
+	})
+
+	t.Run("scales with cpus", func(t *testing.T) {
+		params, err := TuningParameters(ProfileAnalytics, Resources{MemoryMb: 16384, CPUs: 8})
+		require.NoError(t, err)
+
+		assert.Equal(t, "200", params["max_connections"])
+		assert.Equal(t, "12", params["max_worker_processes"])
+		assert.Equal(t, "8", params["max_parallel_workers"])
+		assert.Equal(t, "8", params["max_parallel_workers_per_gather"])
+		assert.Equal(t, "4", params["max_parallel_maintenance_workers"])
+		assert.Equal(t, "4", params["autovacuum_max_workers"])
+		assert.Equal(t, "800", params["autovacuum_vacuum_cost_limit"])
+		assert.Equal(t, "16MB", params["wal_buffers"])
+	})
+
+	t.Run("connections are capped by memory", func(t *testing.T) {
+		params, err := TuningParameters(ProfileOLTP, Resources{MemoryMb: 256, CPUs: 1})
+		require.NoError(t, err)
+
+		assert.Equal(t, "128", params["max_connections"])
+		assert.Equal(t, "0", params["max_parallel_workers_per_gather"])
+	})
+
+	t.Run("small-dev", func(t *testing.T) {
+		params, err := TuningParameters(ProfileSmallDev, Resources{MemoryMb: 256, CPUs: 2})
+		require.NoError(t, err)
+
+		assert.Equal(t, "32MB", params["shared_buffers"])
+		assert.Equal(t, "50", params["max_connections"])
+		assert.Equal(t, "0", params["max_parallel_workers_per_gather"])
+		assert.Equal(t, "0", params["max_parallel_maintenance_workers"])
+		assert.Equal(t, "1", params["autovacuum_max_workers"])
+		assert.Equal(t, "16MB", params["autovacuum_work_mem"])
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		_, err := TuningParameters("web", Resources{MemoryMb: 1024, CPUs: 1})
+		assert.EqualError(t, err, `unknown tuning profile "web", expected one of [analytics default mixed oltp small-dev]`)
+	})
+}
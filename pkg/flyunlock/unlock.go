@@ -181,23 +181,23 @@ func createRequiredUsers(conn *pgx.Conn) error {
 				exists = true
 			}
 		}
-		var sql string
-
 		if exists {
-			sql = fmt.Sprintf("ALTER USER %s WITH PASSWORD '%s'", user, pass)
-		} else {
-			// create user
-			switch user {
-			case "flypgadmin":
-				sql = fmt.Sprintf(`CREATE USER %s WITH SUPERUSER LOGIN PASSWORD '%s'`, user, pass)
-			case "repluser":
-				sql = fmt.Sprintf(`CREATE USER %s WITH REPLICATION PASSWORD '%s'`, user, pass)
-			case "postgres":
-				sql = fmt.Sprintf(`CREATE USER %s WITH LOGIN PASSWORD '%s'`, user, pass)
+			if err := admin.ChangePassword(context.Background(), conn, user, pass); err != nil {
+				return err
 			}
+			continue
 		}
 
-		_, err := conn.Exec(context.Background(), sql)
+		if err := admin.CreateUser(context.Background(), conn, user, pass); err != nil {
+			return err
+		}
+
+		switch user {
+		case "flypgadmin":
+			err = admin.GrantSuperuser(context.Background(), conn, user)
+		case "repluser":
+			err = admin.GrantReplication(context.Background(), conn, user)
+		}
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,265 @@
+// Package metrics exposes counters and gauges in the prometheus text format.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+// Collector refreshes metrics that are read on demand, it runs before every
+// scrape.
+type Collector func(ctx context.Context)
+
+type Registry struct {
+	mu         sync.Mutex
+	families   map[string]*family
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Default is the registry served by Handler.
+var Default = NewRegistry()
+
+type family struct {
+	name, help, typ string
+	labels          []string
+
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func (r *Registry) register(name, help, typ string, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+
+	f := &family{name: name, help: help, typ: typ, labels: labels, samples: map[string]*sample{}}
+	r.families[name] = f
+	return f
+}
+
+// AddCollector registers fn to run before every scrape.
+func (r *Registry) AddCollector(fn Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, fn)
+}
+
+func (f *family) sample(labelValues []string) *sample {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.samples[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		f.samples[key] = s
+	}
+	return s
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	f *family
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, typeCounter, labels)}
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %s can't decrease", c.f.name))
+	}
+
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+
+	c.f.sample(labelValues).value += v
+}
+
+// Set is for counters kept elsewhere, such as the restart count of a
+// supervised process, and read by a Collector.
+func (c *CounterVec) Set(v float64, labelValues ...string) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+
+	c.f.sample(labelValues).value = v
+}
+
+// Reset drops every sample, for collectors whose label values come and go.
+func (c *CounterVec) Reset() {
+	c.f.reset()
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	f *family
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, typeGauge, labels)}
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+
+	g.f.sample(labelValues).value = v
+}
+
+// SetBool sets 1 for true and 0 for false.
+func (g *GaugeVec) SetBool(b bool, labelValues ...string) {
+	var v float64
+	if b {
+		v = 1
+	}
+	g.Set(v, labelValues...)
+}
+
+// Reset drops every sample, for collectors whose label values come and go.
+func (g *GaugeVec) Reset() {
+	g.f.reset()
+}
+
+func (f *family) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.samples = map[string]*sample{}
+}
+
+// Write runs the collectors and writes every metric with at least one
+// sample, sorted by name.
+func (r *Registry) Write(ctx context.Context, w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+
+	for _, collect := range collectors {
+		collect(ctx)
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (f *family) write(b *strings.Builder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.samples) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(f.samples))
+	for key := range f.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.typ)
+
+	for _, key := range keys {
+		s := f.samples[key]
+
+		b.WriteString(f.name)
+		if len(f.labels) > 0 {
+			b.WriteByte('{')
+			for i, label := range f.labels {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(b, "%s=\"%s\"", label, escapeLabel(s.labelValues[i]))
+			}
+			b.WriteByte('}')
+		}
+		b.WriteByte(' ')
+		b.WriteString(formatValue(s.value))
+		b.WriteByte('\n')
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler serves the Default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(r.Context(), w)
+	})
+}
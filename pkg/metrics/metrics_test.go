@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+
+	restarts := r.NewCounterVec("test_restarts_total", "Restarts by process.", "process")
+	up := r.NewGaugeVec("test_up", "Whether the store is up.")
+	r.NewGaugeVec("test_unused", "Never set.")
+
+	restarts.Inc("keeper")
+	restarts.Add(2, "keeper")
+	restarts.Inc("proxy")
+
+	collected := 0
+	r.AddCollector(func(ctx context.Context) {
+		collected++
+		up.SetBool(true)
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(context.Background(), &buf))
+
+	expected := `# HELP test_restarts_total Restarts by process.
+# TYPE test_restarts_total counter
+test_restarts_total{process="keeper"} 3
+test_restarts_total{process="proxy"} 1
+# HELP test_up Whether the store is up.
+# TYPE test_up gauge
+test_up 1
+`
+	assert.Equal(t, expected, buf.String())
+	assert.Equal(t, 1, collected)
+}
+
+func TestWriteEscaping(t *testing.T) {
+	r := NewRegistry()
+
+	info := r.NewGaugeVec("test_info", "Help with a \\ and a\nnewline.", "value")
+	info.Set(0.5, "a \"quoted\"\nvalue \\")
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(context.Background(), &buf))
+
+	expected := `# HELP test_info Help with a \\ and a\nnewline.
+# TYPE test_info gauge
+test_info{value="a \"quoted\"\nvalue \\"} 0.5
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestReset(t *testing.T) {
+	r := NewRegistry()
+
+	healthy := r.NewGaugeVec("test_healthy", "Health by keeper.", "keeper")
+	healthy.Set(1, "old")
+	healthy.Reset()
+	healthy.Set(1, "new")
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(context.Background(), &buf))
+
+	assert.NotContains(t, buf.String(), "old")
+	assert.Contains(t, buf.String(), `test_healthy{keeper="new"} 1`)
+}
+
+func TestMisuse(t *testing.T) {
+	r := NewRegistry()
+
+	counter := r.NewCounterVec("test_total", "Test.", "a", "b")
+
+	assert.Panics(t, func() { counter.Inc("only one") })
+	assert.Panics(t, func() { counter.Add(-1, "a", "b") })
+	assert.Panics(t, func() { r.NewGaugeVec("test_total", "Test.") })
+}
@@ -13,21 +13,33 @@ func AllPeers(ctx context.Context, appName string) ([]net.IPAddr, error) {
 	return Get6PN(ctx, fmt.Sprintf("%s.internal", appName))
 }
 
-func Get6PN(ctx context.Context, hostname string) ([]net.IPAddr, error) {
-	nameserver := os.Getenv("FLY_NAMESERVER")
-	if nameserver == "" {
-		nameserver = "fdaa::3"
+// RegionPeers returns the instances of an app running in a region. Unlike
+// AllPeers it does not include the local instance unless it is in that region.
+func RegionPeers(ctx context.Context, appName string, region string) ([]net.IPAddr, error) {
+	return resolver().LookupIPAddr(ctx, fmt.Sprintf("%s.%s.internal", region, appName))
+}
+
+// AllRegions returns the regions an app is running in.
+func AllRegions(ctx context.Context, appName string) ([]string, error) {
+	records, err := resolver().LookupTXT(ctx, fmt.Sprintf("regions.%s.internal", appName))
+	if err != nil {
+		return nil, err
 	}
-	nameserver = net.JoinHostPort(nameserver, "53")
-	r := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: 1 * time.Second,
+
+	var regions []string
+	for _, record := range records {
+		for _, region := range strings.Split(record, ",") {
+			if region = strings.TrimSpace(region); region != "" {
+				regions = append(regions, region)
 			}
-			return d.DialContext(ctx, "udp6", nameserver)
-		},
+		}
 	}
+
+	return regions, nil
+}
+
+func Get6PN(ctx context.Context, hostname string) ([]net.IPAddr, error) {
+	r := resolver()
 	ips, err := r.LookupIPAddr(ctx, hostname)
 
 	if err != nil {
@@ -54,6 +66,23 @@ func Get6PN(ctx context.Context, hostname string) ([]net.IPAddr, error) {
 	return ips, err
 }
 
+func resolver() *net.Resolver {
+	nameserver := os.Getenv("FLY_NAMESERVER")
+	if nameserver == "" {
+		nameserver = "fdaa::3"
+	}
+	nameserver = net.JoinHostPort(nameserver, "53")
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{
+				Timeout: 1 * time.Second,
+			}
+			return d.DialContext(ctx, "udp6", nameserver)
+		},
+	}
+}
+
 func PrivateIPv6() (net.IP, error) {
 	ips, err := net.LookupIP("fly-local-6pn")
 	if err != nil && !strings.HasSuffix(err.Error(), "no such host") && !strings.HasSuffix(err.Error(), "server misbehaving") {
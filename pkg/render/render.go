@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 )
@@ -31,7 +32,7 @@ func status(err error) int {
 		return http.StatusOK
 	}
 
-	if errors.Is(err, pgx.ErrNoRows) {
+	if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, supervisor.ErrUnknownProcess) {
 		return http.StatusNotFound
 	}
 
@@ -4,18 +4,42 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/fly-examples/postgres-ha/pkg/auth"
 	"github.com/fly-examples/postgres-ha/pkg/commands"
 	"github.com/fly-examples/postgres-ha/pkg/flycheck"
+	"github.com/fly-examples/postgres-ha/pkg/metrics"
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
 	"github.com/go-chi/chi/v5"
 )
 
 const Port = 5500
 
-func StartHttpServer() {
+func StartHttpServer(svisor *supervisor.Supervisor) {
+	logger := svisor.Logger("http")
+
+	commands.UseSupervisor(svisor)
+	registerCollectors(svisor, logger)
+
+	authn, err := auth.FromEnv()
+	if err != nil {
+		logger.Error("failed to load api keys, all commands will be denied:", err)
+	} else if authn.Open() {
+		logger.Println("WARNING: FLYPG_AUTH_DISABLED is set, commands are not authenticated.")
+	} else if authn.Locked() {
+		logger.Println("WARNING: no api keys are configured, all commands will be denied. Set ADMIN_API_KEYS, or FLYPG_AUTH_DISABLED=1 to disable authentication.")
+	}
+
+	thresholds, err := flycheck.LoadThresholds()
+	if err != nil {
+		logger.Error("failed to load check thresholds, using the defaults:", err)
+		thresholds = flycheck.DefaultThresholds
+	}
+
 	r := chi.NewMux()
 
-	r.Mount("/flycheck", flycheck.Handler())
-	r.Mount("/commands", commands.Handler())
+	r.Mount("/flycheck", flycheck.Handler(svisor, thresholds))
+	r.Mount("/commands", commands.Handler(authn))
+	r.Handle("/metrics", metrics.Handler())
 
 	http.ListenAndServe(fmt.Sprintf(":%d", Port), r)
 }
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg"
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/fly-examples/postgres-ha/pkg/metrics"
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
+)
+
+var (
+	processUp = metrics.NewGaugeVec("flypg_process_up",
+		"Whether the supervised process is running.", "process")
+	processReady = metrics.NewGaugeVec("flypg_process_ready",
+		"Whether the supervised process passed its readiness probe.", "process")
+	processDegraded = metrics.NewGaugeVec("flypg_process_degraded",
+		"Whether the supervised process is crash looping.", "process")
+	processCrashes = metrics.NewGaugeVec("flypg_process_consecutive_crashes",
+		"Crashes of the supervised process since it last ran long enough to be healthy.", "process")
+	processRestarts = metrics.NewCounterVec("flypg_process_restarts_total",
+		"Times the supervisor restarted the process.", "process")
+
+	stolonUp = metrics.NewGaugeVec("flypg_stolon_up",
+		"Whether the stolon cluster data could be read from the store.")
+	keeperHealthy = metrics.NewGaugeVec("flypg_stolon_keeper_healthy",
+		"Whether the sentinel reports the keeper as healthy.", "keeper")
+	dbHealthy = metrics.NewGaugeVec("flypg_stolon_db_healthy",
+		"Whether the sentinel reports the db as healthy.", "db", "keeper", "role")
+	masterInfo = metrics.NewGaugeVec("flypg_stolon_master_info",
+		"The keeper and db currently elected master, always 1.", "keeper", "db")
+	masterGeneration = metrics.NewGaugeVec("flypg_stolon_master_generation",
+		"Generation of the master db, it changes when the master is reconfigured.")
+	clusterGeneration = metrics.NewGaugeVec("flypg_stolon_cluster_generation",
+		"Generation of the cluster spec.")
+	masterChanges = metrics.NewCounterVec("flypg_stolon_master_changes_total",
+		"Master changes seen by this node since it started, however they were triggered.")
+)
+
+// clusterDataTimeout bounds the store read of a scrape.
+const clusterDataTimeout = 3 * time.Second
+
+func registerCollectors(svisor *supervisor.Supervisor, logger *supervisor.Logger) {
+	metrics.Default.AddCollector(func(ctx context.Context) {
+		collectProcesses(svisor.Processes())
+	})
+
+	cluster := &clusterCollector{log: logger}
+	metrics.Default.AddCollector(cluster.collect)
+}
+
+func collectProcesses(statuses []supervisor.ProcessStatus) {
+	for _, status := range statuses {
+		processUp.SetBool(status.PID != 0, status.Name)
+		processReady.SetBool(status.Ready, status.Name)
+		processDegraded.SetBool(status.Degraded, status.Name)
+		processCrashes.Set(float64(status.Crashes), status.Name)
+		processRestarts.Set(float64(status.Restarts), status.Name)
+	}
+}
+
+// clusterCollector reads the stolon cluster data on each scrape, and
+// remembers the master to count changes.
+type clusterCollector struct {
+	log *supervisor.Logger
+
+	mu      sync.Mutex
+	master  string
+	lastErr string
+}
+
+func (c *clusterCollector) collect(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, clusterDataTimeout)
+	defer cancel()
+
+	cd, err := readClusterData(ctx)
+	c.logError(err)
+	if err != nil {
+		stolonUp.Set(0)
+		return
+	}
+
+	stolonUp.Set(1)
+	c.record(cd)
+}
+
+// logError logs store errors when they change, rather than on every scrape.
+func (c *clusterCollector) logError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	}
+
+	if msg != "" && msg != c.lastErr {
+		c.log.Error("failed to collect cluster metrics:", msg)
+	}
+	c.lastErr = msg
+}
+
+func readClusterData(ctx context.Context) (*stolon.ClusterData, error) {
+	node, err := flypg.NewNode()
+	if err != nil {
+		return nil, err
+	}
+	return node.GetStolonClusterData(ctx)
+}
+
+func (c *clusterCollector) record(cd *stolon.ClusterData) {
+	keeperHealthy.Reset()
+	for uid, keeper := range cd.Keepers {
+		keeperHealthy.SetBool(keeper.Status.Healthy, uid)
+	}
+
+	dbHealthy.Reset()
+	for uid, db := range cd.DBs {
+		var keeper, role string
+		if db.Spec != nil {
+			keeper, role = db.Spec.KeeperUID, db.Spec.Role
+		}
+		dbHealthy.SetBool(db.Status.Healthy, uid, keeper, role)
+	}
+
+	if cd.Cluster != nil {
+		clusterGeneration.Set(float64(cd.Cluster.Generation))
+	}
+
+	masterInfo.Reset()
+	masterGeneration.Reset()
+
+	master := cd.MasterDB()
+	if master == nil {
+		return
+	}
+
+	keeper := cd.MasterKeeperUID()
+	masterInfo.Set(1, keeper, master.UID)
+	masterGeneration.Set(float64(master.Generation))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// exported as 0 until the first change
+	masterChanges.Add(0)
+	if c.master != "" && c.master != master.UID {
+		masterChanges.Inc()
+	}
+	c.master = master.UID
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/fly-examples/postgres-ha/pkg/flypg/stolon"
+	"github.com/fly-examples/postgres-ha/pkg/metrics"
+	"github.com/fly-examples/postgres-ha/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func clusterData(master string) *stolon.ClusterData {
+	return &stolon.ClusterData{
+		Cluster: &stolon.Cluster{
+			Generation: 3,
+			Status:     stolon.ClusterStatus{Master: master},
+		},
+		Keepers: stolon.Keepers{
+			"keeper1": {UID: "keeper1", Status: stolon.KeeperStatus{Healthy: true}},
+			"keeper2": {UID: "keeper2"},
+		},
+		DBs: stolon.DBs{
+			"db1": {UID: "db1", Generation: 7, Spec: &stolon.DBSpec{KeeperUID: "keeper1", Role: "master"}, Status: stolon.DBStatus{Healthy: true}},
+			"db2": {UID: "db2", Generation: 2, Spec: &stolon.DBSpec{KeeperUID: "keeper2", Role: "standby"}},
+		},
+	}
+}
+
+func scrape(t *testing.T) string {
+	var buf bytes.Buffer
+	require.NoError(t, metrics.Default.Write(context.Background(), &buf))
+	return buf.String()
+}
+
+func TestClusterMetrics(t *testing.T) {
+	var c clusterCollector
+
+	c.record(clusterData("db1"))
+
+	out := scrape(t)
+	assert.Contains(t, out, `flypg_stolon_keeper_healthy{keeper="keeper1"} 1`)
+	assert.Contains(t, out, `flypg_stolon_keeper_healthy{keeper="keeper2"} 0`)
+	assert.Contains(t, out, `flypg_stolon_db_healthy{db="db2",keeper="keeper2",role="standby"} 0`)
+	assert.Contains(t, out, `flypg_stolon_master_info{keeper="keeper1",db="db1"} 1`)
+	assert.Contains(t, out, "flypg_stolon_master_generation 7\n")
+	assert.Contains(t, out, "flypg_stolon_cluster_generation 3\n")
+	assert.Contains(t, out, "flypg_stolon_master_changes_total 0\n")
+
+	// a failover to db2
+	c.record(clusterData("db2"))
+
+	out = scrape(t)
+	assert.Contains(t, out, `flypg_stolon_master_info{keeper="keeper2",db="db2"} 1`)
+	assert.NotContains(t, out, `flypg_stolon_master_info{keeper="keeper1"`)
+	assert.Contains(t, out, "flypg_stolon_master_generation 2\n")
+	assert.Contains(t, out, "flypg_stolon_master_changes_total 1\n")
+}
+
+func TestProcessMetrics(t *testing.T) {
+	collectProcesses([]supervisor.ProcessStatus{
+		{Name: "keeper", PID: 42, Ready: true, Restarts: 2},
+		{Name: "proxy", Degraded: true, Crashes: 5, Restarts: 5},
+	})
+
+	out := scrape(t)
+	assert.Contains(t, out, `flypg_process_up{process="keeper"} 1`)
+	assert.Contains(t, out, `flypg_process_ready{process="keeper"} 1`)
+	assert.Contains(t, out, `flypg_process_restarts_total{process="keeper"} 2`)
+	assert.Contains(t, out, `flypg_process_up{process="proxy"} 0`)
+	assert.Contains(t, out, `flypg_process_degraded{process="proxy"} 1`)
+	assert.Contains(t, out, `flypg_process_consecutive_crashes{process="proxy"} 5`)
+}
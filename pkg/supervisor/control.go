@@ -0,0 +1,243 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Process states reported by ProcessStatus.
+const (
+	StateWaiting    = "waiting"
+	StateRunning    = "running"
+	StateRestarting = "restarting"
+	StateStopping   = "stopping"
+	StateStopped    = "stopped"
+	StateExited     = "exited"
+)
+
+var ErrUnknownProcess = errors.New("unknown process")
+
+type ProcessStatus struct {
+	Name         string     `json:"name"`
+	State        string     `json:"state"`
+	Ready        bool       `json:"ready"`
+	PID          int        `json:"pid,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	Uptime       string     `json:"uptime,omitempty"`
+	Restarts     int        `json:"restarts"`
+	Crashes      int        `json:"consecutive_crashes"`
+	Degraded     bool       `json:"degraded"`
+	LastExitCode *int       `json:"last_exit_code,omitempty"`
+}
+
+func (p *process) status() ProcessStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := ProcessStatus{
+		Name:         p.name,
+		State:        p.state,
+		Restarts:     p.restarts,
+		Crashes:      p.crashes,
+		Degraded:     p.degraded,
+		LastExitCode: p.exitCode,
+	}
+
+	select {
+	case <-p.ready:
+		status.Ready = true
+	default:
+	}
+
+	if p.pid != 0 {
+		startedAt := p.startedAt
+		status.PID = p.pid
+		status.StartedAt = &startedAt
+		status.Uptime = time.Since(startedAt).Round(time.Second).String()
+
+		// a degraded process that has since stayed up has recovered
+		if time.Since(startedAt) >= p.policy.resetAfter() {
+			status.Crashes = 0
+			status.Degraded = false
+		}
+	}
+
+	if p.held {
+		status.State = StateStopped
+		if p.pid != 0 {
+			status.State = StateStopping
+		}
+	}
+
+	return status
+}
+
+func (p *process) setState(state string) {
+	p.mu.Lock()
+	p.state = state
+	p.mu.Unlock()
+}
+
+// wakeUp cuts short a restart delay, or a wait for the process to be
+// started again.
+func (p *process) wakeUp() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// awaitStart blocks while the process is held by the control API. It
+// returns false if the supervisor stopped in the meantime.
+func (p *process) awaitStart(ctx context.Context) bool {
+	for {
+		p.mu.Lock()
+		held := p.held
+		p.mu.Unlock()
+
+		if !held {
+			return true
+		}
+
+		select {
+		case <-p.wake:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// controlled reports whether the last exit was caused by the control API,
+// in which case it shouldn't count as a crash.
+func (p *process) controlled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	restartNow := p.restartNow
+	p.restartNow = false
+
+	return p.held || restartNow
+}
+
+func (h *Supervisor) control(name string) (*process, error) {
+	proc := h.lookup(name)
+	if proc == nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownProcess, name)
+	}
+
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+
+	if proc.state == StateExited {
+		return nil, fmt.Errorf("process %s has exited and is no longer supervised", name)
+	}
+
+	return proc, nil
+}
+
+// Processes returns the status of every process.
+func (h *Supervisor) Processes() []ProcessStatus {
+	statuses := make([]ProcessStatus, 0, len(h.procs))
+	for _, proc := range h.procs {
+		statuses = append(statuses, proc.status())
+	}
+	return statuses
+}
+
+// Process returns the status of the named process.
+func (h *Supervisor) Process(name string) (*ProcessStatus, error) {
+	proc := h.lookup(name)
+	if proc == nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownProcess, name)
+	}
+
+	status := proc.status()
+	return &status, nil
+}
+
+// StopProcess stops the named process and keeps it stopped until it is
+// started again. Processes that depend on it are left running.
+func (h *Supervisor) StopProcess(name string) (*ProcessStatus, error) {
+	proc, err := h.control(name)
+	if err != nil {
+		return nil, err
+	}
+
+	proc.mu.Lock()
+	proc.held = true
+	proc.mu.Unlock()
+
+	proc.wakeUp()
+	h.interruptAndWait(proc)
+
+	return h.Process(name)
+}
+
+// StartProcess starts a process stopped by StopProcess.
+func (h *Supervisor) StartProcess(name string) (*ProcessStatus, error) {
+	proc, err := h.control(name)
+	if err != nil {
+		return nil, err
+	}
+
+	proc.mu.Lock()
+	proc.held = false
+	proc.mu.Unlock()
+
+	proc.wakeUp()
+	h.waitForRunning(proc)
+
+	return h.Process(name)
+}
+
+// RestartProcess stops the named process and starts it again straight
+// away, without waiting for its restart delay.
+func (h *Supervisor) RestartProcess(name string) (*ProcessStatus, error) {
+	proc, err := h.control(name)
+	if err != nil {
+		return nil, err
+	}
+
+	proc.mu.Lock()
+	proc.held = false
+	proc.restartNow = proc.pid != 0
+	proc.mu.Unlock()
+
+	h.interruptAndWait(proc)
+	proc.wakeUp()
+	h.waitForRunning(proc)
+
+	return h.Process(name)
+}
+
+// interruptAndWait stops proc with its stop signal, killing it if it is
+// still running after its stop timeout.
+func (h *Supervisor) interruptAndWait(proc *process) {
+	if !proc.Running() {
+		return
+	}
+
+	proc.Interrupt()
+
+	if !h.waitForStopped(proc, h.stopTimeout(proc), nil) {
+		proc.Kill()
+	}
+}
+
+// waitForRunning gives a process that was just started a moment to come up
+// so the status returned reflects it.
+func (h *Supervisor) waitForRunning(proc *process) {
+	deadline := time.After(h.timeout)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+
+	for !proc.Running() {
+		select {
+		case <-tick.C:
+		case <-deadline:
+			return
+		}
+	}
+}
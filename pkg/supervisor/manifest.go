@@ -0,0 +1,286 @@
+package supervisor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest declares the processes to supervise.
+//
+// String fields may reference variables as $NAME, ${NAME} or
+// ${NAME:-default}. They are resolved when the manifest is loaded, from the
+// vars given to LoadManifest and then the environment.
+type Manifest struct {
+	Processes []ProcessConfig `yaml:"processes"`
+}
+
+type ProcessConfig struct {
+	Name    string            `yaml:"name"`
+	Command string            `yaml:"command"`
+	Env     map[string]string `yaml:"env"`
+	Dir     string            `yaml:"dir"`
+	// StopSignal defaults to SIGINT.
+	StopSignal string `yaml:"stop_signal"`
+	// StopTimeout defaults to the timeout the supervisor was created with.
+	StopTimeout time.Duration `yaml:"stop_timeout"`
+	DependsOn   []string      `yaml:"depends_on"`
+	// Restart is omitted for processes that aren't restarted when they
+	// exit. Unset fields default to those of BackoffPolicy.
+	Restart   *RestartConfig   `yaml:"restart"`
+	Readiness *ReadinessConfig `yaml:"readiness"`
+}
+
+type RestartConfig struct {
+	InitialDelay       time.Duration `yaml:"initial_delay"`
+	MaxDelay           time.Duration `yaml:"max_delay"`
+	Multiplier         float64       `yaml:"multiplier"`
+	Jitter             *float64      `yaml:"jitter"`
+	ResetAfter         time.Duration `yaml:"reset_after"`
+	CrashLoopThreshold *int          `yaml:"crash_loop_threshold"`
+}
+
+// ReadinessConfig sets one readiness probe.
+type ReadinessConfig struct {
+	TCP  string `yaml:"tcp"`
+	HTTP string `yaml:"http"`
+}
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := signals[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported stop signal %s", name)
+	}
+	return sig, nil
+}
+
+// LoadManifest parses a yaml manifest and resolves its variables.
+func LoadManifest(data []byte, vars map[string]string) (*Manifest, error) {
+	var m Manifest
+
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	if err := m.interpolate(vars); err != nil {
+		return nil, err
+	}
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// LoadManifestFile reads and parses the manifest at path.
+func LoadManifestFile(path string, vars map[string]string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := LoadManifest(data, vars)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *Manifest) interpolate(vars map[string]string) error {
+	missing := map[string]bool{}
+
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			name, fallback, hasFallback := splitFallback(name)
+
+			if value, ok := vars[name]; ok {
+				return value
+			}
+			if value, ok := os.LookupEnv(name); ok {
+				return value
+			}
+			if hasFallback {
+				return fallback
+			}
+
+			missing[name] = true
+			return ""
+		})
+	}
+
+	for i := range m.Processes {
+		proc := &m.Processes[i]
+
+		proc.Command = expand(proc.Command)
+		proc.Dir = expand(proc.Dir)
+		for key, value := range proc.Env {
+			proc.Env[key] = expand(value)
+		}
+		if proc.Readiness != nil {
+			proc.Readiness.TCP = expand(proc.Readiness.TCP)
+			proc.Readiness.HTTP = expand(proc.Readiness.HTTP)
+		}
+	}
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return fmt.Errorf("manifest references undefined variables: %s", strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// splitFallback splits a ${NAME:-default} reference.
+func splitFallback(name string) (string, string, bool) {
+	i := strings.Index(name, ":-")
+	if i < 0 {
+		return name, "", false
+	}
+	return name[:i], name[i+2:], true
+}
+
+func (m *Manifest) validate() error {
+	names := map[string]bool{}
+
+	for _, proc := range m.Processes {
+		if proc.Name == "" {
+			return fmt.Errorf("a process is missing a name")
+		}
+		if names[proc.Name] {
+			return fmt.Errorf("process %s is declared twice", proc.Name)
+		}
+		names[proc.Name] = true
+
+		if strings.TrimSpace(proc.Command) == "" {
+			return fmt.Errorf("process %s has no command", proc.Name)
+		}
+
+		if proc.StopSignal != "" {
+			if _, err := parseSignal(proc.StopSignal); err != nil {
+				return fmt.Errorf("process %s: %w", proc.Name, err)
+			}
+		}
+
+		if r := proc.Readiness; r != nil && r.TCP != "" && r.HTTP != "" {
+			return fmt.Errorf("process %s: only one readiness probe can be set", proc.Name)
+		}
+	}
+
+	return nil
+}
+
+// Merge overrides processes in m with those of the same name in other, and
+// adds the rest after them.
+func (m *Manifest) Merge(other *Manifest) {
+	index := map[string]int{}
+	for i, proc := range m.Processes {
+		index[proc.Name] = i
+	}
+
+	for _, proc := range other.Processes {
+		if i, ok := index[proc.Name]; ok {
+			m.Processes[i] = proc
+			continue
+		}
+		m.Processes = append(m.Processes, proc)
+	}
+}
+
+func (c *RestartConfig) policy() RestartPolicy {
+	policy := BackoffPolicy
+
+	if c.InitialDelay > 0 {
+		policy.InitialDelay = c.InitialDelay
+	}
+	if c.MaxDelay > 0 {
+		policy.MaxDelay = c.MaxDelay
+	}
+	if c.Multiplier > 0 {
+		policy.Multiplier = c.Multiplier
+	}
+	if c.Jitter != nil {
+		policy.Jitter = *c.Jitter
+	}
+	if c.ResetAfter > 0 {
+		policy.ResetAfter = c.ResetAfter
+	}
+	if c.CrashLoopThreshold != nil {
+		policy.CrashLoopThreshold = *c.CrashLoopThreshold
+	}
+
+	// a max delay below the initial one would cap every delay
+	if policy.MaxDelay < policy.InitialDelay {
+		policy.MaxDelay = policy.InitialDelay
+	}
+
+	return policy
+}
+
+func (c ProcessConfig) opts() []Opt {
+	var opts []Opt
+
+	if len(c.Env) > 0 {
+		opts = append(opts, WithEnv(c.Env))
+	}
+	if c.Dir != "" {
+		opts = append(opts, WithRootDir(c.Dir))
+	}
+	if c.StopSignal != "" {
+		// validated when the manifest was loaded
+		sig, _ := parseSignal(c.StopSignal)
+		opts = append(opts, WithStopSignal(sig))
+	}
+	if c.StopTimeout > 0 {
+		opts = append(opts, WithStopTimeout(c.StopTimeout))
+	}
+	if len(c.DependsOn) > 0 {
+		opts = append(opts, WithDependencies(c.DependsOn...))
+	}
+	if c.Restart != nil {
+		opts = append(opts, WithRestartPolicy(c.Restart.policy()))
+	}
+	if r := c.Readiness; r != nil {
+		switch {
+		case r.TCP != "":
+			opts = append(opts, WithReadiness(TCPProbe(r.TCP)))
+		case r.HTTP != "":
+			opts = append(opts, WithReadiness(HTTPProbe(r.HTTP)))
+		}
+	}
+
+	return opts
+}
+
+// AddManifest adds every process declared in m.
+func (h *Supervisor) AddManifest(m *Manifest) {
+	for _, proc := range m.Processes {
+		h.AddProcess(proc.Name, proc.Command, proc.opts()...)
+	}
+}
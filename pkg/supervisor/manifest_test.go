@@ -0,0 +1,111 @@
+package supervisor
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testManifest = `
+processes:
+  - name: db
+    command: postgres -p ${PORT}
+    dir: ${DATA_DIR:-/data}
+    env:
+      PGUSER: $USER_NAME
+    stop_signal: term
+    restart:
+      initial_delay: 2s
+      crash_loop_threshold: 0
+    readiness:
+      tcp: localhost:${PORT}
+  - name: bouncer
+    command: pgbouncer
+    depends_on: [db]
+`
+
+func TestLoadManifest(t *testing.T) {
+	os.Setenv("USER_NAME", "postgres")
+	defer os.Unsetenv("USER_NAME")
+
+	m, err := LoadManifest([]byte(testManifest), map[string]string{"PORT": "5433"})
+	require.NoError(t, err)
+	require.Len(t, m.Processes, 2)
+
+	db := m.Processes[0]
+	assert.Equal(t, "postgres -p 5433", db.Command)
+	assert.Equal(t, "/data", db.Dir)
+	assert.Equal(t, "postgres", db.Env["PGUSER"])
+	assert.Equal(t, "localhost:5433", db.Readiness.TCP)
+
+	policy := db.Restart.policy()
+	assert.Equal(t, 2*time.Second, policy.InitialDelay)
+	assert.Equal(t, BackoffPolicy.MaxDelay, policy.MaxDelay)
+	assert.Equal(t, 0, policy.CrashLoopThreshold)
+
+	s := New("test", time.Second)
+	s.AddManifest(m)
+
+	proc := s.lookup("db")
+	assert.Equal(t, syscall.SIGTERM, proc.stopSignal)
+	assert.True(t, proc.restart)
+	assert.NotNil(t, proc.probe)
+	assert.Equal(t, []string{"db"}, s.lookup("bouncer").dependsOn)
+	assert.False(t, s.lookup("bouncer").restart)
+}
+
+func TestLoadManifestErrors(t *testing.T) {
+	cases := map[string]struct {
+		manifest string
+		err      string
+	}{
+		"undefined variables": {
+			manifest: "processes:\n  - name: a\n    command: run ${NOPE_B} ${NOPE_A}",
+			err:      "manifest references undefined variables: NOPE_A, NOPE_B",
+		},
+		"duplicate": {
+			manifest: "processes:\n  - name: a\n    command: run\n  - name: a\n    command: run",
+			err:      "process a is declared twice",
+		},
+		"no command": {
+			manifest: "processes:\n  - name: a",
+			err:      "process a has no command",
+		},
+		"bad signal": {
+			manifest: "processes:\n  - name: a\n    command: run\n    stop_signal: SIGWINCH",
+			err:      "process a: unsupported stop signal SIGWINCH",
+		},
+		"bad duration": {
+			manifest: "processes:\n  - name: a\n    command: run\n    restart:\n      initial_delay: soon",
+		},
+	}
+
+	for name, c := range cases {
+		_, err := LoadManifest([]byte(c.manifest), nil)
+		require.Error(t, err, name)
+		if c.err != "" {
+			assert.EqualError(t, err, c.err, name)
+		}
+	}
+}
+
+func TestMergeManifest(t *testing.T) {
+	base := &Manifest{Processes: []ProcessConfig{
+		{Name: "keeper", Command: "keeper"},
+		{Name: "proxy", Command: "haproxy"},
+	}}
+
+	base.Merge(&Manifest{Processes: []ProcessConfig{
+		{Name: "proxy", Command: "pgbouncer"},
+		{Name: "shipper", Command: "vector"},
+	}})
+
+	require.Len(t, base.Processes, 3)
+	assert.Equal(t, "keeper", base.Processes[0].Command)
+	assert.Equal(t, "pgbouncer", base.Processes[1].Command)
+	assert.Equal(t, "vector", base.Processes[2].Command)
+}
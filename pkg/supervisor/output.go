@@ -3,31 +3,109 @@ package supervisor
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/term/termios"
 )
 
+// LogFormat selects how output lines are written to stdout.
+type LogFormat string
+
+const (
+	// LogFormatText writes colored lines prefixed with the process name.
+	LogFormatText LogFormat = "text"
+	// LogFormatJSON writes one LogLine json object per line.
+	LogFormatJSON LogFormat = "json"
+)
+
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch LogFormat(s) {
+	case "", LogFormatText:
+		return LogFormatText, nil
+	case LogFormatJSON:
+		return LogFormatJSON, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q, expected text or json", s)
+	}
+}
+
+const (
+	LevelInfo  = "info"
+	LevelError = "error"
+)
+
+type LogLine struct {
+	Time    time.Time `json:"time"`
+	Process string    `json:"process"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// ringSize is the number of recent lines kept for each process.
+const ringSize = 1000
+
+// logRing holds the most recent lines written by a process.
+type logRing struct {
+	lines []LogLine
+	next  int
+}
+
+func (r *logRing) add(line LogLine) {
+	if len(r.lines) < ringSize {
+		r.lines = append(r.lines, line)
+		return
+	}
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % ringSize
+}
+
+// tail returns up to the last n lines, oldest first.
+func (r *logRing) tail(n int) []LogLine {
+	if n <= 0 || n > len(r.lines) {
+		n = len(r.lines)
+	}
+
+	out := make([]LogLine, 0, n)
+	for i := len(r.lines) - n; i < len(r.lines); i++ {
+		out = append(out, r.lines[(r.next+i)%len(r.lines)])
+	}
+	return out
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
 type ptyPipe struct {
 	pty, tty *os.File
 }
 
 type multiOutput struct {
 	maxNameLength int
+	format        LogFormat
 	mutex         sync.Mutex
 	pipes         map[*process]*ptyPipe
+	rings         map[string]*logRing
 }
 
 func (m *multiOutput) openPipe(proc *process) (pipe *ptyPipe) {
 	var err error
 
-	pipe = m.pipes[proc]
+	// every run gets its own pty, the reader of the last one may still be
+	// draining it
+	pipe = &ptyPipe{}
 
 	pipe.pty, pipe.tty, err = termios.Pty()
 	fatalOnErr(err)
 
+	m.mutex.Lock()
+	m.pipes[proc] = pipe
+	m.mutex.Unlock()
+
 	proc.cmd.Stdout = pipe.tty
 	proc.cmd.Stderr = pipe.tty
 	proc.cmd.Stdin = pipe.tty
@@ -38,9 +116,10 @@ func (m *multiOutput) openPipe(proc *process) (pipe *ptyPipe) {
 }
 
 func (m *multiOutput) Connect(proc *process) {
-	if len(proc.name) > m.maxNameLength {
-		m.maxNameLength = len(proc.name)
-	}
+	m.register(proc.name)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
 	if m.pipes == nil {
 		m.pipes = make(map[*process]*ptyPipe)
@@ -49,6 +128,25 @@ func (m *multiOutput) Connect(proc *process) {
 	m.pipes[proc] = &ptyPipe{}
 }
 
+// register sets up a log ring and aligns the output for a named source of
+// lines, either a process or a Logger.
+func (m *multiOutput) register(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(name) > m.maxNameLength {
+		m.maxNameLength = len(name)
+	}
+
+	if m.rings == nil {
+		m.rings = make(map[string]*logRing)
+	}
+
+	if m.rings[name] == nil {
+		m.rings[name] = &logRing{}
+	}
+}
+
 func (m *multiOutput) PipeOutput(proc *process) {
 	pipe := m.openPipe(proc)
 
@@ -62,37 +160,109 @@ func (m *multiOutput) PipeOutput(proc *process) {
 }
 
 func (m *multiOutput) ClosePipe(proc *process) {
-	if pipe := m.pipes[proc]; pipe != nil {
+	m.mutex.Lock()
+	pipe := m.pipes[proc]
+	m.mutex.Unlock()
+
+	if pipe != nil && pipe.pty != nil {
 		pipe.pty.Close()
 		pipe.tty.Close()
 	}
 }
 
 func (m *multiOutput) WriteLine(proc *process, p []byte) {
+	m.write(proc.name, proc.color, LevelInfo, p)
+}
+
+func (m *multiOutput) WriteErr(proc *process, err error) {
+	m.write(proc.name, proc.color, LevelError, []byte(err.Error()))
+}
+
+func (m *multiOutput) write(name string, color int, level string, p []byte) {
+	line := LogLine{
+		Time:    time.Now().UTC(),
+		Process: name,
+		Level:   level,
+		Message: ansiEscape.ReplaceAllString(string(p), ""),
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	var buf bytes.Buffer
 
-	color := fmt.Sprintf("\033[1;38;5;%vm", proc.color)
+	if m.format == LogFormatJSON {
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(line)
+	} else {
+		m.writeText(&buf, name, color, level, p)
+	}
 
-	buf.WriteString(color)
-	buf.WriteString(proc.name)
+	if ring := m.rings[name]; ring != nil {
+		ring.add(line)
+	}
 
-	for buf.Len()-len(color) < m.maxNameLength {
+	buf.WriteTo(os.Stdout)
+}
+
+func (m *multiOutput) writeText(buf *bytes.Buffer, name string, color int, level string, p []byte) {
+	colorCode := fmt.Sprintf("\033[1;38;5;%vm", color)
+
+	buf.WriteString(colorCode)
+	buf.WriteString(name)
+
+	for buf.Len()-len(colorCode) < m.maxNameLength {
 		buf.WriteByte(' ')
 	}
 
 	buf.WriteString("\033[0m | ")
 
-	buf.Write(p)
+	if level == LevelError {
+		buf.WriteString(fmt.Sprintf("\033[0;31m%s\033[0m", p))
+	} else {
+		buf.Write(p)
+	}
 	buf.WriteByte('\n')
+}
 
+// Tail returns up to the last n lines written by name, or all that are
+// kept if n is 0.
+func (m *multiOutput) Tail(name string, n int) ([]LogLine, bool) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	buf.WriteTo(os.Stdout)
+	ring := m.rings[name]
+	if ring == nil {
+		return nil, false
+	}
+	return ring.tail(n), true
 }
 
-func (m *multiOutput) WriteErr(proc *process, err error) {
-	m.WriteLine(proc, []byte(
-		fmt.Sprintf("\033[0;31m%v\033[0m", err),
-	))
+// Logger writes lines into the supervisor's output under its own name, so
+// they are formatted and kept like process output.
+type Logger struct {
+	output *multiOutput
+	name   string
+	color  int
+}
+
+func (l *Logger) Println(a ...interface{}) {
+	l.output.write(l.name, l.color, LevelInfo, []byte(sprintln(a...)))
+}
+
+func (l *Logger) Printf(format string, a ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintf(format, a...), "\n")
+	l.output.write(l.name, l.color, LevelInfo, []byte(msg))
+}
+
+// Error writes an error level line.
+func (l *Logger) Error(a ...interface{}) {
+	l.output.write(l.name, l.color, LevelError, []byte(sprintln(a...)))
+}
+
+// sprintln formats like fmt.Println, without the trailing newline.
+func sprintln(a ...interface{}) string {
+	s := fmt.Sprintln(a...)
+	return s[:len(s)-1]
 }
@@ -0,0 +1,80 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRing(t *testing.T) {
+	ring := &logRing{}
+	for i := 0; i < ringSize+10; i++ {
+		ring.add(LogLine{Message: fmt.Sprint(i)})
+	}
+
+	lines := ring.tail(3)
+	require.Len(t, lines, 3)
+	assert.Equal(t, fmt.Sprint(ringSize+7), lines[0].Message)
+	assert.Equal(t, fmt.Sprint(ringSize+9), lines[2].Message)
+
+	all := ring.tail(0)
+	require.Len(t, all, ringSize)
+	assert.Equal(t, "10", all[0].Message)
+
+	short := &logRing{}
+	short.add(LogLine{Message: "only"})
+	assert.Len(t, short.tail(100), 1)
+}
+
+func TestParseLogFormat(t *testing.T) {
+	format, err := ParseLogFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, LogFormatText, format)
+
+	format, err = ParseLogFormat("json")
+	require.NoError(t, err)
+	assert.Equal(t, LogFormatJSON, format)
+
+	_, err = ParseLogFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestLogs(t *testing.T) {
+	s := New("test", 0)
+	s.SetLogFormat(LogFormatJSON)
+	s.AddProcess("keeper", "true")
+
+	proc := s.lookup("keeper")
+	proc.writeLine([]byte("\033[1mRunning...\033[0m"))
+	proc.writeErr(fmt.Errorf("exit status 1"))
+
+	logger := s.Logger("start")
+	logger.Printf("configuring %s\n", "operator")
+
+	lines, err := s.Logs("keeper", 0)
+	require.NoError(t, err)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "Running...", lines[0].Message)
+	assert.Equal(t, LevelInfo, lines[0].Level)
+	assert.Equal(t, "exit status 1", lines[1].Message)
+	assert.Equal(t, LevelError, lines[1].Level)
+
+	lines, err = s.Logs("start", 1)
+	require.NoError(t, err)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "configuring operator", lines[0].Message)
+
+	// lines round trip through the json output format
+	b, err := json.Marshal(lines[0])
+	require.NoError(t, err)
+	var line LogLine
+	require.NoError(t, json.Unmarshal(b, &line))
+	assert.Equal(t, "start", line.Process)
+
+	_, err = s.Logs("missing", 10)
+	assert.True(t, errors.Is(err, ErrUnknownProcess))
+}
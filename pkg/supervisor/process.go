@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -11,13 +12,41 @@ import (
 type cmdFactory func() *exec.Cmd
 
 type process struct {
-	name         string
-	color        int
-	output       *multiOutput
-	stopSignal   os.Signal
-	restart      bool
-	restartDelay time.Duration
-	maxRestarts  int
+	name       string
+	color      int
+	output     *multiOutput
+	stopSignal os.Signal
+	// stopTimeout overrides the supervisor's timeout when set.
+	stopTimeout time.Duration
+	restart     bool
+	policy      RestartPolicy
+	dependsOn   []string
+	probe       ReadinessProbe
+
+	// started is closed the first time the process starts, ready once its
+	// readiness probe has passed.
+	started   chan struct{}
+	startOnce sync.Once
+	ready     chan struct{}
+
+	// mu guards the bookkeeping reported by status and the requests made
+	// through the control API.
+	mu        sync.Mutex
+	state     string
+	pid       int
+	startedAt time.Time
+	restarts  int
+	exitCode  *int
+	// crashes counts consecutive exits, reset once the process has run
+	// for the policy's ResetAfter. degraded is set when they reach its
+	// CrashLoopThreshold.
+	crashes  int
+	degraded bool
+	// held is set while the process is stopped through the control API,
+	// restartNow when it is being restarted through it.
+	held       bool
+	restartNow bool
+	wake       chan struct{}
 
 	f       cmdFactory
 	running bool
@@ -42,19 +71,33 @@ func WithStopSignal(sig os.Signal) Opt {
 	}
 }
 
+// WithStopTimeout sets how long the process is given to exit after its stop
+// signal before it is killed.
+func WithStopTimeout(timeout time.Duration) Opt {
+	return func(proc *process) {
+		proc.stopTimeout = timeout
+	}
+}
+
 func WithRootDir(dir string) Opt {
 	return func(proc *process) {
 		proc.dir = dir
 	}
 }
 
-// WithRestart restarts the process if it exists. If limit
-// is 0 it will restart forever.
-func WithRestart(limit int, delay time.Duration) Opt {
+// WithDependencies holds the process back until the named processes are
+// ready. On shutdown it is stopped before them.
+func WithDependencies(names ...string) Opt {
 	return func(proc *process) {
-		proc.restart = true
-		proc.maxRestarts = limit
-		proc.restartDelay = delay
+		proc.dependsOn = append(proc.dependsOn, names...)
+	}
+}
+
+// WithReadiness marks the process ready once probe passes, rather than as
+// soon as it starts.
+func WithReadiness(probe ReadinessProbe) Opt {
+	return func(proc *process) {
+		proc.probe = probe
 	}
 }
 
@@ -67,7 +110,13 @@ func (p *process) writeErr(err error) {
 }
 
 func (p *process) signal(sig os.Signal) {
-	group, err := os.FindProcess(-p.cmd.Process.Pid)
+	pid := p.currentPid()
+
+	if pid == 0 {
+		return
+	}
+
+	group, err := os.FindProcess(-pid)
 	if err != nil {
 		p.writeErr(err)
 		return
@@ -79,7 +128,14 @@ func (p *process) signal(sig os.Signal) {
 }
 
 func (p *process) Running() bool {
-	return p.cmd != nil && p.cmd.Process != nil && p.cmd.ProcessState == nil
+	return p.currentPid() != 0
+}
+
+func (p *process) currentPid() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.pid
 }
 
 func (p *process) Run() {
@@ -95,11 +151,36 @@ func (p *process) Run() {
 
 	p.writeLine([]byte("\033[1mRunning...\033[0m"))
 
-	if err := p.cmd.Run(); err != nil {
+	p.mu.Lock()
+	p.startedAt = time.Now()
+	p.mu.Unlock()
+
+	if err := p.cmd.Start(); err != nil {
+		p.writeErr(err)
+		return
+	}
+
+	p.mu.Lock()
+	p.state = StateRunning
+	p.pid = p.cmd.Process.Pid
+	p.mu.Unlock()
+
+	p.startOnce.Do(func() {
+		close(p.started)
+	})
+
+	err := p.cmd.Wait()
+
+	p.mu.Lock()
+	code := p.cmd.ProcessState.ExitCode()
+	p.exitCode = &code
+	p.pid = 0
+	p.mu.Unlock()
+
+	if err != nil {
 		p.writeErr(err)
 	} else {
-		status := p.cmd.ProcessState.ExitCode()
-		p.writeLine([]byte(fmt.Sprintf("\033[1mProcess exited %d\033[0m", status)))
+		p.writeLine([]byte(fmt.Sprintf("\033[1mProcess exited %d\033[0m", code)))
 	}
 }
 
@@ -0,0 +1,112 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ReadinessProbe reports whether a process is ready to be depended on.
+type ReadinessProbe func(ctx context.Context) error
+
+const (
+	probeInterval = 1 * time.Second
+	probeTimeout  = 5 * time.Second
+)
+
+// TCPProbe passes once addr accepts connections.
+func TCPProbe(addr string) ReadinessProbe {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPProbe passes once a GET to url returns a 2xx status.
+func HTTPProbe(url string) ReadinessProbe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// awaitReady closes proc.ready once the process has started and its probe,
+// if any, passes.
+func awaitReady(ctx context.Context, proc *process) {
+	select {
+	case <-proc.started:
+	case <-ctx.Done():
+		return
+	}
+
+	if proc.probe != nil {
+		var lastErr string
+
+		for {
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			err := proc.probe(probeCtx)
+			cancel()
+
+			if err == nil {
+				break
+			}
+
+			// only log changes so a slow start doesn't flood the output
+			if err.Error() != lastErr {
+				lastErr = err.Error()
+				proc.writeLine([]byte(fmt.Sprintf("not ready: %s", lastErr)))
+			}
+
+			select {
+			case <-time.After(probeInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+
+	proc.writeLine([]byte("\033[1mReady\033[0m"))
+	close(proc.ready)
+}
+
+// awaitDependencies blocks until every dependency of proc is ready.
+func (h *Supervisor) awaitDependencies(ctx context.Context, proc *process) error {
+	for _, name := range proc.dependsOn {
+		dep := h.lookup(name)
+
+		select {
+		case <-dep.ready:
+			continue
+		default:
+		}
+
+		proc.writeLine([]byte(fmt.Sprintf("waiting for %s", name)))
+
+		select {
+		case <-dep.ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
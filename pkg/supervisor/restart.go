@@ -0,0 +1,141 @@
+package supervisor
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RestartPolicy controls how a process is restarted after it exits.
+type RestartPolicy struct {
+	// InitialDelay is the delay before the first restart. It is multiplied
+	// by Multiplier for every consecutive crash, up to MaxDelay.
+	InitialDelay time.Duration
+	// MaxDelay defaults to five minutes.
+	MaxDelay time.Duration
+	// Multiplier defaults to 2.
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction of it, so
+	// processes on different VMs don't restart in lockstep.
+	Jitter float64
+	// ResetAfter is how long a process has to run for its crashes to be
+	// forgotten. Defaults to a minute.
+	ResetAfter time.Duration
+	// CrashLoopThreshold is the number of consecutive crashes after which
+	// the process is marked degraded. It keeps being restarted at
+	// MaxDelay. 0 disables crash-loop detection.
+	CrashLoopThreshold int
+}
+
+const (
+	defaultMultiplier = 2
+	defaultResetAfter = time.Minute
+	defaultMaxDelay   = 5 * time.Minute
+)
+
+// BackoffPolicy is a restart policy suitable for most processes.
+var BackoffPolicy = RestartPolicy{
+	InitialDelay:       1 * time.Second,
+	MaxDelay:           1 * time.Minute,
+	Jitter:             0.2,
+	ResetAfter:         defaultResetAfter,
+	CrashLoopThreshold: 5,
+}
+
+// WithRestartPolicy restarts the process when it exits according to policy.
+func WithRestartPolicy(policy RestartPolicy) Opt {
+	return func(proc *process) {
+		proc.restart = true
+		proc.policy = policy
+	}
+}
+
+// WithRestart restarts the process after a fixed delay when it exits. The
+// process is marked degraded once it has crashed limit times in a row, if
+// limit isn't 0.
+func WithRestart(limit int, delay time.Duration) Opt {
+	return WithRestartPolicy(RestartPolicy{
+		InitialDelay:       delay,
+		MaxDelay:           delay,
+		CrashLoopThreshold: limit,
+	})
+}
+
+func (p RestartPolicy) resetAfter() time.Duration {
+	if p.ResetAfter <= 0 {
+		return defaultResetAfter
+	}
+	return p.ResetAfter
+}
+
+func (p RestartPolicy) maxDelay() time.Duration {
+	if p.MaxDelay <= 0 {
+		return defaultMaxDelay
+	}
+	return p.MaxDelay
+}
+
+func (p RestartPolicy) crashLooping(crashes int) bool {
+	return p.CrashLoopThreshold > 0 && crashes >= p.CrashLoopThreshold
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// delay returns how long to wait before restarting after the given number
+// of consecutive crashes.
+func (p RestartPolicy) delay(crashes int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultMultiplier
+	}
+
+	// The exponent grows with every crash, clamp while it's still a float
+	// as the product can overflow a Duration or become +Inf.
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(crashes-1))
+	if limit := float64(p.maxDelay()); delay > limit || math.IsNaN(delay) {
+		delay = limit
+	}
+
+	if p.Jitter > 0 {
+		jitterMu.Lock()
+		delay += delay * p.Jitter * (2*jitterRand.Float64() - 1)
+		jitterMu.Unlock()
+	}
+
+	return time.Duration(delay)
+}
+
+// recordCrash counts an exit of the process and returns how long to wait
+// before restarting it. A process that keeps crashing is marked degraded
+// rather than given up on.
+func (p *process) recordCrash() time.Duration {
+	p.mu.Lock()
+
+	if time.Since(p.startedAt) >= p.policy.resetAfter() {
+		p.crashes = 0
+		p.degraded = false
+	}
+
+	p.crashes++
+	p.restarts++
+	p.state = StateRestarting
+
+	crashes := p.crashes
+	looping := p.policy.crashLooping(crashes) && !p.degraded
+	if looping {
+		p.degraded = true
+	}
+
+	p.mu.Unlock()
+
+	if looping {
+		p.writeErr(fmt.Errorf("crashed %d times in a row, marking degraded", crashes))
+	}
+
+	return p.policy.delay(crashes)
+}
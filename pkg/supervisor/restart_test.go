@@ -0,0 +1,92 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartPolicyDelay(t *testing.T) {
+	policy := RestartPolicy{InitialDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	assert.Equal(t, 1*time.Second, policy.delay(1))
+	assert.Equal(t, 2*time.Second, policy.delay(2))
+	assert.Equal(t, 8*time.Second, policy.delay(4))
+	assert.Equal(t, 10*time.Second, policy.delay(5))
+	assert.Equal(t, 10*time.Second, policy.delay(50))
+
+	policy.Multiplier = 3
+	assert.Equal(t, 9*time.Second, policy.delay(3))
+
+	unbounded := RestartPolicy{InitialDelay: time.Second}
+	assert.Equal(t, defaultMaxDelay, unbounded.delay(10000))
+
+	fixed := RestartPolicy{InitialDelay: time.Second, MaxDelay: time.Second}
+	assert.Equal(t, time.Second, fixed.delay(10))
+
+	jittered := RestartPolicy{InitialDelay: 10 * time.Second, MaxDelay: 10 * time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		delay := jittered.delay(1)
+		assert.GreaterOrEqual(t, int64(delay), int64(5*time.Second))
+		assert.LessOrEqual(t, int64(delay), int64(15*time.Second))
+	}
+}
+
+func TestCrashLoop(t *testing.T) {
+	s := New("test", time.Second)
+	s.AddProcess("crasher", "false", WithRestartPolicy(RestartPolicy{
+		InitialDelay:       10 * time.Millisecond,
+		MaxDelay:           50 * time.Millisecond,
+		ResetAfter:         time.Minute,
+		CrashLoopThreshold: 3,
+	}))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run()
+	}()
+
+	deadline := time.After(10 * time.Second)
+	for {
+		status, err := s.Process("crasher")
+		require.NoError(t, err)
+		if status.Degraded {
+			assert.GreaterOrEqual(t, status.Crashes, 3)
+			require.NotNil(t, status.LastExitCode)
+			assert.Equal(t, 1, *status.LastExitCode)
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatal("process was never marked degraded")
+		case err := <-done:
+			t.Fatalf("supervisor exited: %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	s.Stop()
+	assert.NoError(t, <-done)
+}
+
+func TestCrashesReset(t *testing.T) {
+	proc := &process{
+		output: &multiOutput{},
+		policy: RestartPolicy{InitialDelay: time.Second, MaxDelay: time.Minute, ResetAfter: time.Minute, CrashLoopThreshold: 2},
+	}
+
+	proc.startedAt = time.Now()
+	assert.Equal(t, time.Second, proc.recordCrash())
+	assert.Equal(t, 2*time.Second, proc.recordCrash())
+	assert.True(t, proc.degraded)
+
+	// a run longer than ResetAfter forgets earlier crashes
+	proc.startedAt = time.Now().Add(-2 * time.Minute)
+	assert.Equal(t, time.Second, proc.recordCrash())
+	assert.False(t, proc.degraded)
+	assert.Equal(t, 1, proc.crashes)
+	assert.Equal(t, 3, proc.restarts)
+}
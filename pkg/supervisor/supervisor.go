@@ -6,40 +6,79 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/fly-examples/postgres-ha/pkg/server"
 	"github.com/google/shlex"
 	"golang.org/x/sync/errgroup"
 )
 
-type processError struct {
-	process *process
-}
-
-func (pe *processError) Error() string {
-	return fmt.Sprintf("process %s failed", pe.process.name)
-}
-
 type Supervisor struct {
 	name    string
 	output  *multiOutput
+	log     *Logger
 	procs   []*process
 	stop    chan struct{}
 	timeout time.Duration
 }
 
+// defaultStopTimeout is used when New is given no timeout.
+const defaultStopTimeout = 5 * time.Second
+
+// killTimeout is how long a process that was sent SIGKILL is waited for.
+const killTimeout = 5 * time.Second
+
+// New returns a supervisor that gives each process timeout to stop before
+// it is killed, unless the process sets its own.
 func New(name string, timeout time.Duration) *Supervisor {
-	return &Supervisor{
-		timeout: 5 * time.Second,
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
+	}
+
+	h := &Supervisor{
+		timeout: timeout,
 		name:    name,
 		output:  &multiOutput{},
 	}
+	h.log = h.Logger(name)
+
+	return h
 }
 
 var colors = []int{2, 3, 4, 5, 6, 42, 130, 103, 129, 108}
 
+// loggerColor sets lines that don't come from a process apart.
+const loggerColor = 245
+
+// SetLogFormat sets the format process output is written in. It should be
+// called before any process is started.
+func (h *Supervisor) SetLogFormat(format LogFormat) {
+	h.output.format = format
+}
+
+// Logger returns a logger whose lines are written alongside process
+// output, under name.
+func (h *Supervisor) Logger(name string) *Logger {
+	h.output.register(name)
+
+	return &Logger{
+		output: h.output,
+		name:   name,
+		color:  loggerColor,
+	}
+}
+
+// Logs returns up to the last tail lines written by the named process or
+// logger, or every line kept if tail is 0.
+func (h *Supervisor) Logs(name string, tail int) ([]LogLine, error) {
+	lines, ok := h.output.Tail(name, tail)
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownProcess, name)
+	}
+	return lines, nil
+}
+
 func (h *Supervisor) AddProcess(name string, command string, opts ...Opt) {
 	proc := &process{
 		name:       name,
@@ -47,6 +86,10 @@ func (h *Supervisor) AddProcess(name string, command string, opts ...Opt) {
 		output:     h.output,
 		stopSignal: syscall.SIGINT,
 		env:        os.Environ(),
+		started:    make(chan struct{}),
+		ready:      make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+		state:      StateWaiting,
 	}
 
 	parsedCmd, err := shlex.Split(command)
@@ -70,10 +113,79 @@ func (h *Supervisor) AddProcess(name string, command string, opts ...Opt) {
 	h.procs = append(h.procs, proc)
 }
 
+func (h *Supervisor) lookup(name string) *process {
+	for _, proc := range h.procs {
+		if proc.name == name {
+			return proc
+		}
+	}
+	return nil
+}
+
+// startOrder sorts processes so each comes after its dependencies, keeping
+// the order they were added in otherwise. Processes are stopped in reverse.
+func (h *Supervisor) startOrder() ([]*process, error) {
+	for _, proc := range h.procs {
+		for _, dep := range proc.dependsOn {
+			if h.lookup(dep) == nil {
+				return nil, fmt.Errorf("process %s depends on unknown process %s", proc.name, dep)
+			}
+		}
+	}
+
+	order := make([]*process, 0, len(h.procs))
+	added := map[string]bool{}
+
+	for len(order) < len(h.procs) {
+		progress := false
+
+		for _, proc := range h.procs {
+			if added[proc.name] {
+				continue
+			}
+
+			satisfied := true
+			for _, dep := range proc.dependsOn {
+				if !added[dep] {
+					satisfied = false
+					break
+				}
+			}
+
+			if satisfied {
+				order = append(order, proc)
+				added[proc.name] = true
+				progress = true
+			}
+		}
+
+		if !progress {
+			var cycle []string
+			for _, proc := range h.procs {
+				if !added[proc.name] {
+					cycle = append(cycle, proc.name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between processes %s", strings.Join(cycle, ", "))
+		}
+	}
+
+	return order, nil
+}
+
 func (h *Supervisor) runProcess(ctx context.Context, proc *process) error {
-	restarts := 0
+	if err := h.awaitDependencies(ctx, proc); err != nil {
+		// supervisor stopped before the process could start
+		return nil
+	}
+
+	go awaitReady(ctx, proc)
 
 	for {
+		if !proc.awaitStart(ctx) {
+			return nil
+		}
+
 		proc.Run()
 
 		// supervisor is stopping, exit
@@ -81,56 +193,91 @@ func (h *Supervisor) runProcess(ctx context.Context, proc *process) error {
 			return nil
 		}
 
+		// process was stopped or restarted through the control api
+		if proc.controlled() {
+			continue
+		}
+
 		// process is done, exit
 		if !proc.restart {
 			proc.writeLine([]byte("done"))
+			proc.setState(StateExited)
 			return nil
 		}
 
-		// process restart limit reached, crash supervisor
-		if proc.maxRestarts > 0 && restarts >= proc.maxRestarts {
-			proc.writeLine([]byte("restart attempts exhausted, crashing"))
-			return &processError{proc}
-		}
+		delay := proc.recordCrash()
+		proc.writeLine([]byte(fmt.Sprintf("restarting in %s [attempt %d]", delay.Round(time.Millisecond), proc.status().Restarts)))
 
-		restarts++
-		proc.writeLine([]byte(fmt.Sprintf("restarting in %s [attempt %d]", proc.restartDelay, restarts)))
 		select {
-		case <-time.After(proc.restartDelay):
+		case <-time.After(delay):
+		case <-proc.wake:
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
-func (h *Supervisor) waitForTimeoutOrInterrupt() {
-	select {
-	case <-time.After(h.timeout):
-	case <-h.stop:
+// stopTimeout is how long proc is given to stop before it is killed.
+func (h *Supervisor) stopTimeout(proc *process) time.Duration {
+	if proc.stopTimeout > 0 {
+		return proc.stopTimeout
 	}
+	return h.timeout
 }
 
-func (h *Supervisor) waitForExit(ctx context.Context) {
-	<-ctx.Done()
+// waitForStopped waits for the current run of proc to exit. It returns
+// false if it is still running after timeout, or interrupt fires.
+func (h *Supervisor) waitForStopped(proc *process, timeout time.Duration, interrupt <-chan struct{}) bool {
+	deadline := time.After(timeout)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
 
-	fmt.Println("supervisor stopping")
+	// compare pids so a process that was already restarted isn't mistaken
+	// for the one being stopped
+	pid := proc.currentPid()
 
-	for _, proc := range h.procs {
-		go proc.Interrupt()
+	for pid != 0 && proc.currentPid() == pid {
+		select {
+		case <-tick.C:
+		case <-deadline:
+			return false
+		case <-interrupt:
+			return false
+		}
 	}
 
-	h.waitForTimeoutOrInterrupt()
-
-	for _, proc := range h.procs {
-		go proc.Kill()
-	}
+	return true
 }
 
-func (h *Supervisor) StartHttpListener() {
-	go server.StartHttpServer()
+// waitForExit stops processes in reverse start order, so dependents are
+// gone before the processes they rely on. Every process is sent its stop
+// signal and only killed once its own timeout runs out, or a second stop
+// skips its graceful shutdown.
+func (h *Supervisor) waitForExit(ctx context.Context, order []*process) {
+	<-ctx.Done()
+
+	h.log.Println("supervisor stopping")
+
+	for i := len(order) - 1; i >= 0; i-- {
+		proc := order[i]
+
+		proc.Interrupt()
+
+		if h.waitForStopped(proc, h.stopTimeout(proc), h.stop) {
+			continue
+		}
+
+		proc.Kill()
+		h.waitForStopped(proc, killTimeout, nil)
+	}
 }
 
 func (h *Supervisor) Run() error {
+	order, err := h.startOrder()
+	if err != nil {
+		return err
+	}
+
 	h.stop = make(chan struct{})
 
 	ctx := context.Background()
@@ -142,14 +289,14 @@ func (h *Supervisor) Run() error {
 
 	eg, egCtx := errgroup.WithContext(ctx)
 
-	for _, proc := range h.procs {
+	for _, proc := range order {
 		p := proc
 		eg.Go(func() error {
 			return h.runProcess(egCtx, p)
 		})
 	}
 
-	go h.waitForExit(egCtx)
+	go h.waitForExit(egCtx, order)
 
 	return eg.Wait()
 }
@@ -159,12 +306,14 @@ func (h *Supervisor) Stop() {
 }
 
 func (h *Supervisor) StopOnSignal(sigs ...os.Signal) {
-	sigch := make(chan os.Signal)
+	// buffered so a second signal isn't dropped while the first one is
+	// being handled
+	sigch := make(chan os.Signal, 1)
 	signal.Notify(sigch, sigs...)
 
 	go func() {
 		for sig := range sigch {
-			fmt.Printf("Got %s, stopping\n", sig)
+			h.log.Printf("Got %s, stopping", sig)
 			h.Stop()
 		}
 	}()
@@ -0,0 +1,198 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func names(procs []*process) []string {
+	var out []string
+	for _, proc := range procs {
+		out = append(out, proc.name)
+	}
+	return out
+}
+
+func TestStartOrder(t *testing.T) {
+	s := New("test", time.Second)
+	s.AddProcess("proxy", "true", WithDependencies("keeper"))
+	s.AddProcess("sentinel", "true")
+	s.AddProcess("exporter", "true", WithDependencies("keeper", "proxy"))
+	s.AddProcess("keeper", "true")
+
+	order, err := s.startOrder()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sentinel", "keeper", "proxy", "exporter"}, names(order))
+}
+
+func TestStartOrderErrors(t *testing.T) {
+	s := New("test", time.Second)
+	s.AddProcess("a", "true", WithDependencies("b"))
+	s.AddProcess("b", "true", WithDependencies("a"))
+	s.AddProcess("c", "true")
+
+	_, err := s.startOrder()
+	assert.EqualError(t, err, "dependency cycle between processes a, b")
+
+	s = New("test", time.Second)
+	s.AddProcess("a", "true", WithDependencies("missing"))
+
+	_, err = s.startOrder()
+	assert.EqualError(t, err, "process a depends on unknown process missing")
+}
+
+func TestAwaitReady(t *testing.T) {
+	s := New("test", time.Second)
+
+	attempts := 0
+	s.AddProcess("db", "true", WithReadiness(func(ctx context.Context) error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("starting")
+		}
+		return nil
+	}))
+	s.AddProcess("app", "true", WithDependencies("db"))
+
+	db, app := s.lookup("db"), s.lookup("app")
+	close(db.started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go awaitReady(ctx, db)
+
+	require.NoError(t, s.awaitDependencies(ctx, app))
+	assert.Equal(t, 2, attempts)
+}
+
+func TestAwaitDependenciesCancelled(t *testing.T) {
+	s := New("test", time.Second)
+	s.AddProcess("db", "true")
+	s.AddProcess("app", "true", WithDependencies("db"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Error(t, s.awaitDependencies(ctx, s.lookup("app")))
+}
+
+func TestProbes(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+
+	assert.NoError(t, TCPProbe(addr)(ctx))
+	l.Close()
+	assert.Error(t, TCPProbe(addr)(ctx))
+
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	assert.Error(t, HTTPProbe(srv.URL)(ctx))
+	status = http.StatusOK
+	assert.NoError(t, HTTPProbe(srv.URL)(ctx))
+}
+
+func TestProcessControl(t *testing.T) {
+	s := New("test", time.Second)
+	s.AddProcess("sleeper", "sleep 30", WithRestart(0, time.Minute))
+
+	done := make(chan error)
+	go func() {
+		done <- s.Run()
+	}()
+
+	proc := s.lookup("sleeper")
+	s.waitForRunning(proc)
+
+	status, err := s.Process("sleeper")
+	require.NoError(t, err)
+	assert.Equal(t, StateRunning, status.State)
+	assert.NotZero(t, status.PID)
+	pid := status.PID
+
+	// restarting skips the restart delay and isn't counted as a crash
+	status, err = s.RestartProcess("sleeper")
+	require.NoError(t, err)
+	assert.Equal(t, StateRunning, status.State)
+	assert.NotEqual(t, pid, status.PID)
+	assert.Equal(t, 0, status.Restarts)
+	require.NotNil(t, status.LastExitCode)
+
+	status, err = s.StopProcess("sleeper")
+	require.NoError(t, err)
+	assert.Equal(t, StateStopped, status.State)
+	assert.Zero(t, status.PID)
+
+	status, err = s.StartProcess("sleeper")
+	require.NoError(t, err)
+	assert.Equal(t, StateRunning, status.State)
+
+	_, err = s.Process("missing")
+	assert.True(t, errors.Is(err, ErrUnknownProcess))
+
+	statuses := s.Processes()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "sleeper", statuses[0].Name)
+
+	s.Stop()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor did not stop")
+	}
+}
+
+func TestStopAfterKill(t *testing.T) {
+	dir := t.TempDir()
+	stopped := filepath.Join(dir, "stopped")
+
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755))
+		return path
+	}
+
+	s := New("test", 5*time.Second)
+	s.AddProcess("db", script("db", `trap "touch $STOPPED; exit 0" INT; while true; do sleep 0.1; done`),
+		WithEnv(map[string]string{"STOPPED": stopped}))
+	s.AddProcess("proxy", script("proxy", `trap "" INT; sleep 30`),
+		WithDependencies("db"), WithStopTimeout(200*time.Millisecond))
+
+	done := make(chan error)
+	go func() {
+		done <- s.Run()
+	}()
+
+	s.waitForRunning(s.lookup("db"))
+	s.waitForRunning(s.lookup("proxy"))
+
+	s.Stop()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("supervisor did not stop")
+	}
+
+	// the proxy ignoring its stop signal doesn't get db killed
+	_, err := os.Stat(stopped)
+	assert.NoError(t, err)
+}